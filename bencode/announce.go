@@ -15,6 +15,8 @@ type AnnounceMessage struct {
 	Downloaded Integer // 0 - the total amount downloaded so far
 	Left       Integer // the number of bytes left to download
 	Compact    Integer // 1 - whether the peer list should use the compact representation
+	Key        String  // optional, lets the tracker recognize the client when its IP changes
+	Event      String  // optional, "started", "completed" or "stopped"
 }
 
 func (req *AnnounceMessage) URL() (string, error) {
@@ -56,6 +58,12 @@ func (req *AnnounceMessage) URL() (string, error) {
 	q.Set("downloaded", strconv.FormatInt(int64(req.Downloaded), 10))
 	q.Set("left", strconv.FormatInt(int64(req.Left), 10))
 	q.Set("compact", strconv.FormatInt(int64(req.Compact), 10))
+	if req.Key != "" {
+		q.Set("key", string(req.Key))
+	}
+	if req.Event != "" {
+		q.Set("event", string(req.Event))
+	}
 	u.RawQuery = q.Encode()
 
 	return u.String() + "&info_hash=" + encodedHash, nil
@@ -45,6 +45,12 @@ func (dec *Decoder) Decode() (Bencodable, error) {
 	return dec.decodeNext()
 }
 
+// Buffered returns the amount of bytes read from the reader that were not decoded yet, the data
+// after a value starts that many bytes before the position of the reader.
+func (dec *Decoder) Buffered() int {
+	return dec.r.Buffered()
+}
+
 func (dec *Decoder) decodeNext() (Bencodable, error) {
 	b, err := dec.r.Peek(1)
 	if err != nil {
@@ -0,0 +1,299 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MinPieceLength = 16 * 1024
+	MaxPieceLength = 16 * 1024 * 1024
+	// targetPieceCount is the amount of pieces the automatic piece length aims for.
+	targetPieceCount = 1500
+)
+
+// Builder creates a .torrent file from a file or a directory.
+type Builder struct {
+	Path         string     // the file or directory to create the torrent from
+	Announce     string     // the main tracker
+	AnnounceList [][]string // tiers of trackers (BEP 12), optional
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // omitted if zero
+	Private      bool      // BEP 27
+	URLList      []string  // web seeds (BEP 19)
+	Source       string    // stored in the info dictionary, changes the info hash
+	PieceLength  int64     // chosen automatically if zero
+	Workers      int       // the amount of goroutines hashing pieces, runtime.NumCPU() if zero
+}
+
+// NewBuilder returns a builder for the path with the defaults filled in.
+func NewBuilder(path string) *Builder {
+	return &Builder{
+		Path:         path,
+		CreatedBy:    "gobittorrent",
+		CreationDate: time.Now(),
+	}
+}
+
+// builderFile is a file on disk and its place in the torrent.
+type builderFile struct {
+	fullPath string
+	path     []string
+	length   int64
+}
+
+// Build walks the path, hashes the pieces and returns the metainfo dictionary.
+func (b *Builder) Build() (Dictionary, error) {
+	stat, err := os.Stat(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(b.Path) // "." is named after the directory
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := b.walk(stat)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.length
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w %q", ErrBuildEmpty, b.Path)
+	}
+
+	pieceLength := b.PieceLength
+	if pieceLength == 0 {
+		pieceLength = ChoosePieceLength(total)
+	}
+	if pieceLength <= 0 || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("%w: %d is not a power of two", ErrBuildPieceLength, pieceLength)
+	}
+
+	pieces, err := b.hashPieces(files, total, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	info := Dictionary{
+		"name":         String(filepath.Base(abs)),
+		"piece length": Integer(pieceLength),
+		"pieces":       String(pieces),
+	}
+	if stat.IsDir() {
+		list := make(List, 0, len(files))
+		for _, f := range files {
+			path := make(List, 0, len(f.path))
+			for _, component := range f.path {
+				path = append(path, String(component))
+			}
+			list = append(list, Dictionary{"length": Integer(f.length), "path": path})
+		}
+		info["files"] = list
+	} else {
+		info["length"] = Integer(total)
+	}
+	if b.Private {
+		info["private"] = Integer(1)
+	}
+	if b.Source != "" {
+		info["source"] = String(b.Source)
+	}
+
+	metainfo := Dictionary{"info": info}
+	if b.Announce != "" {
+		metainfo["announce"] = String(b.Announce)
+	}
+	if len(b.AnnounceList) > 0 {
+		tiers := make(List, 0, len(b.AnnounceList))
+		for _, tier := range b.AnnounceList {
+			trackers := make(List, 0, len(tier))
+			for _, tracker := range tier {
+				trackers = append(trackers, String(tracker))
+			}
+			tiers = append(tiers, trackers)
+		}
+		metainfo["announce-list"] = tiers
+	}
+	if b.Comment != "" {
+		metainfo["comment"] = String(b.Comment)
+	}
+	if b.CreatedBy != "" {
+		metainfo["created by"] = String(b.CreatedBy)
+	}
+	if !b.CreationDate.IsZero() {
+		metainfo["creation date"] = Integer(b.CreationDate.Unix())
+	}
+	if len(b.URLList) > 0 {
+		urls := make(List, 0, len(b.URLList))
+		for _, u := range b.URLList {
+			urls = append(urls, String(u))
+		}
+		metainfo["url-list"] = urls
+	}
+
+	return metainfo, nil
+}
+
+// WriteTo builds the torrent and writes the bencoded result to w.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	metainfo, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := metainfo.Encode()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(encoded)
+	return int64(n), err
+}
+
+// walk lists the regular files of the torrent in a stable (lexical) order.
+func (b *Builder) walk(stat fs.FileInfo) ([]builderFile, error) {
+	if !stat.IsDir() {
+		return []builderFile{{fullPath: b.Path, length: stat.Size()}}, nil
+	}
+
+	files := make([]builderFile, 0)
+	err := filepath.WalkDir(b.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.Path, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, builderFile{
+			fullPath: path,
+			path:     strings.Split(filepath.ToSlash(rel), "/"),
+			length:   info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// hashPieces hashes the pieces in parallel and returns the concatenated SHA-1 sums.
+func (b *Builder) hashPieces(files []builderFile, total, pieceLength int64) ([]byte, error) {
+	var (
+		count   = int((total + pieceLength - 1) / pieceLength)
+		pieces  = make([]byte, count*sha1.Size)
+		jobs    = make(chan int)
+		workers = b.Workers
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		hashErr error
+		quitch  = make(chan struct{})
+	)
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for i := range jobs {
+				offset := int64(i) * pieceLength
+				data := buf[:min(pieceLength, total-offset)]
+				if err := readFilesAt(files, data, offset); err != nil {
+					errOnce.Do(func() {
+						hashErr = err
+						close(quitch)
+					})
+					return
+				}
+				sum := sha1.Sum(data)
+				copy(pieces[i*sha1.Size:], sum[:])
+			}
+		}()
+	}
+
+loop:
+	for i := range count {
+		select {
+		case jobs <- i:
+		case <-quitch:
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if hashErr != nil {
+		return nil, hashErr
+	}
+	return pieces, nil
+}
+
+// readFilesAt fills buf with the data at offset of the files laid out one after another.
+func readFilesAt(files []builderFile, buf []byte, offset int64) error {
+	var fileStart int64
+	for _, f := range files {
+		if len(buf) == 0 {
+			return nil
+		}
+		fileEnd := fileStart + f.length
+		if offset < fileEnd {
+			n := min(fileEnd-offset, int64(len(buf)))
+			if err := readFileAt(f.fullPath, buf[:n], offset-fileStart); err != nil {
+				return err
+			}
+			buf = buf[n:]
+			offset += n
+		}
+		fileStart = fileEnd
+	}
+	if len(buf) != 0 {
+		return fmt.Errorf("%w: files changed while hashing", ErrBencodeReadFile)
+	}
+	return nil
+}
+
+func readFileAt(path string, buf []byte, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w %q, because: %w", ErrBencodeOpenFile, path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return fmt.Errorf("%w %q, because: %w", ErrBencodeReadFile, path, err)
+	}
+	return nil
+}
+
+// ChoosePieceLength picks a power of two piece length that keeps the piece count around targetPieceCount.
+func ChoosePieceLength(total int64) int64 {
+	pieceLength := int64(MinPieceLength)
+	for pieceLength < MaxPieceLength && total/pieceLength > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
@@ -0,0 +1,130 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChoosePieceLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int64
+		want  int64
+	}{
+		{name: "Tiny", total: 1000, want: MinPieceLength},
+		{name: "100 MiB", total: 100 << 20, want: 128 << 10},
+		{name: "4 GiB", total: 4 << 30, want: 4 << 20},
+		{name: "Huge", total: 1 << 50, want: MaxPieceLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChoosePieceLength(tt.total); got != tt.want {
+				t.Errorf("ChoosePieceLength(%d) = %d, want %d", tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "release")
+	files := []struct {
+		path string
+		data []byte
+	}{
+		{path: "a.txt", data: bytes.Repeat([]byte("a"), 10)},
+		{path: "bin/app", data: bytes.Repeat([]byte("b"), 40000)},
+		{path: "bin/lib.so", data: bytes.Repeat([]byte("c"), 30000)},
+	}
+	var data []byte
+	for _, f := range files {
+		full := filepath.Join(root, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, f.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, f.data...)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		data       []byte
+		wantFiles  []FileEntry
+		wantLength Integer
+	}{
+		{
+			name: "Directory",
+			path: root,
+			data: data,
+			wantFiles: []FileEntry{
+				{Length: 10, Path: []String{"a.txt"}},
+				{Length: 40000, Path: []String{"bin", "app"}},
+				{Length: 30000, Path: []String{"bin", "lib.so"}},
+			},
+			wantLength: 70010,
+		},
+		{
+			name:       "Single file",
+			path:       filepath.Join(root, "bin", "app"),
+			data:       files[1].data,
+			wantLength: 40000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(tt.path)
+			b.Announce = "http://tracker/announce"
+			b.AnnounceList = [][]string{{"http://tracker/announce"}, {"http://backup/announce"}}
+			b.URLList = []string{"http://mirror/"}
+			b.CreationDate = time.Unix(1700000000, 0)
+			b.Private = true
+			b.Source = "CI"
+			b.PieceLength = MinPieceLength
+			b.Workers = 3
+
+			var buf bytes.Buffer
+			if _, err := b.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+
+			torrent, err := NewTorrent(&buf)
+			if err != nil {
+				t.Fatalf("NewTorrent() error = %v", err)
+			}
+
+			info := torrent.File.Info
+			if !reflect.DeepEqual(info.Files, tt.wantFiles) {
+				t.Errorf("Files = %v, want %v", info.Files, tt.wantFiles)
+			}
+			if info.Length != tt.wantLength {
+				t.Errorf("Length = %d, want %d", info.Length, tt.wantLength)
+			}
+			if info.Name != String(filepath.Base(tt.path)) {
+				t.Errorf("Name = %q, want %q", info.Name, filepath.Base(tt.path))
+			}
+			if !reflect.DeepEqual(torrent.File.URLList, []String{"http://mirror/"}) {
+				t.Errorf("URLList = %v, want %v", torrent.File.URLList, b.URLList)
+			}
+
+			var wantHashes []string
+			for i := 0; i < len(tt.data); i += MinPieceLength {
+				sum := sha1.Sum(tt.data[i:min(i+MinPieceLength, len(tt.data))])
+				wantHashes = append(wantHashes, hex.EncodeToString(sum[:]))
+			}
+			if !reflect.DeepEqual(info.PieceHashes, wantHashes) {
+				t.Errorf("PieceHashes = %v, want %v", info.PieceHashes, wantHashes)
+			}
+		})
+	}
+}
@@ -9,9 +9,13 @@ var (
 	ErrBencodeInfoHash    = errors.New("bencode: failed to bencode info_hash")
 	ErrBencodeOpenFile    = errors.New("bencode: failed to open the file")
 	ErrBencodeReadFile    = errors.New("bencode: failed to read the file")
+	ErrBuildEmpty         = errors.New("bencode: there is no data to create the torrent from")
+	ErrBuildPieceLength   = errors.New("bencode: invalid piece length")
 	ErrConvertDecoded     = errors.New("bencode: failed to convert decoded values to a map")
 	ErrDecodeAnnounceBody = errors.New("bencode: failed to decode the announce body")
 	ErrGetAnnounce        = errors.New("bencode: failed to GET the announce")
+	ErrInvalidMagnet      = errors.New("bencode: invalid magnet link")
+	ErrInvalidPieceLayer  = errors.New("bencode: invalid piece layer")
 	ErrMarshal            = errors.New("bencode: failed to marshal a value")
 	ErrParseAnnounceURL   = errors.New("bencode: failed to parse the announce url")
 	ErrParsePeer          = errors.New("bencode: failed to parse peer")
@@ -0,0 +1,54 @@
+package bencode
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Magnet is a magnet link (BEP 9), it names the torrent by its info hash without the metadata.
+type Magnet struct {
+	InfoHash [20]byte
+	Name     string   // the display name, "dn"
+	Trackers []string // "tr"
+}
+
+// ParseMagnet parses a "magnet:?xt=urn:btih:..." link, the info hash is in hex or base32.
+func ParseMagnet(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidMagnet, err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("%w, the scheme is %q", ErrInvalidMagnet, u.Scheme)
+	}
+
+	query := u.Query()
+	m := &Magnet{Name: query.Get("dn"), Trackers: query["tr"]}
+
+	for _, xt := range query["xt"] {
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+
+		var decoded []byte
+		switch len(hash) {
+		case 40:
+			decoded, err = hex.DecodeString(hash)
+		case 32:
+			decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("the info hash %q is not 40 hex or 32 base32 characters", hash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w, %w", ErrInvalidMagnet, err)
+		}
+		copy(m.InfoHash[:], decoded)
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("%w, there is no urn:btih info hash", ErrInvalidMagnet)
+}
@@ -0,0 +1,49 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	hash := [20]byte{0xd6, 0x9f, 0x91, 0xe6, 0xb2, 0xae, 0x4c, 0x54, 0x24, 0x68, 0xd1, 0x07, 0x3a, 0x71, 0xd4, 0xea, 0x13, 0x87, 0x9a, 0x7f}
+
+	tests := []struct {
+		name    string
+		uri     string
+		want    *Magnet
+		wantErr bool
+	}{
+		{
+			name: "Hex info hash",
+			uri:  "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&dn=sample.txt&tr=http%3A%2F%2Ftracker%2Fannounce&tr=udp%3A%2F%2Fother%3A80",
+			want: &Magnet{InfoHash: hash, Name: "sample.txt", Trackers: []string{"http://tracker/announce", "udp://other:80"}},
+		},
+		{
+			name: "Base32 info hash",
+			uri:  "magnet:?xt=urn:btih:22PZDZVSVZGFIJDI2EDTU4OU5IJYPGT7",
+			want: &Magnet{InfoHash: hash},
+		},
+		{name: "Not a magnet link", uri: "http://example.com/?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f", wantErr: true},
+		{name: "No info hash", uri: "magnet:?dn=sample.txt", wantErr: true},
+		{name: "Short info hash", uri: "magnet:?xt=urn:btih:d69f91", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.uri)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMagnet) {
+					t.Errorf("ParseMagnet() error = %v, want %v", err, ErrInvalidMagnet)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMagnet() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMagnet() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
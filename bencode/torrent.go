@@ -3,9 +3,11 @@ package bencode
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // Torrent is a structure that describes the .torrent file and related actions to it.
@@ -15,18 +17,43 @@ type Torrent struct {
 
 // File is the contents of the file itself.
 type File struct {
-	Announce    String
-	CreatedBy   String
-	Info        Info
-	InfoHashSum [20]byte
+	Announce     String
+	AnnounceList [][]String // tiers of trackers (BEP 12), nil if the torrent only has "announce"
+	CreatedBy    String
+	URLList      []String // web seeds (BEP 19)
+	Info         Info
+	InfoHashSum  [20]byte
+	InfoHashV2   [32]byte                // SHA-256 of the info dictionary, only set for v2 torrents
+	PieceLayers  map[[32]byte][][32]byte // pieces root - piece layer hashes (BEP 52)
 }
 
 type Info struct {
 	Name        String
 	Pieces      []byte
 	PieceHashes []string
-	Length      Integer
+	Length      Integer // the total length of all the files
 	PieceLength Integer
+	Files       []FileEntry // nil for single-file torrents
+	MetaVersion Integer     // 2 for v2 and hybrid torrents
+	FileTree    []V2File    // the flattened v2 "file tree"
+	Private     bool        // BEP 27, peers may only come from the torrent's trackers
+}
+
+// FileEntry is a single file of a multi-file torrent.
+type FileEntry struct {
+	Length Integer
+	Path   []String // path components relative to the torrent directory (Info.Name)
+	Attr   String   // BEP 47 attributes, "p" marks the padding files of hybrid torrents
+}
+
+// IsPadding reports whether the file only exists to align the next file to a piece boundary.
+func (f *FileEntry) IsPadding() bool {
+	return strings.ContainsRune(string(f.Attr), 'p')
+}
+
+// IsMultiFile reports whether the torrent describes a directory of files.
+func (info *Info) IsMultiFile() bool {
+	return info.Files != nil
 }
 
 func NewTorrent(r io.Reader) (*Torrent, error) {
@@ -59,17 +86,19 @@ func decodeTorrent(values any) (*Torrent, error) {
 	if !ok {
 		return nil, fmt.Errorf("%w, values (%q)", ErrConvertDecoded, valuesMap)
 	}
+	announceList, err := decodeAnnounceList(valuesMap)
+	if err != nil {
+		return nil, err
+	}
 	announce, ok := valuesMap["announce"].(String)
-	if !ok {
+	if _, found := valuesMap["announce"]; found && !ok {
 		return nil, ConvertError{ValueName: "announce", WantedType: "String"}
-	}
-	createdBy, ok := valuesMap["created by"].(String)
-	if !ok {
-		return nil, ConvertError{ValueName: "created by", WantedType: "String"}
-	}
-	length, ok := infoMap["length"].(Integer)
-	if !ok {
-		return nil, ConvertError{ValueName: "length", WantedType: "Integer"}
+	} // Missing in trackerless torrents, their peers come from the web seeds.
+	private, _ := infoMap["private"].(Integer)
+	createdBy, _ := valuesMap["created by"].(String) // optional
+	urlList, err := decodeURLList(valuesMap)
+	if err != nil {
+		return nil, err
 	}
 	name, ok := infoMap["name"].(String)
 	if !ok {
@@ -79,28 +108,72 @@ func decodeTorrent(values any) (*Torrent, error) {
 	if !ok {
 		return nil, ConvertError{ValueName: "piece length", WantedType: "Integer"}
 	}
-	pieces, ok := infoMap["pieces"].(String)
-	if !ok {
+	metaVersion, _ := infoMap["meta version"].(Integer)
+	pieces, isV1 := infoMap["pieces"].(String)
+	if !isV1 && metaVersion != MetaVersion2 {
 		return nil, ConvertError{ValueName: "pieces", WantedType: "String"}
 	}
 
+	var (
+		files    []FileEntry
+		length   Integer
+		fileTree []V2File
+		layers   map[[32]byte][][32]byte
+	)
+	if isV1 {
+		files, length, err = decodeFiles(infoMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if metaVersion == MetaVersion2 {
+		if pieceLength < BlockSize || pieceLength&(pieceLength-1) != 0 {
+			return nil, fmt.Errorf("%w: %d is not a power of two of at least %d", ErrBuildPieceLength, pieceLength, BlockSize)
+		}
+		tree, ok := infoMap["file tree"].(Dictionary)
+		if !ok {
+			return nil, ConvertError{ValueName: "file tree", WantedType: "Dictionary"}
+		}
+		fileTree, err = decodeFileTree(tree, nil)
+		if err != nil {
+			return nil, err
+		}
+		layers, err = decodePieceLayers(valuesMap, fileTree, pieceLength)
+		if err != nil {
+			return nil, err
+		}
+		if !isV1 {
+			files, length = v2Files(name, fileTree)
+		}
+	}
+
 	encoded, err := infoMap.Encode()
 	if err != nil {
 		return nil, fmt.Errorf("%w, because: %w", ErrBencodeInfoHash, err)
 	}
 
 	torrent.File = File{
-		Announce:    announce,
-		CreatedBy:   createdBy,
-		InfoHashSum: sha1.Sum(encoded),
+		Announce:     announce,
+		AnnounceList: announceList,
+		CreatedBy:    createdBy,
+		URLList:      urlList,
+		InfoHashSum:  sha1.Sum(encoded),
+		PieceLayers:  layers,
 		Info: Info{
 			Length:      length,
+			Files:       files,
 			Name:        name,
 			PieceLength: pieceLength,
 			Pieces:      append(make([]byte, 0), pieces...),
 			PieceHashes: make([]string, 0),
+			MetaVersion: metaVersion,
+			FileTree:    fileTree,
+			Private:     private == 1,
 		},
 	}
+	if metaVersion == MetaVersion2 {
+		torrent.File.InfoHashV2 = sha256.Sum256(encoded)
+	}
 
 	// Encode pieces
 	start := 0
@@ -114,3 +187,127 @@ func decodeTorrent(values any) (*Torrent, error) {
 
 	return torrent, nil
 }
+
+// decodeFiles returns the file list and the total length, the list is nil for single-file torrents.
+func decodeFiles(infoMap Dictionary) ([]FileEntry, Integer, error) {
+	if length, ok := infoMap["length"].(Integer); ok {
+		return nil, length, nil
+	}
+
+	filesList, ok := infoMap["files"].(List)
+	if !ok {
+		return nil, 0, ConvertError{ValueName: "length", WantedType: "Integer"}
+	}
+
+	var (
+		files = make([]FileEntry, 0, len(filesList))
+		total Integer
+	)
+	for _, value := range filesList {
+		fileMap, ok := value.(Dictionary)
+		if !ok {
+			return nil, 0, ConvertError{ValueName: "files", WantedType: "Dictionary"}
+		}
+		length, ok := fileMap["length"].(Integer)
+		if !ok {
+			return nil, 0, ConvertError{ValueName: "files.length", WantedType: "Integer"}
+		}
+		pathList, ok := fileMap["path"].(List)
+		if !ok || len(pathList) == 0 {
+			return nil, 0, ConvertError{ValueName: "files.path", WantedType: "List"}
+		}
+
+		path := make([]String, 0, len(pathList))
+		for _, p := range pathList {
+			component, ok := p.(String)
+			if !ok {
+				return nil, 0, ConvertError{ValueName: "files.path", WantedType: "String"}
+			}
+			path = append(path, component)
+		}
+
+		attr, _ := fileMap["attr"].(String)
+
+		files = append(files, FileEntry{Length: length, Path: path, Attr: attr})
+		total += length
+	}
+
+	return files, total, nil
+}
+
+// Trackers returns the tiers of trackers, the announce-list takes precedence over announce (BEP 12).
+func (f *File) Trackers() [][]String {
+	if len(f.AnnounceList) > 0 {
+		tiers := make([][]String, 0, len(f.AnnounceList))
+		for _, tier := range f.AnnounceList {
+			tiers = append(tiers, append([]String(nil), tier...))
+		}
+		return tiers
+	}
+	if f.Announce == "" {
+		return nil
+	}
+	return [][]String{{f.Announce}}
+}
+
+// decodeAnnounceList returns the non-empty tiers of the "announce-list".
+func decodeAnnounceList(valuesMap Dictionary) ([][]String, error) {
+	value, ok := valuesMap["announce-list"]
+	if !ok {
+		return nil, nil
+	}
+	tiersList, ok := value.(List)
+	if !ok {
+		return nil, ConvertError{ValueName: "announce-list", WantedType: "List"}
+	}
+
+	tiers := make([][]String, 0, len(tiersList))
+	for _, t := range tiersList {
+		trackersList, ok := t.(List)
+		if !ok {
+			return nil, ConvertError{ValueName: "announce-list", WantedType: "List"}
+		}
+		tier := make([]String, 0, len(trackersList))
+		for _, tracker := range trackersList {
+			u, ok := tracker.(String)
+			if !ok {
+				return nil, ConvertError{ValueName: "announce-list", WantedType: "String"}
+			}
+			if u != "" {
+				tier = append(tier, u)
+			}
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	return tiers, nil
+}
+
+// decodeURLList returns the web seeds, "url-list" is either a single string or a list of them.
+func decodeURLList(valuesMap Dictionary) ([]String, error) {
+	switch v := valuesMap["url-list"].(type) {
+	case nil:
+		return nil, nil
+	case String:
+		if v == "" {
+			return nil, nil
+		}
+		return []String{v}, nil
+	case List:
+		urls := make([]String, 0, len(v))
+		for _, item := range v {
+			u, ok := item.(String)
+			if !ok {
+				return nil, ConvertError{ValueName: "url-list", WantedType: "String"}
+			}
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		return urls, nil
+	default:
+		return nil, ConvertError{ValueName: "url-list", WantedType: "List"}
+	}
+}
@@ -0,0 +1,161 @@
+package bencode
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewTorrent(t *testing.T) {
+	f, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	torrent, err := NewTorrent(f)
+	if err != nil {
+		t.Fatalf("NewTorrent() error = %v", err)
+	}
+
+	if torrent.File.Info.Length != 92063 {
+		t.Errorf("Length = %d, want %d", torrent.File.Info.Length, 92063)
+	}
+	if torrent.File.Info.IsMultiFile() {
+		t.Error("IsMultiFile() = true, want false")
+	}
+	if len(torrent.File.Info.PieceHashes) != 3 {
+		t.Errorf("len(PieceHashes) = %d, want %d", len(torrent.File.Info.PieceHashes), 3)
+	}
+}
+
+func TestDecodeTorrentFilesAndURLList(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlList     Bencodable
+		wantURLList []String
+	}{
+		{name: "Single URL", urlList: String("http://mirror/"), wantURLList: []String{"http://mirror/"}},
+		{name: "URL list", urlList: List{String("http://a/"), String("http://b/")}, wantURLList: []String{"http://a/", "http://b/"}},
+		{name: "Empty URL", urlList: String(""), wantURLList: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := Dictionary{
+				"announce":   String("http://tracker/announce"),
+				"created by": String("test"),
+				"url-list":   tt.urlList,
+				"info": Dictionary{
+					"name":         String("dir"),
+					"piece length": Integer(16384),
+					"pieces":       String(bytes.Repeat([]byte{1}, 20)),
+					"files": List{
+						Dictionary{"length": Integer(10), "path": List{String("a.txt")}},
+						Dictionary{"length": Integer(20), "path": List{String("sub"), String("b.txt")}},
+					},
+				},
+			}.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			torrent, err := NewTorrent(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("NewTorrent() error = %v", err)
+			}
+
+			wantFiles := []FileEntry{
+				{Length: 10, Path: []String{"a.txt"}},
+				{Length: 20, Path: []String{"sub", "b.txt"}},
+			}
+			if !reflect.DeepEqual(torrent.File.Info.Files, wantFiles) {
+				t.Errorf("Files = %v, want %v", torrent.File.Info.Files, wantFiles)
+			}
+			if torrent.File.Info.Length != 30 {
+				t.Errorf("Length = %d, want %d", torrent.File.Info.Length, 30)
+			}
+			if !reflect.DeepEqual(torrent.File.URLList, tt.wantURLList) {
+				t.Errorf("URLList = %v, want %v", torrent.File.URLList, tt.wantURLList)
+			}
+		})
+	}
+}
+
+func TestDecodeTorrentV2(t *testing.T) {
+	const pieceLength = BlockSize
+	data := bytes.Repeat([]byte("x"), 3*pieceLength)
+
+	var layer [][32]byte
+	var encodedLayer []byte
+	for i := 0; i < len(data); i += pieceLength {
+		h := MerkleRoot(BlockHashes(data[i:i+pieceLength]), 1)
+		layer = append(layer, h)
+		encodedLayer = append(encodedLayer, h[:]...)
+	}
+	root := PieceLayerRoot(layer, pieceLength)
+	small := MerkleRoot(BlockHashes([]byte("small")), 1)
+
+	tests := []struct {
+		name    string
+		layers  Dictionary
+		wantErr bool
+	}{
+		{name: "Valid", layers: Dictionary{String(root[:]): String(encodedLayer)}},
+		{name: "Missing piece layers", layers: Dictionary{}, wantErr: true},
+		{name: "Corrupt piece layer", layers: Dictionary{String(root[:]): String(bytes.Repeat([]byte{1}, 96))}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := Dictionary{
+				"announce": String("http://tracker/announce"),
+				"info": Dictionary{
+					"name":         String("dir"),
+					"piece length": Integer(pieceLength),
+					"meta version": Integer(MetaVersion2),
+					"file tree": Dictionary{
+						"big.bin": Dictionary{"": Dictionary{"length": Integer(len(data)), "pieces root": String(root[:])}},
+						"sub": Dictionary{
+							"small.txt": Dictionary{"": Dictionary{"length": Integer(5), "pieces root": String(small[:])}},
+						},
+					},
+				},
+				"piece layers": tt.layers,
+			}.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			torrent, err := NewTorrent(bytes.NewReader(encoded))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTorrent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			f := torrent.File
+			if !f.IsV2() || f.IsV1() || f.IsHybrid() {
+				t.Errorf("IsV2() = %v, IsV1() = %v, IsHybrid() = %v, want a v2 only torrent", f.IsV2(), f.IsV1(), f.IsHybrid())
+			}
+			wantTree := []V2File{
+				{Path: []String{"big.bin"}, Length: Integer(len(data)), PiecesRoot: root},
+				{Path: []String{"sub", "small.txt"}, Length: 5, PiecesRoot: small},
+			}
+			if !reflect.DeepEqual(f.Info.FileTree, wantTree) {
+				t.Errorf("FileTree = %v, want %v", f.Info.FileTree, wantTree)
+			}
+			if f.Info.Length != Integer(len(data)+5) {
+				t.Errorf("Length = %d, want %d", f.Info.Length, len(data)+5)
+			}
+			if h := f.ProtocolInfoHash(); !bytes.Equal(h[:], f.InfoHashV2[:20]) {
+				t.Errorf("ProtocolInfoHash() = %x, want the truncated v2 info hash %x", h, f.InfoHashV2[:20])
+			}
+			if _, ok := f.PieceLayer(root); ok != (len(tt.layers) > 0) {
+				t.Errorf("PieceLayer() ok = %v, want %v", ok, len(tt.layers) > 0)
+			}
+		})
+	}
+}
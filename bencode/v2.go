@@ -0,0 +1,212 @@
+package bencode
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+const (
+	// BlockSize is the size of the leaves of the v2 merkle trees.
+	BlockSize = 16 * 1024
+	// MetaVersion2 is the "meta version" of BitTorrent v2 torrents (BEP 52).
+	MetaVersion2 = 2
+)
+
+// V2File is a single file from the v2 "file tree".
+type V2File struct {
+	Path       []String // path components relative to the torrent directory (Info.Name)
+	Length     Integer
+	PiecesRoot [32]byte // the root of the file's merkle tree, zero for empty files
+}
+
+// IsV1 reports whether the torrent has v1 piece hashes.
+func (f *File) IsV1() bool {
+	return len(f.Info.Pieces) > 0
+}
+
+// IsV2 reports whether the torrent has the v2 file tree.
+func (f *File) IsV2() bool {
+	return f.Info.MetaVersion == MetaVersion2
+}
+
+// IsHybrid reports whether the torrent can be used by both v1 and v2 clients.
+func (f *File) IsHybrid() bool {
+	return f.IsV1() && f.IsV2()
+}
+
+// ProtocolInfoHash returns the 20 byte info hash used in handshakes and announces,
+// which is the v1 info hash if there is one, and the truncated v2 info hash otherwise.
+func (f *File) ProtocolInfoHash() [20]byte {
+	if f.IsV1() || !f.IsV2() {
+		return f.InfoHashSum
+	}
+	var h [20]byte
+	copy(h[:], f.InfoHashV2[:])
+	return h
+}
+
+// PieceLayer returns the piece layer hashes of the file with the given root, if the file has more than one piece.
+func (f *File) PieceLayer(root [32]byte) ([][32]byte, bool) {
+	layer, ok := f.PieceLayers[root]
+	return layer, ok
+}
+
+// decodeFileTree flattens the nested "file tree" dictionary, files are ordered by their path.
+func decodeFileTree(tree Dictionary, prefix []String) ([]V2File, error) {
+	keys := make([]String, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	files := make([]V2File, 0, len(keys))
+	for _, key := range keys {
+		node, ok := tree[key].(Dictionary)
+		if !ok {
+			return nil, ConvertError{ValueName: "file tree", WantedType: "Dictionary"}
+		}
+
+		if key == "" { // A file, the key of its properties is an empty string.
+			length, ok := node["length"].(Integer)
+			if !ok {
+				return nil, ConvertError{ValueName: "file tree.length", WantedType: "Integer"}
+			}
+			file := V2File{Path: append([]String(nil), prefix...), Length: length}
+			if length > 0 {
+				root, ok := node["pieces root"].(String)
+				if !ok || len(root) != 32 {
+					return nil, ConvertError{ValueName: "file tree.pieces root", WantedType: "String"}
+				}
+				copy(file.PiecesRoot[:], root)
+			}
+			files = append(files, file)
+			continue
+		}
+
+		children, err := decodeFileTree(node, append(prefix[:len(prefix):len(prefix)], key))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, children...)
+	}
+
+	return files, nil
+}
+
+// decodePieceLayers reads the "piece layers" and checks them against the roots of the files, every
+// file of more than one piece needs its layer since the hashes are never requested from peers.
+func decodePieceLayers(valuesMap Dictionary, files []V2File, pieceLength Integer) (map[[32]byte][][32]byte, error) {
+	layersMap, _ := valuesMap["piece layers"].(Dictionary)
+	layers := make(map[[32]byte][][32]byte)
+
+	for _, file := range files {
+		if file.Length <= pieceLength {
+			continue // The pieces root is the only hash of such files.
+		}
+
+		value, ok := layersMap[String(file.PiecesRoot[:])].(String)
+		if !ok {
+			return nil, fmt.Errorf("%w, file %v has none", ErrInvalidPieceLayer, file.Path)
+		}
+		if len(value)%32 != 0 || Integer(len(value)/32) != (file.Length+pieceLength-1)/pieceLength {
+			return nil, fmt.Errorf("%w, file %v has %d bytes of hashes", ErrInvalidPieceLayer, file.Path, len(value))
+		}
+
+		layer := make([][32]byte, len(value)/32)
+		for i := range layer {
+			copy(layer[i][:], value[i*32:])
+		}
+		if PieceLayerRoot(layer, int(pieceLength)) != file.PiecesRoot {
+			return nil, fmt.Errorf("%w, file %v does not match its pieces root", ErrInvalidPieceLayer, file.Path)
+		}
+		layers[file.PiecesRoot] = layer
+	}
+
+	return layers, nil
+}
+
+// v2Files converts the file tree into the v1 shaped file list, nil for a single file.
+func v2Files(name String, tree []V2File) ([]FileEntry, Integer) {
+	var total Integer
+	for _, f := range tree {
+		total += f.Length
+	}
+	if len(tree) == 1 && len(tree[0].Path) == 1 && tree[0].Path[0] == name {
+		return nil, total
+	}
+
+	files := make([]FileEntry, 0, len(tree))
+	for _, f := range tree {
+		files = append(files, FileEntry{Length: f.Length, Path: f.Path})
+	}
+	return files, total
+}
+
+// MerkleRoot reduces the leaves to the root of the tree, padding them with zero hashes up to count,
+// which has to be a power of two.
+func MerkleRoot(leaves [][32]byte, count int) [32]byte {
+	return merkleRoot(leaves, count, [32]byte{})
+}
+
+func merkleRoot(leaves [][32]byte, count int, pad [32]byte) [32]byte {
+	if count <= 1 {
+		if len(leaves) == 0 {
+			return pad
+		}
+		return leaves[0]
+	}
+
+	layer := make([][32]byte, count)
+	n := copy(layer, leaves)
+	for i := n; i < count; i++ {
+		layer[i] = pad
+	}
+
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// PieceLayerRoot computes the pieces root from a piece layer, the padding entries are
+// the roots of subtrees that consist only of zero leaves.
+func PieceLayerRoot(layer [][32]byte, pieceLength int) [32]byte {
+	return merkleRoot(layer, NextPowerOfTwo(len(layer)), PadHash(pieceLength/BlockSize))
+}
+
+// PadHash returns the root of a tree of the given amount of zero leaves.
+func PadHash(leaves int) [32]byte {
+	var h [32]byte
+	for ; leaves > 1; leaves /= 2 {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+// BlockHashes splits the data into blocks and hashes them, the last block may be shorter.
+func BlockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for i := 0; i < len(data); i += BlockSize {
+		hashes = append(hashes, sha256.Sum256(data[i:min(i+BlockSize, len(data))]))
+	}
+	return hashes
+}
+
+func NextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+func hashPair(a, b [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], a[:])
+	copy(buf[32:], b[:])
+	return sha256.Sum256(buf[:])
+}
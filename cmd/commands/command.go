@@ -0,0 +1,243 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/handsomefox/gobittorrent/config"
+)
+
+// The exit codes of Main.
+const (
+	ExitOK    = 0
+	ExitError = 1 // the command failed
+	ExitUsage = 2 // the command or its arguments are wrong
+	// ExitMismatch is the code of verify when the data does not match the torrent.
+	ExitMismatch = 3
+	// ExitInterrupted is the code of the commands stopped by a signal, like shells report SIGINT.
+	ExitInterrupted = 130
+)
+
+// RunFunc runs a command. It defines its flags on fs, parses the arguments with them and returns
+// the output.
+type RunFunc func(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error)
+
+// Command is a subcommand of gobittorrent.
+type Command struct {
+	Name    string
+	Args    string // the arguments after the flags, "<.torrent file> <peer>"
+	Summary string
+	Run     RunFunc
+	// NoConfig commands don't read the config, they run with the defaults when it can't be loaded.
+	NoConfig bool
+}
+
+// Commands are the subcommands in the order the help lists them.
+var Commands = []Command{
+	{Name: "decode", Args: "<string>", Summary: "decodes a bencoded string and outputs it as json", Run: Decode, NoConfig: true},
+	{Name: "info", Args: "<.torrent file>", Summary: "shows the decoded representation of the .torrent file", Run: Info, NoConfig: true},
+	{Name: "peers", Args: "<.torrent file>", Summary: "shows the available peers for the given .torrent file", Run: Peers},
+	{
+		Name:    "handshake",
+		Args:    "<.torrent file> <peer>",
+		Summary: `does the handshake with the given peer, which is a string that looks like: "host:port", and shows its peer ID and client`,
+		Run:     Handshake,
+	},
+	{Name: "files", Args: "<.torrent file>", Summary: "lists the file tree of the .torrent file", Run: Files, NoConfig: true},
+	{
+		Name:    "download",
+		Args:    "<.torrent file> [output file or directory]",
+		Summary: "downloads a single-file torrent to the specified file, or to the download directory, showing the progress",
+		Run:     Download,
+	},
+	{
+		Name:    "serve",
+		Args:    "<.torrent file>",
+		Summary: "downloads the torrent and serves its files over HTTP with Range support while they are downloaded",
+		Run:     Serve,
+	},
+	{
+		Name:    "tui",
+		Args:    "[.torrent files...]",
+		Summary: "downloads the torrents and shows them in a full-screen view with their peers, trackers and files",
+		Run:     Tui,
+	},
+	{
+		Name:    "daemon",
+		Args:    "[.torrent files...]",
+		Summary: "downloads the torrents in the background and serves the JSON HTTP API that controls them",
+		Run:     Daemon,
+	},
+	{
+		Name:    "remote",
+		Args:    "<session|list|info|add|pause|resume|remove|limits|sequential|peers|trackers|files> [argument]",
+		Summary: "runs a command on the API of a daemon",
+		Run:     Remote,
+	},
+	{Name: "create", Args: "<file or directory>", Summary: "creates a .torrent file", Run: Create, NoConfig: true},
+	{
+		Name:     "verify",
+		Args:     "<.torrent file> <file or directory>",
+		Summary:  "checks the data on disk against the torrent and reports the complete, corrupt and missing pieces and files",
+		Run:      Verify,
+		NoConfig: true,
+	},
+	{Name: "config", Args: "show", Summary: "shows the effective config: the config file, the environment and the flags", Run: Config},
+}
+
+// Lookup returns the command with the name.
+func Lookup(name string) (Command, bool) {
+	for _, cmd := range Commands {
+		if cmd.Name == strings.ToLower(name) {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// Main runs the command named by the first argument, writes its output to stdout and returns
+// the exit code. usage is the help of the whole program. The config is loaded once the command is
+// known, the help and the NoConfig commands run without it when it can't be loaded.
+func Main(load func() (*config.Config, error), args []string, stdout, stderr io.Writer, usage string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if cmd, ok := Lookup(args[1]); ok {
+				cmd.Usage(stdout)
+				return ExitOK
+			}
+		}
+		fmt.Fprintln(stdout, usage)
+		return ExitOK
+	}
+
+	cmd, ok := Lookup(args[0])
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q, run \"gobittorrent help\" to see the commands\n", args[0])
+		return ExitUsage
+	}
+
+	cfg, err := load()
+	switch {
+	case err == nil:
+	case helpRequested(args[1:]):
+		cmd.Usage(stdout)
+		return ExitOK
+	case cmd.NoConfig:
+		slog.Warn("Failed to read the config, using the defaults", "err", err)
+		cfg = config.Default()
+	default:
+		slog.Error("Failed to read the config", "command", cmd.Name, "err", err)
+		return ExitError
+	}
+
+	fs := cmd.flagSet()
+	out, err := cmd.Run(fs, cfg, args[1:])
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		cmd.usage(stdout, fs)
+		return ExitOK
+	case errors.Is(err, ErrInvalidArguments):
+		fmt.Fprintf(stderr, "%v\n\n", err)
+		cmd.usage(stderr, fs)
+		return ExitUsage
+	case errors.Is(err, ErrMismatch):
+		fmt.Fprintln(stdout, out) // The report of what does not match.
+		fmt.Fprintln(stderr, err)
+		return ExitMismatch
+	case errors.Is(err, ErrInterrupted):
+		fmt.Fprintln(stderr, err)
+		return ExitInterrupted
+	case err != nil:
+		slog.Error("Failed to run the command", "command", cmd.Name, "err", err)
+		return ExitError
+	}
+
+	fmt.Fprintln(stdout, out)
+	return ExitOK
+}
+
+// helpRequested reports whether the arguments of a command ask for its help.
+func helpRequested(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "-help", "--h", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// Usage writes the help of the command with its flags.
+func (cmd Command) Usage(w io.Writer) {
+	fs := cmd.flagSet()
+	_, _ = cmd.Run(fs, config.Default(), []string{"-help"}) // defines the flags and stops at -help
+	cmd.usage(w, fs)
+}
+
+func (cmd Command) usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: gobittorrent %s", cmd.Name)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprint(w, " [flags]")
+	}
+	fmt.Fprintf(w, " %s\n\n%s\n", cmd.Args, cmd.Summary)
+
+	if hasFlags {
+		fmt.Fprint(w, "\nFlags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// flagSet returns the flag set of a run, Main reports its errors and prints the help.
+func (cmd Command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parseArgs parses the flags and checks that between min and max arguments follow them.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w, %w", ErrInvalidArguments, err)
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		if min == max {
+			return nil, fmt.Errorf("%w, wrong number of arguments, want %d, got %d", ErrInvalidArguments, min, fs.NArg())
+		}
+		return nil, fmt.Errorf("%w, wrong number of arguments, want %d to %d, got %d", ErrInvalidArguments, min, max, fs.NArg())
+	}
+	return fs.Args(), nil
+}
+
+// jsonFlag defines the --json flag of the commands that can print JSON.
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "print the output as JSON")
+}
+
+// marshal returns the indented JSON of v.
+func marshal(v any) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/config"
+)
+
+// defaultConfig loads the defaults for Main.
+func defaultConfig() (*config.Config, error) {
+	return config.Default(), nil
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(file, bytes.Repeat([]byte("gobittorrent"), 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	torrent := filepath.Join(dir, "file.torrent")
+	trackerless := filepath.Join(dir, "trackerless.torrent")
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string // a substring of the output
+		wantStderr string
+	}{
+		{name: "No command", args: nil, wantCode: ExitUsage, wantStderr: "usage"},
+		{name: "Help", args: []string{"help"}, wantCode: ExitOK, wantStdout: "usage"},
+		{name: "Command help", args: []string{"info", "--help"}, wantCode: ExitOK, wantStdout: "-json"},
+		{name: "Help command", args: []string{"help", "create"}, wantCode: ExitOK, wantStdout: "-piece-length"},
+		{name: "Unknown command", args: []string{"seed"}, wantCode: ExitUsage, wantStderr: `unknown command "seed"`},
+		{name: "Missing argument", args: []string{"decode"}, wantCode: ExitUsage, wantStderr: "Usage: gobittorrent decode <string>"},
+		{name: "Unknown flag", args: []string{"info", "-x", torrent}, wantCode: ExitUsage, wantStderr: "-x"},
+		{name: "Failure", args: []string{"decode", "5:hi"}, wantCode: ExitError},
+		{name: "Decode", args: []string{"decode", "5:hello"}, wantCode: ExitOK, wantStdout: `"hello"`},
+		{name: "Create", args: []string{"create", "-tracker", "http://tracker/announce", "-o", torrent, file}, wantCode: ExitOK, wantStdout: "Created"},
+		{name: "Info", args: []string{"info", torrent}, wantCode: ExitOK, wantStdout: "Tracker URL: http://tracker/announce"},
+		{name: "Create without trackers", args: []string{"create", "-web-seed", "http://mirror/", "-o", trackerless, file}, wantCode: ExitOK, wantStdout: "Created"},
+		{name: "Info without trackers", args: []string{"info", trackerless}, wantCode: ExitOK, wantStdout: "Length: 120000"},
+		{name: "Invalid config flag", args: []string{"config", "show", "-encryption", "sometimes"}, wantCode: ExitUsage, wantStderr: "encryption"},
+		{name: "Unsupported encryption", args: []string{"config", "show", "-encryption", "required"}, wantCode: ExitUsage, wantStderr: "not supported"},
+		{name: "Unsupported DHT", args: []string{"config", "show", "-dht"}, wantCode: ExitUsage, wantStderr: "dht"},
+		{name: "Config show", args: []string{"config", "show", "-port", "7000"}, wantCode: ExitOK, wantStdout: "port = 7000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := Main(defaultConfig, tt.args, &stdout, &stderr, "usage")
+			if code != tt.wantCode {
+				t.Errorf("Main() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout.String(), tt.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+
+	var stdout bytes.Buffer
+	if code := Main(defaultConfig, []string{"info", "--json", torrent}, &stdout, os.Stderr, "usage"); code != ExitOK {
+		t.Fatalf("Main() = %d, want %d", code, ExitOK)
+	}
+	var info torrentInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("info --json is not JSON: %v", err)
+	}
+	if info.Tracker != "http://tracker/announce" || info.Length != 120000 || len(info.PieceHashes) == 0 {
+		t.Errorf("info --json = %+v", info)
+	}
+}
+
+func TestBrokenConfig(t *testing.T) {
+	broken := func() (*config.Config, error) { return nil, config.ErrInvalidConfig }
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+	}{
+		{name: "Help", args: []string{"help"}, wantCode: ExitOK, wantStdout: "usage"},
+		{name: "Help command", args: []string{"help", "download"}, wantCode: ExitOK, wantStdout: "-download-dir"},
+		{name: "Command help", args: []string{"download", "-help"}, wantCode: ExitOK, wantStdout: "-download-dir"},
+		{name: "Without config", args: []string{"decode", "5:hello"}, wantCode: ExitOK, wantStdout: `"hello"`},
+		{name: "With config", args: []string{"config", "show"}, wantCode: ExitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if code := Main(broken, tt.args, &stdout, io.Discard, "usage"); code != tt.wantCode {
+				t.Errorf("Main() = %d, want %d", code, tt.wantCode)
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout.String(), tt.wantStdout)
+			}
+		})
+	}
+}
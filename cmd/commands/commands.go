@@ -1,56 +1,29 @@
 package commands
 
 import (
-	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"log/slog"
 	"os"
-	"runtime"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
 	"github.com/handsomefox/gobittorrent/p2p"
 )
 
-type (
-	CommandFunc  func(string) (string, error)
-	CommandFunc2 func(string, string) (string, error)
-)
-
-func RunCommand(f CommandFunc) {
-	if len(os.Args) < 2 {
-		slog.Error("Invalid argument count", "want", 2, "got", len(os.Args))
-		return
-	}
-
-	r, err := f(os.Args[2])
-	if err != nil {
-		slog.Error("Failed to run the command", "err", err)
-	} else {
-		fmt.Println(r)
-	}
-}
-
-func RunCommand2(f CommandFunc2) {
-	if len(os.Args) < 3 {
-		slog.Error("Invalid argument count", "want", 3, "got", len(os.Args))
-		return
-	}
-
-	r, err := f(os.Args[2], os.Args[3])
+// Decode returns the JSON representation of the decoded value.
+func Decode(fs *flag.FlagSet, _ *config.Config, args []string) (string, error) {
+	args, err := parseArgs(fs, args, 1, 1)
 	if err != nil {
-		slog.Error("Failed to run the command", "err", err)
-	} else {
-		fmt.Println(r)
+		return "", err
 	}
-}
 
-// Decode returns the JSON representation of the decoded value.
-func Decode(encodedValue string) (string, error) {
-	decoded, err := bencode.NewDecoder(strings.NewReader((encodedValue))).Decode()
+	decoded, err := bencode.NewDecoder(strings.NewReader(args[0])).Decode()
 	if err != nil {
 		return "", err
 	}
@@ -63,55 +36,118 @@ func Decode(encodedValue string) (string, error) {
 	return string(b), nil
 }
 
-func Peers(path string) (string, error) {
-	f, err := os.Open(path)
+// peerInfo is a peer in the JSON output of peers.
+type peerInfo struct {
+	Addr   string          `json:"addr"`
+	Client *p2p.PeerClient `json:"client,omitempty"` // nil if we did not connect to the peer
+}
+
+// Peers lists the peers of the torrent and the clients of the ones we connected to.
+func Peers(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	asJSON := jsonFlag(fs)
+	args, err := parseConfigFlags(fs, cfg, args, 1, 1)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
 
-	torrent, err := bencode.NewTorrent(f)
+	torrent, err := openTorrent(args[0])
 	if err != nil {
 		return "", err
 	}
 
-	client, err := p2p.NewClient(slog.Default(), []byte("00112233445566778899"), torrent)
+	ctx, stop := notifyContext()
+	defer stop()
+
+	client, err := newClient(ctx, cfg, torrent)
 	if err != nil {
-		return "", err
+		return "", interrupted(ctx, err)
 	}
-	defer client.Close()
+	defer shutdown(client)
 
-	peers, err := client.DiscoverPeers(context.Background())
+	peers, err := client.DiscoverPeers(ctx)
 	if err != nil {
-		return "", err
+		return "", interrupted(ctx, err)
+	}
+
+	clients := make(map[string]p2p.PeerClient)
+	for _, conn := range client.Connections() {
+		clients[conn.Addr()] = conn.Client()
+	}
+
+	if *asJSON {
+		list := make([]peerInfo, 0, len(peers))
+		for _, peer := range peers {
+			info := peerInfo{Addr: peer.Addr()}
+			if pc, ok := clients[peer.Addr()]; ok {
+				info.Client = &pc
+			}
+			list = append(list, info)
+		}
+		return marshal(list)
 	}
 
 	output := ""
 	for _, peer := range peers {
-		output += peer.Addr() + "\n"
+		output += peer.Addr()
+		if pc, ok := clients[peer.Addr()]; ok {
+			output += " " + pc.String()
+		}
+		output += "\n"
 	}
 
 	return output, nil
 }
 
-func Info(path string) (string, error) {
-	f, err := os.Open(path)
+// torrentInfo is the JSON output of info.
+type torrentInfo struct {
+	Tracker     string   `json:"tracker"`
+	Length      int64    `json:"length"`
+	InfoHash    string   `json:"info_hash"`
+	InfoHashV2  string   `json:"info_hash_v2,omitempty"`
+	Private     bool     `json:"private"`
+	PieceLength int64    `json:"piece_length"`
+	PieceHashes []string `json:"piece_hashes"`
+}
+
+func Info(fs *flag.FlagSet, _ *config.Config, args []string) (string, error) {
+	asJSON := jsonFlag(fs)
+	args, err := parseArgs(fs, args, 1, 1)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
 
-	torrent, err := bencode.NewTorrent(f)
+	torrent, err := openTorrent(args[0])
 	if err != nil {
 		return "", err
 	}
 
-	s := fmt.Sprintf("Tracker URL: %s\nLength: %d\nInfo Hash: %s\nPiece Length: %d\nPiece Hashes:\n",
+	if *asJSON {
+		info := torrentInfo{
+			Tracker:     string(torrent.File.Announce),
+			Length:      int64(torrent.File.Info.Length),
+			InfoHash:    hex.EncodeToString(torrent.File.InfoHashSum[:]),
+			Private:     torrent.File.Info.Private,
+			PieceLength: int64(torrent.File.Info.PieceLength),
+			PieceHashes: torrent.File.Info.PieceHashes,
+		}
+		if torrent.File.IsV2() {
+			info.InfoHashV2 = hex.EncodeToString(torrent.File.InfoHashV2[:])
+		}
+		return marshal(info)
+	}
+
+	s := fmt.Sprintf("Tracker URL: %s\nLength: %d\nInfo Hash: %s\n",
 		torrent.File.Announce,
 		torrent.File.Info.Length,
 		hex.EncodeToString(torrent.File.InfoHashSum[:]),
-		torrent.File.Info.PieceLength,
 	)
+	if torrent.File.Info.Private {
+		s += "Private: yes\n"
+	}
+	if torrent.File.IsV2() {
+		s += fmt.Sprintf("Info Hash v2: %s\n", hex.EncodeToString(torrent.File.InfoHashV2[:]))
+	}
+	s += fmt.Sprintf("Piece Length: %d\nPiece Hashes:\n", torrent.File.Info.PieceLength)
 
 	for i, h := range torrent.File.Info.PieceHashes {
 		s += h
@@ -123,29 +159,45 @@ func Info(path string) (string, error) {
 	return s, nil
 }
 
-var ErrPeerNotFound = errors.New("commands: peer not found")
+var (
+	ErrPeerNotFound     = errors.New("commands: peer not found")
+	ErrInvalidArguments = errors.New("commands: invalid arguments")
+	ErrNotTerminal      = errors.New("commands: the standard input and output must be a terminal")
+	ErrInterrupted      = errors.New("commands: interrupted")
+	ErrMismatch         = errors.New("commands: the data does not match the torrent")
+)
+
+// handshakeInfo is the JSON output of handshake.
+type handshakeInfo struct {
+	PeerID string         `json:"peer_id"`
+	Client p2p.PeerClient `json:"client"`
+}
 
-func Handshake(path, addr string) (string, error) {
-	f, err := os.Open(path)
+func Handshake(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	asJSON := jsonFlag(fs)
+	args, err := parseConfigFlags(fs, cfg, args, 2, 2)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
+	addr := args[1]
 
-	torrent, err := bencode.NewTorrent(f)
+	torrent, err := openTorrent(args[0])
 	if err != nil {
 		return "", err
 	}
 
-	client, err := p2p.NewClient(slog.Default(), []byte("00112233445566778899"), torrent)
+	ctx, stop := notifyContext()
+	defer stop()
+
+	client, err := newClient(ctx, cfg, torrent)
 	if err != nil {
-		return "", err
+		return "", interrupted(ctx, err)
 	}
-	defer client.Close()
+	defer shutdown(client)
 
-	peers, err := client.DiscoverPeers(context.Background())
+	peers, err := client.DiscoverPeers(ctx)
 	if err != nil {
-		return "", err
+		return "", interrupted(ctx, err)
 	}
 
 	var peer bencode.Peer
@@ -160,47 +212,80 @@ func Handshake(path, addr string) (string, error) {
 	}
 
 	for !client.HasConnection(peer.Addr()) {
-		runtime.Gosched()
+		select {
+		case <-ctx.Done():
+			return "", interrupted(ctx, ctx.Err())
+		case <-time.After(time.Millisecond * 10):
+		}
 	}
 
 	conns := client.Connections()
 
-	id := "not found"
+	info := handshakeInfo{PeerID: "not found", Client: p2p.PeerClient{Name: "unknown"}}
 	for _, conn := range conns {
 		if conn.Addr() == peer.Addr() {
-			id = conn.PeerID()
+			info = handshakeInfo{PeerID: conn.PeerID(), Client: conn.Client()}
 		}
 	}
 
-	return "Peer ID: " + id, nil
+	if *asJSON {
+		return marshal(info)
+	}
+	return "Peer ID: " + info.PeerID + "\nClient: " + info.Client.String(), nil
 }
 
-func Download(torrentPath, outputPath string) (string, error) {
-	torrentFile, err := os.Open(torrentPath)
+// Download saves a single-file torrent to the output file, or to the download directory.
+func Download(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	var (
+		only       stringsFlag
+		noProgress = fs.Bool("no-progress", false, "do not show the progress")
+		sequential = fs.Bool("sequential", false, "download the pieces front to back")
+		window     = fs.Int("window", 0, "the pieces from the first missing one a sequential download requests at once, 0 means no limit")
+	)
+	fs.Var(&only, "only", "download only the files whose path or name matches the glob into the output directory (repeatable)")
+	args, err := parseConfigFlags(fs, cfg, args, 1, 2)
 	if err != nil {
 		return "", err
 	}
-	defer torrentFile.Close()
+	torrentPath := args[0]
 
-	outputFile, err := os.Create(outputPath)
+	torrent, err := openTorrent(torrentPath)
 	if err != nil {
 		return "", err
 	}
-	defer outputFile.Close()
+	if len(only) > 0 {
+		dir := cfg.DownloadDir
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		return downloadFiles(cfg, torrent, torrentPath, dir, only, *noProgress, *sequential, *window)
+	}
+
+	outputPath := filepath.Join(cfg.DownloadDir, filepath.Base(string(torrent.File.Info.Name)))
+	if len(args) == 2 {
+		outputPath = args[1]
+	}
 
-	torrent, err := bencode.NewTorrent(torrentFile)
+	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return "", err
 	}
+	defer outputFile.Close()
+
+	ctx, stopSignals := notifyContext()
+	defer stopSignals()
 
-	client, err := p2p.NewClient(slog.Default(), []byte("00112233445566778899"), torrent)
+	client, err := newClient(ctx, cfg, torrent)
 	if err != nil {
-		return "", err
+		return "", interrupted(ctx, err)
 	}
-	defer client.Close()
+	defer shutdown(client)
+	runSchedule(ctx, cfg, client)
+	client.SetSequential(*sequential, *window)
 
-	if err := client.Download(outputFile); err != nil {
-		return "", err
+	err = withProgress(client, *noProgress, func() error { return client.Download(ctx, outputFile) })
+	if err != nil {
+		return "", interrupted(ctx, err)
 	}
 
 	return "Downloaded " + torrentPath + " to " + outputPath, nil
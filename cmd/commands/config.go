@@ -0,0 +1,184 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/lmittmann/tint"
+)
+
+// NewLogger returns the logger the config asks for, writing to w.
+func NewLogger(w io.Writer, cfg *config.Config) *slog.Logger {
+	level, err := cfg.LogLevel()
+	if err != nil {
+		level = slog.LevelInfo
+	}
+
+	if cfg.Log.Format == config.LogJSON {
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true, Level: level}))
+	}
+	return slog.New(tint.NewHandler(w, &tint.Options{
+		AddSource:  true,
+		Level:      level,
+		TimeFormat: time.Kitchen,
+	}))
+}
+
+// parseConfigFlags parses the config flags of a command along with its own flags and applies the
+// log settings.
+func parseConfigFlags(fs *flag.FlagSet, cfg *config.Config, args []string, min, max int) ([]string, error) {
+	cfg.AddFlags(fs)
+
+	args, err := parseArgs(fs, args, min, max)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidArguments, err)
+	}
+
+	slog.SetDefault(NewLogger(os.Stderr, cfg))
+	return args, nil
+}
+
+// openTorrent reads the .torrent file.
+func openTorrent(path string) (*bencode.Torrent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return bencode.NewTorrent(f)
+}
+
+// ShutdownTimeout is how long the commands wait for the trackers and the running API requests when
+// they stop.
+const ShutdownTimeout = time.Second * 5
+
+// notifyContext returns a context that is canceled on an interrupt or SIGTERM.
+func notifyContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// interrupted wraps the error with ErrInterrupted when it comes from the canceled context.
+func interrupted(ctx context.Context, err error) error {
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w, %w", ErrInterrupted, err)
+	}
+	return err
+}
+
+// shutdown shuts the client or the session down gracefully, waiting up to the ShutdownTimeout.
+func shutdown(s interface{ Shutdown(context.Context) error }) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// newClient starts a client with the port, limits and trackers of the config and the options, the
+// context bounds the first announce.
+func newClient(ctx context.Context, cfg *config.Config, torrent *bencode.Torrent, opts ...p2p.Option) (*p2p.Client, error) {
+	client, err := p2p.NewClient(ctx, slog.Default(), torrent, append(cfg.ClientOptions(), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetDownloadLimit(cfg.DownloadLimit)
+	client.SetUploadLimit(cfg.UploadLimit)
+	if err := client.AddTrackers(cfg.Trackers...); err != nil && !errors.Is(err, p2p.ErrPrivateTorrent) {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// newSession starts a session on the port of the config with its limits.
+func newSession(cfg *config.Config, log *slog.Logger) (*p2p.Session, error) {
+	session, err := p2p.NewSession(log, ":"+strconv.Itoa(cfg.Port), cfg.ClientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMaxConnections(cfg.MaxConnections)
+	session.SetDownloadLimit(cfg.DownloadLimit)
+	session.SetUploadLimit(cfg.UploadLimit)
+	return session, nil
+}
+
+// runSchedule applies the schedule of the config to the session or client until the context is
+// done, when one is configured.
+func runSchedule(ctx context.Context, cfg *config.Config, target p2p.ScheduleTarget) {
+	if !cfg.Schedule.IsZero() {
+		go p2p.NewScheduler(target, cfg.Schedule).Run(ctx)
+	}
+}
+
+// addTorrent adds the torrent to the session with the trackers of the config and downloads it into
+// its files under the download directory in the background.
+func addTorrent(session *p2p.Session, cfg *config.Config, log *slog.Logger, torrent *bencode.Torrent) (*p2p.Client, error) {
+	client, err := session.Add(torrent, p2p.WithStorage(p2p.NewFileStorage(cfg.DownloadDir)))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.AddTrackers(cfg.Trackers...); err != nil && !errors.Is(err, p2p.ErrPrivateTorrent) {
+		session.Remove(client.InfoHash())
+		return nil, err
+	}
+
+	path := filepath.Join(cfg.DownloadDir, string(torrent.File.Info.Name)) // The storage checked the name.
+	go func() {
+		if err := client.Wait(context.Background()); err != nil {
+			if !errors.Is(err, p2p.ErrClientClosed) {
+				log.Error("Failed to download the torrent", "torrent", torrent.File.Info.Name, "err", err)
+			}
+			return
+		}
+		log.Info("Downloaded the torrent", "torrent", torrent.File.Info.Name, "path", path)
+	}()
+
+	return client, nil
+}
+
+// Config runs the config subcommands, "show" prints the effective config.
+func Config(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	show := len(args) > 0 && args[0] == "show"
+	if show {
+		args = args[1:]
+	}
+	if _, err := parseConfigFlags(fs, cfg, args, 0, 0); err != nil {
+		return "", err
+	}
+	if !show {
+		return "", fmt.Errorf("%w, want config show", ErrInvalidArguments)
+	}
+
+	var sb strings.Builder
+	if cfg.Path != "" {
+		fmt.Fprintf(&sb, "# loaded from %s\n", cfg.Path)
+	} else {
+		sb.WriteString("# no config file, searched:\n")
+		for _, path := range config.Paths(os.Getenv) {
+			fmt.Fprintf(&sb, "#   %s\n", path)
+		}
+	}
+	if err := cfg.WriteTOML(&sb); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
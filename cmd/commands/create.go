@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
+)
+
+// stringsFlag is a flag that can be repeated, every occurrence is appended to the list.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Create builds a .torrent file from a file or a directory.
+func Create(fs *flag.FlagSet, _ *config.Config, args []string) (string, error) {
+	var (
+		trackers    stringsFlag
+		webSeeds    stringsFlag
+		output      = fs.String("o", "", "the output .torrent file (default: <name>.torrent)")
+		comment     = fs.String("comment", "", "the comment stored in the torrent")
+		createdBy   = fs.String("created-by", "gobittorrent", "the creator stored in the torrent")
+		noDate      = fs.Bool("no-date", false, "omit the creation date")
+		private     = fs.Bool("private", false, "mark the torrent as private (BEP 27)")
+		source      = fs.String("source", "", "the source tag stored in the info dictionary")
+		pieceLength = fs.Int64("piece-length", 0, "the piece length in bytes, a power of two (default: automatic)")
+		workers     = fs.Int("workers", 0, "the amount of goroutines hashing pieces (default: number of CPUs)")
+	)
+	fs.Var(&trackers, "tracker", "a tracker tier, comma separated trackers are in the same tier (repeatable)")
+	fs.Var(&webSeeds, "web-seed", "a web seed URL (repeatable)")
+
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return "", err
+	}
+	path := args[0]
+
+	b := bencode.NewBuilder(path)
+	b.Comment = *comment
+	b.CreatedBy = *createdBy
+	b.Private = *private
+	b.Source = *source
+	b.PieceLength = *pieceLength
+	b.Workers = *workers
+	b.URLList = webSeeds
+	if *noDate {
+		b.CreationDate = time.Time{}
+	}
+	for _, tier := range trackers {
+		b.AnnounceList = append(b.AnnounceList, strings.Split(tier, ","))
+	}
+	if len(b.AnnounceList) > 0 {
+		b.Announce = b.AnnounceList[0][0]
+	}
+	if len(b.AnnounceList) == 1 && len(b.AnnounceList[0]) == 1 {
+		b.AnnounceList = nil // announce alone says the same
+	}
+
+	if *output == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		*output = filepath.Base(abs) + ".torrent"
+	}
+
+	// Built before the output is created, so it is not one of the files when it is in the path.
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return "", err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return "", err
+	}
+
+	return "Created " + *output + " from " + path, nil
+}
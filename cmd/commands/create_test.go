@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateCurrentDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "release")
+	files := map[string][]byte{"a.bin": bytes.Repeat([]byte("a"), 20000), "dir/b.bin": bytes.Repeat([]byte("b"), 30000)}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if code := Main(defaultConfig, []string{"create", "-web-seed", "http://mirror/", "."}, io.Discard, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("create . = %d, want %d", code, ExitOK)
+	}
+	torrent, err := openTorrent("release.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, files := torrent.File.Info.Name, torrent.File.Info.Files; name != "release" || len(files) != 2 {
+		t.Errorf("create . named the torrent %q with the files %v, want release with a.bin and dir/b.bin", name, files)
+	}
+
+	if code := Main(defaultConfig, []string{"verify", "release.torrent", "."}, io.Discard, io.Discard, "usage"); code != ExitOK {
+		t.Errorf("verify = %d, want %d", code, ExitOK)
+	}
+}
+
+func TestCreateFailure(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "empty.torrent")
+	if code := Main(defaultConfig, []string{"create", "-o", output, t.TempDir()}, io.Discard, io.Discard, "usage"); code != ExitError {
+		t.Errorf("create of an empty directory = %d, want %d", code, ExitError)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("create left %s behind: %v", output, err)
+	}
+}
@@ -0,0 +1,262 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/daemon"
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/handsomefox/gobittorrent/tui"
+)
+
+// Daemon runs a session with the API until it is interrupted, the torrents in the arguments are
+// added on start.
+func Daemon(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	torrentPaths, err := parseConfigFlags(fs, cfg, args, 0, math.MaxInt)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Daemon.Token == "" {
+		return "", fmt.Errorf("%w, the daemon needs an API token, set daemon.token in the config or GOBITTORRENT_DAEMON_TOKEN", ErrInvalidArguments)
+	}
+
+	ctx, stop := notifyContext()
+	defer stop()
+
+	log := slog.Default()
+	session, err := newSession(cfg, log)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	runSchedule(ctx, cfg, session)
+
+	add := func(torrent *bencode.Torrent) (*p2p.Client, error) { return addTorrent(session, cfg, log, torrent) }
+	for _, path := range torrentPaths {
+		torrent, err := openTorrent(path)
+		if err != nil {
+			return "", err
+		}
+		if _, err := add(torrent); err != nil {
+			return "", err
+		}
+	}
+
+	api := daemon.NewServer(log, session, cfg.Daemon.Token, add)
+	api.DownloadDir = cfg.DownloadDir
+	srv := &http.Server{
+		Addr:              cfg.Daemon.Listen,
+		Handler:           api,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	errch := make(chan error, 1)
+	go func() { errch <- srv.ListenAndServe() }()
+	log.Info("Started the daemon", "api", cfg.Daemon.Listen, "port", session.Port(), "torrents", len(torrentPaths))
+
+	select {
+	case err := <-errch:
+		return "", err
+	case <-ctx.Done():
+	}
+
+	if err := shutdown(srv); err != nil {
+		return "", err
+	}
+	if err := shutdown(session); err != nil {
+		log.Warn("Failed to stop the torrents gracefully", "err", err)
+	}
+	return "Stopped the daemon", nil
+}
+
+// The subcommands of remote and their arguments.
+var remoteCommands = []string{
+	"session", "list", "info <info hash>", "add <.torrent file or magnet link>", "pause <info hash>", "resume <info hash>",
+	"remove <info hash>", "limits [info hash]", "sequential <info hash>", "peers <info hash>", "trackers <info hash>", "files <info hash>",
+}
+
+// Remote runs a subcommand on the API of a daemon.
+func Remote(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	var (
+		asJSON      = jsonFlag(fs)
+		down        = fs.Int("down", 0, "limits: the download limit in bytes per second, 0 means no limit")
+		up          = fs.Int("up", 0, "limits: the upload limit in bytes per second, 0 means no limit")
+		connections = fs.Int("connections", 0, "limits: the connections of the session, 0 means no limit")
+		window      = fs.Int("window", 0, "sequential: the pieces from the first missing one requested at once, 0 means no limit")
+		off         = fs.Bool("off", false, "sequential: switch the sequential download off")
+	)
+
+	var subcommand string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand, args = args[0], args[1:]
+	}
+	args, err := parseConfigFlags(fs, cfg, args, 0, 1)
+	if err != nil {
+		return "", err
+	}
+
+	client := daemon.NewClient(cfg.Daemon.Listen, cfg.Daemon.Token)
+	ctx := context.Background()
+
+	var hash string
+	switch subcommand {
+	case "session", "list":
+		if len(args) != 0 {
+			return "", fmt.Errorf("%w, %s takes no arguments", ErrInvalidArguments, subcommand)
+		}
+	case "limits":
+		if len(args) == 1 {
+			hash = args[0]
+		}
+	case "info", "add", "pause", "resume", "remove", "sequential", "peers", "trackers", "files":
+		if len(args) != 1 {
+			return "", fmt.Errorf("%w, %s needs an argument", ErrInvalidArguments, subcommand)
+		}
+		hash = args[0]
+	default:
+		return "", fmt.Errorf("%w, unknown remote command %q, want one of: %s", ErrInvalidArguments, subcommand, strings.Join(remoteCommands, ", "))
+	}
+
+	var v any
+	switch subcommand {
+	case "session":
+		v, err = client.Session(ctx)
+	case "list":
+		v, err = client.Torrents(ctx)
+	case "info":
+		v, err = client.Torrent(ctx, hash)
+	case "add":
+		v, err = remoteAdd(ctx, client, args[0])
+	case "pause":
+		v, err = client.Pause(ctx, hash)
+	case "resume":
+		v, err = client.Resume(ctx, hash)
+	case "remove":
+		if err := client.Remove(ctx, hash); err != nil {
+			return "", err
+		}
+		return "Removed " + hash, nil
+	case "limits":
+		var limits daemon.Limits
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "down":
+				limits.DownloadLimit = down
+			case "up":
+				limits.UploadLimit = up
+			case "connections":
+				limits.MaxConnections = connections
+			}
+		})
+		if hash == "" {
+			v, err = client.SetSessionLimits(ctx, limits)
+		} else {
+			v, err = client.SetLimits(ctx, hash, limits)
+		}
+	case "sequential":
+		v, err = client.SetSequential(ctx, hash, daemon.SequentialRequest{Enabled: !*off, Window: *window})
+	case "peers":
+		v, err = client.Peers(ctx, hash)
+	case "trackers":
+		v, err = client.Trackers(ctx, hash)
+	case "files":
+		v, err = client.Files(ctx, hash)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if *asJSON {
+		return marshal(v)
+	}
+	return formatRemote(v), nil
+}
+
+// remoteAdd adds a magnet link or the contents of a .torrent file.
+func remoteAdd(ctx context.Context, client *daemon.Client, arg string) (*daemon.Torrent, error) {
+	if strings.HasPrefix(arg, "magnet:") {
+		return client.AddMagnet(ctx, arg)
+	}
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	return client.Add(ctx, data)
+}
+
+// formatRemote formats the responses of the API as tables.
+func formatRemote(v any) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+
+	torrentRow := func(t *daemon.Torrent) {
+		eta := "-"
+		if t.ETA >= 0 {
+			eta = tui.FormatETA(time.Duration(t.ETA) * time.Second)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1f%%\t%s\t%s\t%d (%d)\t%s\n", t.InfoHash, t.Name, t.Status, t.Progress*100,
+			tui.FormatRate(t.DownloadRate), tui.FormatRate(t.UploadRate), t.Peers, t.Seeds, eta)
+	}
+	const torrentHeader = "INFO HASH\tNAME\tSTATUS\tDONE\tDOWN\tUP\tPEERS\tETA\n"
+
+	switch v := v.(type) {
+	case *daemon.Session:
+		fmt.Fprintf(w, "Port:\t%d\n", v.Port)
+		fmt.Fprintf(w, "Torrents:\t%d\n", v.Torrents)
+		fmt.Fprintf(w, "Connections:\t%d (max %d)\n", v.Connections, v.MaxConnections)
+		fmt.Fprintf(w, "Download:\t%s (limit %d)\n", tui.FormatRate(v.DownloadRate), v.DownloadLimit)
+		fmt.Fprintf(w, "Upload:\t%s (limit %d)\n", tui.FormatRate(v.UploadRate), v.UploadLimit)
+	case []daemon.Torrent:
+		fmt.Fprint(w, torrentHeader)
+		for i := range v {
+			torrentRow(&v[i])
+		}
+	case *daemon.Torrent:
+		fmt.Fprint(w, torrentHeader)
+		torrentRow(v)
+	case *daemon.Details:
+		fmt.Fprint(w, torrentHeader)
+		torrentRow(&v.Torrent)
+		fmt.Fprintf(w, "\nPiece length:\t%d\n", v.PieceLength)
+		fmt.Fprintf(w, "Private:\t%t\n", v.Private)
+		fmt.Fprintf(w, "Limits:\t%d down, %d up\n", v.DownloadLimit, v.UploadLimit)
+		if v.Sequential {
+			fmt.Fprintf(w, "Sequential:\twindow %d\n", v.Window)
+		}
+		for i, tier := range v.Trackers {
+			fmt.Fprintf(w, "Tracker tier %d:\t%s\n", i, strings.Join(tier, " "))
+		}
+		for _, f := range v.Files {
+			fmt.Fprintf(w, "File:\t%s\t%s\t%.1f%%\t%s\n", f.Path, tui.FormatBytes(float64(f.Length)), f.Progress*100, f.Priority)
+		}
+	case []daemon.Peer:
+		fmt.Fprint(w, "ADDRESS\tCLIENT\tFLAGS\tDOWN\tUP\tHAS\n")
+		for _, p := range v {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1f%%\n", p.Addr, p.Client, p.Flags, tui.FormatRate(p.DownloadRate), tui.FormatRate(p.UploadRate), p.Progress*100)
+		}
+	case [][]string:
+		for i, tier := range v {
+			for _, u := range tier {
+				fmt.Fprintf(w, "%d\t%s\n", i, u)
+			}
+		}
+	case []daemon.File:
+		fmt.Fprint(w, "PATH\tSIZE\tDONE\tPRIORITY\n")
+		for _, f := range v {
+			fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s\n", f.Path, tui.FormatBytes(float64(f.Length)), f.Progress*100, f.Priority)
+		}
+	}
+
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/daemon"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+func TestRemote(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(file, bytes.Repeat([]byte("gobittorrent"), 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	torrent := filepath.Join(dir, "file.torrent")
+	if code := Main(defaultConfig, []string{"create", "-tracker", "http://127.0.0.1:1/announce", "-o", torrent, file}, io.Discard, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("create = %d, want %d", code, ExitOK)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	session, err := p2p.NewSession(log, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	add := func(torrent *bencode.Torrent) (*p2p.Client, error) { return session.Add(torrent) }
+	srv := httptest.NewServer(daemon.NewServer(log, session, "secret", add))
+	defer srv.Close()
+
+	run := func(args ...string) (int, string, string) {
+		cfg := config.Default()
+		cfg.Daemon = config.Daemon{Listen: srv.URL, Token: "secret"}
+		var stdout, stderr bytes.Buffer
+		load := func() (*config.Config, error) { return cfg, nil }
+		code := Main(load, append([]string{"remote"}, args...), &stdout, &stderr, "usage")
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, stderr := run("add", torrent)
+	if code != ExitOK || !strings.Contains(out, "file.bin") {
+		t.Fatalf("remote add = %d, %q, %q", code, out, stderr)
+	}
+	hash := strings.Fields(strings.Split(out, "\n")[1])[0]
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     string
+	}{
+		{name: "List", args: []string{"list"}, wantCode: ExitOK, want: hash},
+		{name: "Info", args: []string{"info", hash}, wantCode: ExitOK, want: "http://127.0.0.1:1/announce"},
+		{name: "Pause", args: []string{"pause", hash}, wantCode: ExitOK, want: "paused"},
+		{name: "Torrent limits", args: []string{"limits", "-down", "2048", hash}, wantCode: ExitOK},
+		{name: "Session limits", args: []string{"limits", "-connections", "5"}, wantCode: ExitOK, want: "(max 5)"},
+		{name: "Sequential", args: []string{"sequential", "-window", "8", "--json", hash}, wantCode: ExitOK, want: `"window": 8`},
+		{name: "Sequential info", args: []string{"info", hash}, wantCode: ExitOK, want: "window 8"},
+		{name: "Sequential off", args: []string{"sequential", "-off", "--json", hash}, wantCode: ExitOK, want: `"sequential": false`},
+		{name: "Files", args: []string{"files", "--json", hash}, wantCode: ExitOK, want: `"path": "file.bin"`},
+		{name: "Trackers", args: []string{"trackers", hash}, wantCode: ExitOK, want: "0  http://127.0.0.1:1/announce"},
+		{name: "Unknown command", args: []string{"start", hash}, wantCode: ExitUsage},
+		{name: "Missing argument", args: []string{"peers"}, wantCode: ExitUsage},
+		{name: "Unknown torrent", args: []string{"pause", daemon.FormatInfoHash([20]byte{1})}, wantCode: ExitError},
+		{name: "Remove", args: []string{"remove", hash}, wantCode: ExitOK, want: "Removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out, stderr := run(tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("Main() = %d, want %d, stderr: %s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("stdout = %q, want it to contain %q", out, tt.want)
+			}
+		})
+	}
+
+	if n := len(session.Torrents()); n != 0 {
+		t.Errorf("the session has %d torrents after remove, want 0", n)
+	}
+
+	var stderrBuf bytes.Buffer
+	if code := Main(defaultConfig, []string{"daemon"}, io.Discard, &stderrBuf, "usage"); code != ExitUsage || !strings.Contains(stderrBuf.String(), "token") {
+		t.Errorf("daemon without a token = %d, %q, want %d", code, stderrBuf.String(), ExitUsage)
+	}
+}
+
+func TestAddTorrent(t *testing.T) {
+	files := map[string][]byte{
+		"a.bin":     bytes.Repeat([]byte("a"), 20000),
+		"dir/b.bin": bytes.Repeat([]byte("b"), 30000),
+	}
+	torrent, err := openTorrent(newFilesTorrent(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	session, err := p2p.NewSession(log, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	cfg := config.Default()
+	cfg.DownloadDir = t.TempDir()
+
+	client, err := addTorrent(session, cfg, log, torrent)
+	if err != nil {
+		t.Fatalf("addTorrent() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := client.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	// The files are laid out like the torrent, not concatenated into one.
+	for name, data := range files {
+		if got, err := os.ReadFile(filepath.Join(cfg.DownloadDir, "root", filepath.FromSlash(name))); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s has %d bytes, %v, want the %d of the file", name, len(got), err, len(data))
+		}
+	}
+}
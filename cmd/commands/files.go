@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/handsomefox/gobittorrent/tui"
+)
+
+// fileInfo is the JSON output of files.
+type fileInfo struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// Files lists the file tree of a .torrent file.
+func Files(fs *flag.FlagSet, _ *config.Config, args []string) (string, error) {
+	asJSON := jsonFlag(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return "", err
+	}
+
+	torrent, err := openTorrent(args[0])
+	if err != nil {
+		return "", err
+	}
+	files := p2p.TorrentFiles(torrent)
+
+	if *asJSON {
+		infos := make([]fileInfo, 0, len(files))
+		for _, f := range files {
+			infos = append(infos, fileInfo{Path: f.Path, Length: f.Length})
+		}
+		return marshal(infos)
+	}
+	return formatFileTree(torrent, files), nil
+}
+
+// formatFileTree draws the files as a tree under the name of the torrent, the files of a directory
+// before its subdirectories, with the sizes in a column.
+func formatFileTree(torrent *bencode.Torrent, files []p2p.FileStats) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	if !torrent.File.Info.IsMultiFile() {
+		fmt.Fprintf(w, "%s\t%s\n", torrent.File.Info.Name, tui.FormatBytes(float64(total)))
+		w.Flush()
+		return strings.TrimSuffix(sb.String(), "\n")
+	}
+	fmt.Fprintf(w, "%s/\t%s\t(%d files)\n", torrent.File.Info.Name, tui.FormatBytes(float64(total)), len(files))
+
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b p2p.FileStats) int {
+		return cmp.Compare(treeKey(a.Path), treeKey(b.Path))
+	})
+	var dirs []string // of the previous file
+	for _, f := range sorted {
+		parts := strings.Split(f.Path, "/")
+		common := 0
+		for common < len(dirs) && common < len(parts)-1 && dirs[common] == parts[common] {
+			common++
+		}
+		for i := common; i < len(parts)-1; i++ {
+			fmt.Fprintf(w, "%s%s/\t\n", strings.Repeat("  ", i+1), parts[i])
+		}
+		dirs = parts[:len(parts)-1]
+		fmt.Fprintf(w, "%s%s\t%s\n", strings.Repeat("  ", len(parts)), parts[len(parts)-1], tui.FormatBytes(float64(f.Length)))
+	}
+
+	w.Flush()
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ") // The directories have no size.
+	}
+	return strings.Join(lines, "\n")
+}
+
+// treeKey sorts the files of a directory before its subdirectories.
+func treeKey(p string) string {
+	dir, file := path.Split(p)
+	return dir + "\x00" + file
+}
+
+// matchFiles returns which files match any of the globs, by their path or by their name. It fails
+// when a glob is malformed or nothing matches.
+func matchFiles(files []p2p.FileStats, globs []string) ([]bool, error) {
+	matched := make([]bool, len(files))
+	count := 0
+	for i, f := range files {
+		for _, glob := range globs {
+			byPath, err := path.Match(glob, f.Path)
+			if err != nil {
+				return nil, fmt.Errorf("%w, glob %q: %w", ErrInvalidArguments, glob, err)
+			}
+			byName, _ := path.Match(glob, path.Base(f.Path))
+			if byPath || byName {
+				matched[i] = true
+				count++
+				break
+			}
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("%w, no file matches %s", ErrInvalidArguments, strings.Join(globs, " or "))
+	}
+	return matched, nil
+}
+
+// downloadFiles downloads the files that match the globs into their place under dir, the other
+// files are skipped and not created.
+func downloadFiles(cfg *config.Config, torrent *bencode.Torrent, torrentPath, dir string, globs []string, noProgress, sequential bool, window int) (string, error) {
+	matched, err := matchFiles(p2p.TorrentFiles(torrent), globs)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, stopSignals := notifyContext()
+	defer stopSignals()
+
+	client, err := newClient(ctx, cfg, torrent, p2p.WithStorage(p2p.NewFileStorage(dir)))
+	if err != nil {
+		return "", interrupted(ctx, err)
+	}
+	defer shutdown(client)
+	runSchedule(ctx, cfg, client)
+	client.SetSequential(sequential, window)
+
+	count := 0
+	for i, ok := range matched {
+		if ok {
+			count++
+			continue
+		}
+		if err := client.SetFilePriority(i, p2p.PrioritySkip); err != nil {
+			return "", err
+		}
+	}
+
+	if err := withProgress(client, noProgress, func() error { return client.Wait(ctx) }); err != nil {
+		return "", interrupted(ctx, err)
+	}
+	return fmt.Sprintf("Downloaded %d of %d files of %s to %s", count, len(matched), torrentPath, dir), nil
+}
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// newFilesTorrent creates a multi-file torrent of the files served by a web seed and returns the
+// path of the .torrent file.
+func newFilesTorrent(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, "root", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(seed.Close)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	t.Cleanup(tracker.Close)
+
+	torrent := filepath.Join(t.TempDir(), "root.torrent")
+	args := []string{"create", "-tracker", tracker.URL + "/announce", "-web-seed", seed.URL + "/", "-piece-length", "16384", "-o", torrent, filepath.Join(dir, "root")}
+	if code := Main(defaultConfig, args, io.Discard, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("create = %d, want %d", code, ExitOK)
+	}
+	return torrent
+}
+
+func TestFiles(t *testing.T) {
+	torrent := newFilesTorrent(t, map[string][]byte{
+		"readme.txt":        []byte("hello"),
+		"video/movie.mkv":   make([]byte, 2048),
+		"video/subs/en.srt": make([]byte, 100),
+		"video/extra.mkv":   make([]byte, 1024),
+	})
+
+	var stdout bytes.Buffer
+	if code := Main(defaultConfig, []string{"files", torrent}, &stdout, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("files = %d, want %d", code, ExitOK)
+	}
+	want := `root/          3.1 KiB  (4 files)
+  readme.txt   5 B
+  video/
+    extra.mkv  1.0 KiB
+    movie.mkv  2.0 KiB
+    subs/
+      en.srt   100 B
+`
+	if got := stdout.String(); got != want {
+		t.Errorf("files printed:\n%s\nwant:\n%s", got, want)
+	}
+
+	stdout.Reset()
+	if code := Main(defaultConfig, []string{"files", "--json", torrent}, &stdout, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("files --json = %d, want %d", code, ExitOK)
+	}
+	if !strings.Contains(stdout.String(), `"path": "video/subs/en.srt"`) {
+		t.Errorf("files --json printed %s", stdout.String())
+	}
+}
+
+func TestMatchFiles(t *testing.T) {
+	files := []p2p.FileStats{{Path: "readme.txt"}, {Path: "video/movie.mkv"}, {Path: "video/subs/en.srt"}}
+	tests := []struct {
+		name    string
+		globs   []string
+		want    []bool
+		wantErr error
+	}{
+		{name: "Name", globs: []string{"*.mkv"}, want: []bool{false, true, false}},
+		{name: "Path", globs: []string{"video/*"}, want: []bool{false, true, false}},
+		{name: "Several globs", globs: []string{"readme.txt", "*.srt"}, want: []bool{true, false, true}},
+		{name: "No match", globs: []string{"*.iso"}, wantErr: ErrInvalidArguments},
+		{name: "Malformed", globs: []string{"[a"}, wantErr: ErrInvalidArguments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchFiles(files, tt.globs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("matchFiles() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matchFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadOnly(t *testing.T) {
+	// Pieces of 16KiB: every file shares a piece with the next one.
+	files := map[string][]byte{
+		"a.bin":     bytes.Repeat([]byte("a"), 20000),
+		"dir/b.bin": bytes.Repeat([]byte("b"), 30000),
+		"dir/c.bin": bytes.Repeat([]byte("c"), 20000),
+	}
+	torrent := newFilesTorrent(t, files)
+
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := Main(defaultConfig, []string{"download", "-no-progress", "-only", "dir/b.*", torrent, out}, &stdout, &stderr, "usage")
+	if code != ExitOK || !strings.Contains(stdout.String(), "Downloaded 1 of 3 files") {
+		t.Fatalf("download -only = %d, %q, %q", code, stdout.String(), stderr.String())
+	}
+
+	if got, err := os.ReadFile(filepath.Join(out, "root", "dir", "b.bin")); err != nil || !bytes.Equal(got, files["dir/b.bin"]) {
+		t.Errorf("dir/b.bin has %d bytes, %v, want the %d of the file", len(got), err, len(files["dir/b.bin"]))
+	}
+	for _, name := range []string{"a.bin", "dir/c.bin"} {
+		if _, err := os.Stat(filepath.Join(out, "root", filepath.FromSlash(name))); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("the skipped %s exists, Stat() error = %v", name, err)
+		}
+	}
+
+	if code := Main(defaultConfig, []string{"download", "-only", "*.iso", torrent, out}, io.Discard, io.Discard, "usage"); code != ExitUsage {
+		t.Errorf("download -only without a match = %d, want %d", code, ExitUsage)
+	}
+}
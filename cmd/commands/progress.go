@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/handsomefox/gobittorrent/tui"
+)
+
+// The intervals between progress updates.
+const (
+	ProgressInterval    = time.Millisecond * 500 // redrawing the terminal line
+	ProgressLogInterval = time.Second * 10       // logging without a terminal
+)
+
+// PieceMapWidth is the amount of cells in the piece map, each cell covers a range of pieces.
+const PieceMapWidth = 40
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// withProgress runs the download and shows the progress of the client on stdout meanwhile, unless
+// noProgress is set.
+func withProgress(client *p2p.Client, noProgress bool, download func() error) error {
+	var (
+		stop     = make(chan struct{})
+		finished = make(chan struct{})
+	)
+	go func() {
+		defer close(finished)
+		if !noProgress {
+			showProgress(os.Stdout, isTerminal(os.Stdout), client.Stats, stop)
+		}
+	}()
+
+	err := download()
+	close(stop)
+	<-finished
+	return err
+}
+
+// showProgress reports the stats until stop is closed, on a single redrawn line when w is a
+// terminal and with log lines otherwise. It writes the final stats before it returns.
+func showProgress(w io.Writer, terminal bool, stats func() p2p.Stats, stop <-chan struct{}) {
+	interval := ProgressLogInterval
+	if terminal {
+		interval = ProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	report := func() {
+		s := stats()
+		if terminal {
+			fmt.Fprint(w, "\r\033[K"+tui.ProgressLine(&s, PieceMapWidth))
+			return
+		}
+		slog.Info("Download progress",
+			"progress", fmt.Sprintf("%.1f%%", s.Progress()*100),
+			"pieces", fmt.Sprintf("%d/%d", s.PiecesDone, s.PiecesTotal),
+			"download_rate", tui.FormatRate(s.DownloadRate),
+			"upload_rate", tui.FormatRate(s.UploadRate),
+			"eta", tui.FormatETA(s.ETA()),
+			"peers", s.Peers,
+			"seeds", s.Seeds,
+		)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			report()
+		case <-stop:
+			report()
+			if terminal {
+				fmt.Fprintln(w)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+func TestShowProgress(t *testing.T) {
+	stats := p2p.Stats{
+		Length:       4 << 20,
+		PiecesTotal:  4,
+		PiecesDone:   2,
+		Have:         []bool{true, true, false, false},
+		DownloadRate: 1 << 20,
+		Peers:        3,
+		Seeds:        1,
+	}
+
+	var buf bytes.Buffer
+	stop := make(chan struct{})
+	close(stop)
+	showProgress(&buf, true, func() p2p.Stats { return stats }, stop)
+
+	got := buf.String()
+	for _, part := range []string{"\r\033[K", " 50.0%", "[██··]", "2/4 pieces", "↓ 1.0 MiB/s", "↑ 0 B/s", "ETA 2s", "peers 3 (1 seeds)"} {
+		if !strings.Contains(got, part) {
+			t.Errorf("showProgress() wrote %q, want it to contain %q", got, part)
+		}
+	}
+	if !strings.HasSuffix(got, "\n") {
+		t.Errorf("showProgress() did not end the line: %q", got)
+	}
+}
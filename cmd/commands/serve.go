@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/handsomefox/gobittorrent/stream"
+)
+
+// DefaultServeListen is where serve listens without the -listen flag.
+const DefaultServeListen = "127.0.0.1:8080"
+
+// Serve downloads the torrent and serves its files over HTTP until it is interrupted.
+func Serve(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	listen := fs.String("listen", DefaultServeListen, "the address of the HTTP server")
+	args, err := parseConfigFlags(fs, cfg, args, 1, 1)
+	if err != nil {
+		return "", err
+	}
+
+	torrent, err := openTorrent(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	ctx, stop := notifyContext()
+	defer stop()
+
+	client, err := newClient(ctx, cfg, torrent, p2p.WithStorage(p2p.NewFileStorage(cfg.DownloadDir)))
+	if err != nil {
+		return "", interrupted(ctx, err)
+	}
+	defer shutdown(client)
+	runSchedule(ctx, cfg, client)
+
+	log := slog.Default()
+	go func() {
+		// The readers download the pieces they need first, the rest follow.
+		if err := client.Wait(ctx); err == nil {
+			log.Info("Downloaded the torrent", "torrent", torrent.File.Info.Name)
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           stream.NewServer(log, client),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	errch := make(chan error, 1)
+	go func() { errch <- srv.ListenAndServe() }()
+	log.Info("Serving the files of the torrent", "torrent", torrent.File.Info.Name, "url", "http://"+*listen+"/")
+
+	select {
+	case err := <-errch:
+		return "", err
+	case <-ctx.Done():
+	}
+
+	// The client goes first, its readers stop the requests that wait for pieces.
+	if err := shutdown(client); err != nil {
+		log.Warn("Failed to stop the torrent gracefully", "err", err)
+	}
+	if err := shutdown(srv); err != nil {
+		return "", err
+	}
+	return "Stopped serving " + args[0], nil
+}
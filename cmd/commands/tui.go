@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/tui"
+	"golang.org/x/term"
+)
+
+// Tui downloads the torrents and shows them in a full-screen view until "q" is pressed.
+func Tui(fs *flag.FlagSet, cfg *config.Config, args []string) (string, error) {
+	torrentPaths, err := parseConfigFlags(fs, cfg, args, 0, math.MaxInt)
+	if err != nil {
+		return "", err
+	}
+
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+		return "", ErrNotTerminal
+	}
+
+	// The log would draw over the view.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	session, err := newSession(cfg, log)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	defer shutdown(session)
+
+	for _, path := range torrentPaths {
+		torrent, err := openTorrent(path)
+		if err != nil {
+			return "", err
+		}
+		if _, err := addTorrent(session, cfg, log, torrent); err != nil {
+			return "", err
+		}
+	}
+
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(stdin, state)
+
+	size := func() (int, int) {
+		width, height, err := term.GetSize(stdout)
+		if err != nil {
+			return 80, 24
+		}
+		return width, height
+	}
+	ctx, stop := notifyContext()
+	defer stop()
+	runSchedule(ctx, cfg, session)
+	if err := tui.Run(ctx, tui.SessionBackend{Session: session}, os.Stdin, os.Stdout, size); err != nil {
+		return "", err
+	}
+	return "", nil
+}
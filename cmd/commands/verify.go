@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/handsomefox/gobittorrent/config"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// verifyInfo is the JSON output of verify.
+type verifyInfo struct {
+	Complete       bool             `json:"complete"`
+	Pieces         int              `json:"pieces"`
+	PiecesComplete int              `json:"pieces_complete"`
+	Corrupt        []int            `json:"corrupt"` // the indexes of the pieces
+	Missing        []int            `json:"missing"`
+	Files          []p2p.FileReport `json:"files"`
+}
+
+// Verify checks the data of a torrent on disk, it fails with ErrMismatch when a piece is corrupt or
+// missing.
+func Verify(fs *flag.FlagSet, _ *config.Config, args []string) (string, error) {
+	var (
+		asJSON  = jsonFlag(fs)
+		workers = fs.Int("workers", 0, "the amount of goroutines hashing pieces (default: number of CPUs)")
+	)
+	args, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return "", err
+	}
+	torrentPath, path := args[0], args[1]
+
+	torrent, err := openTorrent(torrentPath)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, stop := notifyContext()
+	defer stop()
+
+	report, err := p2p.Verify(ctx, torrent, path, *workers)
+	if err != nil {
+		return "", interrupted(ctx, err)
+	}
+
+	info := verifyInfo{
+		Complete: report.Complete(),
+		Pieces:   len(report.Pieces),
+		Corrupt:  []int{},
+		Missing:  []int{},
+		Files:    report.Files,
+	}
+	for i, s := range report.Pieces {
+		switch s {
+		case p2p.PieceComplete:
+			info.PiecesComplete++
+		case p2p.PieceCorrupt:
+			info.Corrupt = append(info.Corrupt, i)
+		case p2p.PieceMissing:
+			info.Missing = append(info.Missing, i)
+		}
+	}
+
+	var out string
+	if *asJSON {
+		if out, err = marshal(info); err != nil {
+			return "", err
+		}
+	} else {
+		out = formatVerifyReport(torrentPath, path, info)
+	}
+	if !info.Complete {
+		return out, fmt.Errorf("%w, %d corrupt and %d missing pieces", ErrMismatch, len(info.Corrupt), len(info.Missing))
+	}
+	return out, nil
+}
+
+// formatVerifyReport prints the counts of the pieces, the state of every file and the ranges of the
+// bad pieces.
+func formatVerifyReport(torrentPath, path string, info verifyInfo) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Verified %d pieces of %s at %s: %d complete, %d corrupt, %d missing\n",
+		info.Pieces, torrentPath, path, info.PiecesComplete, len(info.Corrupt), len(info.Missing))
+
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	for _, f := range info.Files {
+		fmt.Fprintf(w, "%s\t%s\t%d/%d pieces\n", f.State, f.Path, f.PiecesComplete, f.PiecesTotal)
+	}
+	w.Flush()
+
+	if len(info.Corrupt) > 0 {
+		fmt.Fprintf(&sb, "Corrupt pieces: %s\n", formatRanges(info.Corrupt))
+	}
+	if len(info.Missing) > 0 {
+		fmt.Fprintf(&sb, "Missing pieces: %s\n", formatRanges(info.Missing))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// formatRanges joins the sorted indexes, runs of them as "first-last".
+func formatRanges(indexes []int) string {
+	var ranges []string
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && indexes[j+1] == indexes[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(indexes[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", indexes[i], indexes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	// Pieces of 16KiB: a.bin is in 0-1, dir/b.bin in 1-3 and dir/c.bin in 3-4.
+	files := map[string][]byte{
+		"a.bin":     bytes.Repeat([]byte("a"), 20000),
+		"dir/b.bin": bytes.Repeat([]byte("b"), 30000),
+		"dir/c.bin": bytes.Repeat([]byte("c"), 20000),
+	}
+	torrent := newFilesTorrent(t, files)
+
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, "root", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if code := Main(defaultConfig, []string{"verify", torrent, dir}, &stdout, io.Discard, "usage"); code != ExitOK {
+		t.Fatalf("verify = %d, want %d", code, ExitOK)
+	}
+	if !strings.Contains(stdout.String(), "5 complete, 0 corrupt, 0 missing") {
+		t.Errorf("verify printed %s", stdout.String())
+	}
+
+	// Corrupt a byte in piece 2 and remove dir/c.bin.
+	b := filepath.Join(dir, "root", "dir", "b.bin")
+	data := bytes.Clone(files["dir/b.bin"])
+	data[20000] = 'x'
+	if err := os.WriteFile(b, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "root", "dir", "c.bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	var stderr bytes.Buffer
+	code := Main(defaultConfig, []string{"verify", "--json", "-workers", "2", torrent, filepath.Join(dir, "root")}, &stdout, &stderr, "usage")
+	if code != ExitMismatch || !strings.Contains(stderr.String(), ErrMismatch.Error()) {
+		t.Fatalf("verify of damaged data = %d, %q, want %d", code, stderr.String(), ExitMismatch)
+	}
+	var info verifyInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("verify --json printed %s: %v", stdout.String(), err)
+	}
+	if info.Complete || info.PiecesComplete != 2 || !slices.Equal(info.Corrupt, []int{2}) || !slices.Equal(info.Missing, []int{3, 4}) {
+		t.Errorf("verify --json = %+v, want pieces 0-1 complete, 2 corrupt and 3-4 missing", info)
+	}
+	if !strings.Contains(stdout.String(), `"state": "corrupt"`) || !strings.Contains(stdout.String(), `"state": "missing"`) {
+		t.Errorf("verify --json printed the files %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := Main(defaultConfig, []string{"verify", torrent, dir}, &stdout, io.Discard, "usage"); code != ExitMismatch {
+		t.Fatalf("verify = %d, want %d", code, ExitMismatch)
+	}
+	if !strings.Contains(stdout.String(), "Corrupt pieces: 2\nMissing pieces: 3-4") {
+		t.Errorf("verify printed %s", stdout.String())
+	}
+}
+
+func TestFormatRanges(t *testing.T) {
+	tests := []struct {
+		indexes []int
+		want    string
+	}{
+		{indexes: []int{3}, want: "3"},
+		{indexes: []int{0, 1, 2}, want: "0-2"},
+		{indexes: []int{0, 2, 3, 4, 7, 9, 10}, want: "0, 2-4, 7, 9-10"},
+	}
+	for _, tt := range tests {
+		if got := formatRanges(tt.indexes); got != tt.want {
+			t.Errorf("formatRanges(%v) = %q, want %q", tt.indexes, got, tt.want)
+		}
+	}
+}
@@ -1,39 +1,23 @@
 package main
 
 import (
-	"fmt"
 	"log/slog"
 	"os"
-	"strings"
-	"time"
 
 	"github.com/handsomefox/gobittorrent/cmd/commands"
-	"github.com/lmittmann/tint"
+	"github.com/handsomefox/gobittorrent/config"
 )
 
 func main() {
-	log := slog.New(tint.NewHandler(os.Stderr, &tint.Options{
-		AddSource:  true,
-		Level:      slog.LevelInfo,
-		TimeFormat: time.Kitchen,
-	}))
-	slog.SetDefault(log)
-	switch command := strings.ToLower(os.Args[1]); command {
-	case "decode":
-		commands.RunCommand(commands.Decode)
-	case "peers":
-		commands.RunCommand(commands.Peers)
-	case "help":
-		fmt.Println(Usage)
-	case "info":
-		commands.RunCommand(commands.Info)
-	case "handshake":
-		commands.RunCommand2(commands.Handshake)
-	case "download":
-		commands.RunCommand2(commands.Download)
-	default:
-		fmt.Println(IncorrectUsage)
+	load := func() (*config.Config, error) {
+		cfg, err := config.Read()
+		if err != nil {
+			return nil, err
+		}
+		slog.SetDefault(commands.NewLogger(os.Stderr, cfg))
+		return cfg, nil
 	}
+	os.Exit(commands.Main(load, os.Args[1:], os.Stdout, os.Stderr, Usage))
 }
 
 const Usage = `gobittorrent
@@ -41,23 +25,74 @@ const Usage = `gobittorrent
 Commands:
   decode <string>
     decodes a bencoded string and outputs it as json
-  peers <.torrent file>
+  peers [flags] <.torrent file>
     shows the available peers for the given .torrent file
-  info <.torrent file>
+  info [flags] <.torrent file>
     shows the decoded representation of the .torrent file
-  handshake <.torrent file> <peer>
-    does the handshake with the given peer, which is a string that looks like: "host:port"
-  download <.torrent file> <output file>
-    downloads a single-file torrent to the specified file
-  help
-    display this message
+  files [flags] <.torrent file>
+    lists the file tree of the .torrent file
+  handshake [flags] <.torrent file> <peer>
+    does the handshake with the given peer, which is a string that looks like: "host:port", and shows its peer ID and client
+  download [flags] <.torrent file> [output file or directory]
+    downloads a single-file torrent to the specified file, or to the download directory,
+    showing the progress on a terminal and logging it otherwise. The pieces are written in order as
+    soon as they are verified, so the output can be a pipe. -sequential downloads them front to back,
+    -window N requests only the N pieces after the first missing one at once. With -only <glob>
+    (repeatable) it downloads the files whose path or name matches into the directory, laid out like
+    the torrent, the other files are skipped and not created
+  serve [flags] <.torrent file>
+    downloads the torrent to the download directory and serves its files over HTTP on -listen
+    (127.0.0.1:8080) with an index at /, a player can seek in a file before it is downloaded
+  tui [flags] [.torrent files...]
+    downloads the torrents to the download directory and shows them in a full-screen view
+    with their peers, trackers and files. Keys: j/k select, tab switches the pane,
+    p pauses or resumes, x removes, [/] select a file, +/- change its priority, q quits
+  daemon [flags] [.torrent files...]
+    downloads the torrents in the background and serves the JSON HTTP API on daemon.listen,
+    every request needs "Authorization: Bearer <daemon.token>". Transmission clients connect to
+    /transmission/rpc with the token as the password, Prometheus scrapes /metrics with the token
+  remote [flags] <command> [argument]
+    runs a command on the API of a daemon: session, list, info <info hash>,
+    add <.torrent file or magnet link>, pause, resume, remove, peers, trackers and files <info hash>,
+    limits [-down N] [-up N] [-connections N] [info hash], sequential [-window N] [-off] <info hash>
+  config show [flags]
+    shows the effective config: the config file, the environment and the flags
+  create [flags] <file or directory>
+    creates a .torrent file
+  verify [flags] <.torrent file> <file or directory>
+    hashes the pieces of the data on disk in parallel (-workers N) and reports the complete,
+    corrupt and missing pieces and files, exits with 3 when the data does not match
+  help [command]
+    display this message, or the help and the flags of the command
+
+  info, files, peers, handshake, remote and verify print JSON with --json. Every command shows its flags with --help.
+  On Ctrl-C or SIGTERM the torrents are stopped gracefully, the trackers are told within 5 seconds.
+  An interrupted peers, handshake or download exits with 130.
+
+Config:
+  The config file is $GOBITTORRENT_CONFIG, or config.toml or config.json in
+  $XDG_CONFIG_HOME/gobittorrent (~/.config/gobittorrent) or $XDG_CONFIG_DIRS/gobittorrent.
+  Every setting can be overridden by a GOBITTORRENT_* environment variable and by a flag
+  of peers, handshake, download, serve, tui, daemon, remote and config show:
+    -download-dir, -port, -download-limit, -upload-limit, -max-peers, -max-connections,
+    -trackers, -dht, -encryption, -log-level, -log-format, -daemon-listen, -daemon-token
 
 Usage:
   gobittorrent decode 5:hello
   gobittorrent decode d3:foo3:bar5:helloi52ee
   gobittorrent peers sample.torrent
   gobittorrent info sample.torrent
+  gobittorrent info --json sample.torrent
   gobittorrent handshake sample.torrent 1.1.1.1:1111
-  gobittorrent download sample.torrent ./output.txt`
-
-const IncorrectUsage = "Incorrect usage...\n" + Usage
+  gobittorrent download sample.torrent ./output.txt
+  gobittorrent download -download-limit 1048576 sample.torrent
+  gobittorrent download -sequential -window 16 sample.torrent /tmp/pipe
+  gobittorrent files debian.torrent
+  gobittorrent download -only '*.iso' -only 'docs/*' debian.torrent ./downloads
+  gobittorrent serve -listen 127.0.0.1:8080 movie.torrent
+  gobittorrent tui first.torrent second.torrent
+  GOBITTORRENT_DAEMON_TOKEN=secret gobittorrent daemon sample.torrent
+  GOBITTORRENT_DAEMON_TOKEN=secret gobittorrent remote list
+  GOBITTORRENT_LOG_LEVEL=debug gobittorrent config show -port 51413
+  gobittorrent verify --json debian.torrent ./downloads
+  gobittorrent create -tracker http://tracker/announce -web-seed http://mirror/ -o build.torrent ./build`
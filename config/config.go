@@ -0,0 +1,349 @@
+// Package config reads the configuration file of the client.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+var (
+	ErrInvalidConfig = errors.New("config: invalid config")
+	ErrUnknownFormat = errors.New("config: unknown file format")
+)
+
+// The encryption policies, the client does not encrypt connections yet so Validate only accepts
+// Disabled.
+const (
+	EncryptionDisabled = "disabled"
+	EncryptionEnabled  = "enabled"
+	EncryptionRequired = "required"
+)
+
+// The log formats, text is colored for terminals.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// EnvPrefix starts the names of the environment variables that override the file.
+const EnvPrefix = "GOBITTORRENT_"
+
+// Config is the contents of the configuration file.
+type Config struct {
+	DownloadDir    string   `json:"download_dir" toml:"download_dir"`
+	Port           int      `json:"port" toml:"port"`
+	DownloadLimit  int      `json:"download_limit" toml:"download_limit"` // bytes per second, 0 means no limit
+	UploadLimit    int      `json:"upload_limit" toml:"upload_limit"`
+	MaxPeers       int      `json:"max_peers" toml:"max_peers"`             // connections of a single torrent
+	MaxConnections int      `json:"max_connections" toml:"max_connections"` // connections of all torrents, 0 means no limit
+	Trackers       []string `json:"trackers" toml:"trackers"`               // added to every public torrent
+	DHT            bool     `json:"dht" toml:"dht"`
+	Encryption     string   `json:"encryption" toml:"encryption"`
+	Log            Log      `json:"log" toml:"log"`
+	Daemon         Daemon   `json:"daemon" toml:"daemon"`
+
+	// Path is the file the config was loaded from, empty for the defaults.
+	Path string `json:"-" toml:"-"`
+
+	// Schedule switches the bandwidth limits of the session by the time of the week.
+	Schedule p2p.Schedule `json:"schedule" toml:"schedule"`
+}
+
+type Log struct {
+	Level  string `json:"level" toml:"level"` // debug, info, warn or error
+	Format string `json:"format" toml:"format"`
+}
+
+// Daemon is the control API of the daemon command, the remote command connects to it.
+type Daemon struct {
+	Listen string `json:"listen" toml:"listen"` // "host:port"
+	Token  string `json:"token" toml:"token"`   // required by the API as a bearer token
+}
+
+// DefaultDaemonListen is the address of the daemon API, it only accepts local connections.
+const DefaultDaemonListen = "127.0.0.1:9091"
+
+// Default returns the config used when there is no file.
+func Default() *Config {
+	dir, err := os.UserHomeDir()
+	if err == nil {
+		dir = filepath.Join(dir, "Downloads")
+	} else {
+		dir = "."
+	}
+
+	return &Config{
+		DownloadDir: dir,
+		Port:        p2p.DefaultPort,
+		MaxPeers:    p2p.DefaultMaxPeers,
+		Encryption:  EncryptionDisabled,
+		Log:         Log{Level: "info", Format: LogText},
+		Daemon:      Daemon{Listen: DefaultDaemonListen},
+	}
+}
+
+// Paths returns the locations searched for the config file, the first existing one is used:
+// $GOBITTORRENT_CONFIG, then config.toml and config.json in $XDG_CONFIG_HOME/gobittorrent
+// and in every directory of $XDG_CONFIG_DIRS.
+func Paths(getenv func(string) string) []string {
+	if path := getenv(EnvPrefix + "CONFIG"); path != "" {
+		return []string{path}
+	}
+
+	home := getenv("XDG_CONFIG_HOME")
+	if home == "" {
+		if dir := getenv("HOME"); dir != "" {
+			home = filepath.Join(dir, ".config")
+		}
+	}
+	dirs := getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+
+	var paths []string
+	for _, dir := range append([]string{home}, filepath.SplitList(dirs)...) {
+		if dir == "" {
+			continue
+		}
+		for _, name := range []string{"config.toml", "config.json"} {
+			paths = append(paths, filepath.Join(dir, "gobittorrent", name))
+		}
+	}
+	return paths
+}
+
+// Find returns the path of the config file, or "" if there is none.
+func Find(getenv func(string) string) string {
+	paths := Paths(getenv)
+	if getenv(EnvPrefix+"CONFIG") != "" {
+		return paths[0] // an explicit path has to exist, Load reports it otherwise
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Load reads the TOML or JSON configuration file on top of the defaults, the format is picked
+// by the extension.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		if _, err := toml.Decode(string(data), cfg); err != nil {
+			return nil, fmt.Errorf("config %q: %w", path, err)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %q: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, ext)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config %q: %w", path, err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// Read loads the config file found by Find, or the defaults without one, and applies the
+// environment variables.
+func Read() (*Config, error) {
+	cfg := Default()
+	if path := Find(os.Getenv); path != "" {
+		var err error
+		if cfg, err = Load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the fields with the GOBITTORRENT_* environment variables, for example
+// GOBITTORRENT_PORT or GOBITTORRENT_LOG_LEVEL. GOBITTORRENT_TRACKERS is comma separated.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, v := range c.vars() {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(v.name, "-", "_"))
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("%w, %s: %w", ErrInvalidConfig, name, err)
+		}
+	}
+	return c.Validate()
+}
+
+// AddFlags registers the flags that override the fields, the values already in the config are
+// the defaults of the flags. Call Validate after parsing.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	for _, v := range c.vars() {
+		fs.Var(v, v.name, v.usage)
+	}
+}
+
+// Validate checks the values that the file, the environment or the flags can get wrong.
+func (c *Config) Validate() error {
+	switch {
+	case c.Port < 0 || c.Port > 65535:
+		return fmt.Errorf("%w, port %d", ErrInvalidConfig, c.Port)
+	case c.DownloadLimit < 0 || c.UploadLimit < 0:
+		return fmt.Errorf("%w, negative bandwidth limit", ErrInvalidConfig)
+	case c.MaxPeers < 0 || c.MaxConnections < 0:
+		return fmt.Errorf("%w, negative connection limit", ErrInvalidConfig)
+	}
+
+	switch c.Encryption {
+	case EncryptionDisabled:
+	case EncryptionEnabled, EncryptionRequired:
+		return fmt.Errorf("%w, encryption %q is not supported yet, only disabled is", ErrInvalidConfig, c.Encryption)
+	default:
+		return fmt.Errorf("%w, encryption %q is not one of disabled, enabled or required", ErrInvalidConfig, c.Encryption)
+	}
+	if c.DHT {
+		return fmt.Errorf("%w, dht is not supported yet, only the trackers are", ErrInvalidConfig)
+	}
+	switch c.Log.Format {
+	case LogText, LogJSON:
+	default:
+		return fmt.Errorf("%w, log format %q is not text or json", ErrInvalidConfig, c.Log.Format)
+	}
+	if _, err := c.LogLevel(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LogLevel parses Log.Level.
+func (c *Config) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return level, fmt.Errorf("%w, log level %q", ErrInvalidConfig, c.Log.Level)
+	}
+	return level, nil
+}
+
+// ClientOptions returns the options of the clients and sessions created with the config.
+func (c *Config) ClientOptions() []p2p.Option {
+	return []p2p.Option{
+		p2p.WithPort(c.Port),
+		p2p.WithMaxPeers(c.MaxPeers),
+	}
+}
+
+// WriteTOML writes the config in the format of the config file.
+func (c *Config) WriteTOML(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
+
+// configVar is a field that can be set from an environment variable or a flag.
+type configVar struct {
+	name, usage string
+	get         func() string
+	set         func(string) error
+}
+
+func (v configVar) String() string {
+	if v.get == nil {
+		return "" // the flag package formats a zero configVar to find out the default
+	}
+	return v.get()
+}
+
+func (v configVar) Set(s string) error { return v.set(s) }
+
+// IsBoolFlag lets "-dht" be used without a value.
+func (v configVar) IsBoolFlag() bool { return v.name == "dht" }
+
+func (c *Config) vars() []configVar {
+	return []configVar{
+		stringVar("download-dir", "the directory downloads are saved to", &c.DownloadDir),
+		intVar("port", "the port announced to trackers and listened on", &c.Port),
+		intVar("download-limit", "the download limit in bytes per second, 0 means no limit", &c.DownloadLimit),
+		intVar("upload-limit", "the upload limit in bytes per second, 0 means no limit", &c.UploadLimit),
+		intVar("max-peers", "the connections of a single torrent", &c.MaxPeers),
+		intVar("max-connections", "the connections of all torrents, 0 means no limit", &c.MaxConnections),
+		{
+			name:  "trackers",
+			usage: "comma separated trackers added to every public torrent",
+			get:   func() string { return strings.Join(c.Trackers, ",") },
+			set: func(s string) error {
+				c.Trackers = nil
+				for _, url := range strings.Split(s, ",") {
+					if url = strings.TrimSpace(url); url != "" {
+						c.Trackers = append(c.Trackers, url)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name:  "dht",
+			usage: "use the DHT to find peers (not supported yet)",
+			get:   func() string { return strconv.FormatBool(c.DHT) },
+			set: func(s string) (err error) {
+				c.DHT, err = strconv.ParseBool(s)
+				return err
+			},
+		},
+		stringVar("encryption", "the encryption policy: disabled, enabled or required (only disabled is supported yet)", &c.Encryption),
+		stringVar("log-level", "the log level: debug, info, warn or error", &c.Log.Level),
+		stringVar("log-format", "the log format: text or json", &c.Log.Format),
+		stringVar("daemon-listen", "the address of the daemon API", &c.Daemon.Listen),
+		stringVar("daemon-token", "the token of the daemon API", &c.Daemon.Token),
+	}
+}
+
+func stringVar(name, usage string, p *string) configVar {
+	return configVar{
+		name:  name,
+		usage: usage,
+		get:   func() string { return *p },
+		set: func(s string) error {
+			*p = s
+			return nil
+		},
+	}
+}
+
+func intVar(name, usage string, p *int) configVar {
+	return configVar{
+		name:  name,
+		usage: usage,
+		get:   func() string { return strconv.Itoa(*p) },
+		set: func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			*p = n
+			return nil
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // config.json if empty
+		data    string
+		want    p2p.Schedule
+		wantErr error
+	}{
+		{
+			name: "Schedule",
+			data: `{"schedule": {
+				"default": {"download_limit": 0, "upload_limit": 0},
+				"rules": [
+					{"days": ["mon", "Tuesday", "wed", "thu", "fri"], "start": "09:00", "end": "18:00", "download_limit": 1048576, "upload_limit": 262144},
+					{"days": ["sat"], "start": "22:30", "end": "06:00", "pause": true}
+				]
+			}}`,
+			want: p2p.Schedule{
+				Rules: []p2p.ScheduleRule{
+					{
+						Days:             []p2p.Weekday{p2p.Weekday(time.Monday), p2p.Weekday(time.Tuesday), p2p.Weekday(time.Wednesday), p2p.Weekday(time.Thursday), p2p.Weekday(time.Friday)},
+						Start:            p2p.TimeOfDay(9 * time.Hour),
+						End:              p2p.TimeOfDay(18 * time.Hour),
+						BandwidthProfile: p2p.BandwidthProfile{DownloadLimit: 1048576, UploadLimit: 262144},
+					},
+					{
+						Days:             []p2p.Weekday{p2p.Weekday(time.Saturday)},
+						Start:            p2p.TimeOfDay(22*time.Hour + 30*time.Minute),
+						End:              p2p.TimeOfDay(6 * time.Hour),
+						BandwidthProfile: p2p.BandwidthProfile{Pause: true},
+					},
+				},
+			},
+		},
+		{
+			name: "TOML schedule",
+			file: "config.toml",
+			data: `
+				[[schedule.rules]]
+				days = ["sat", "sun"]
+				start = "00:00"
+				end = "24:00"
+				upload_limit = 1024
+			`,
+			want: p2p.Schedule{
+				Rules: []p2p.ScheduleRule{{
+					Days:             []p2p.Weekday{p2p.Weekday(time.Saturday), p2p.Weekday(time.Sunday)},
+					End:              p2p.TimeOfDay(24 * time.Hour),
+					BandwidthProfile: p2p.BandwidthProfile{UploadLimit: 1024},
+				}},
+			},
+		},
+		{name: "Empty", data: `{}`},
+		{name: "Unknown format", file: "config.yaml", data: `port: 1`, wantErr: ErrUnknownFormat},
+		{name: "Invalid encryption", data: `{"encryption": "sometimes"}`, wantErr: ErrInvalidConfig},
+		{name: "Unsupported encryption", data: `{"encryption": "required"}`, wantErr: ErrInvalidConfig},
+		{name: "Unsupported DHT", file: "config.toml", data: `dht = true`, wantErr: ErrInvalidConfig},
+		{name: "Invalid log level", file: "config.toml", data: `log = {level = "loud"}`, wantErr: ErrInvalidConfig},
+		{name: "Unknown weekday", data: `{"schedule": {"rules": [{"days": ["someday"]}]}}`, wantErr: p2p.ErrInvalidSchedule},
+		{name: "Invalid time", data: `{"schedule": {"rules": [{"start": "9am"}]}}`, wantErr: p2p.ErrInvalidSchedule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file == "" {
+				tt.file = "config.json"
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(cfg.Schedule, tt.want) {
+				t.Errorf("Load() schedule = %+v, want %+v", cfg.Schedule, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	want := Default()
+	want.DownloadDir = "/data"
+	want.Port = 51413
+	want.DownloadLimit = 1 << 20
+	want.MaxConnections = 200
+	want.Trackers = []string{"udp://tracker.example:1337/announce"}
+	want.Log = Log{Level: "debug", Format: LogJSON}
+	want.Daemon = Daemon{Listen: "0.0.0.0:9091", Token: "secret"}
+
+	files := map[string]string{
+		"config.toml": `
+			download_dir = "/data"
+			port = 51413
+			download_limit = 1048576
+			max_connections = 200
+			trackers = ["udp://tracker.example:1337/announce"]
+			dht = false
+
+			[log]
+			level = "debug"
+			format = "json"
+
+			[daemon]
+			listen = "0.0.0.0:9091"
+			token = "secret"
+		`,
+		"config.json": `{
+			"download_dir": "/data", "port": 51413, "download_limit": 1048576, "max_connections": 200,
+			"trackers": ["udp://tracker.example:1337/announce"], "dht": false,
+			"log": {"level": "debug", "format": "json"},
+			"daemon": {"listen": "0.0.0.0:9091", "token": "secret"}
+		}`,
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			want.Path = path
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("Load() = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := Default()
+	env := map[string]string{
+		"GOBITTORRENT_PORT":         "7000",
+		"GOBITTORRENT_TRACKERS":     "http://a/announce, http://b/announce",
+		"GOBITTORRENT_LOG_LEVEL":    "warn",
+		"GOBITTORRENT_MAX_PEERS":    "20",
+		"GOBITTORRENT_DAEMON_TOKEN": "secret",
+	}
+	if err := cfg.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err != nil {
+		t.Fatalf("ApplyEnv() error = %v", err)
+	}
+	if cfg.Port != 7000 || cfg.Log.Level != "warn" || cfg.MaxPeers != 20 || cfg.Daemon.Token != "secret" ||
+		!reflect.DeepEqual(cfg.Trackers, []string{"http://a/announce", "http://b/announce"}) {
+		t.Errorf("ApplyEnv() = %+v", cfg)
+	}
+
+	// The flags override the environment.
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.AddFlags(fs)
+	if err := fs.Parse([]string{"-port", "8000", "-max-peers", "30", "-upload-limit", "512", "file.torrent"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8000 || cfg.MaxPeers != 30 || cfg.UploadLimit != 512 || cfg.Log.Level != "warn" || fs.Arg(0) != "file.torrent" {
+		t.Errorf("flags = %+v", cfg)
+	}
+
+	err := cfg.ApplyEnv(func(k string) (string, bool) { return "many", k == "GOBITTORRENT_MAX_PEERS" })
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ApplyEnv() error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	env := map[string]string{"HOME": "/home/user", "XDG_CONFIG_DIRS": "/etc/a:/etc/b"}
+	want := []string{
+		"/home/user/.config/gobittorrent/config.toml",
+		"/home/user/.config/gobittorrent/config.json",
+		"/etc/a/gobittorrent/config.toml",
+		"/etc/a/gobittorrent/config.json",
+		"/etc/b/gobittorrent/config.toml",
+		"/etc/b/gobittorrent/config.json",
+	}
+	if got := Paths(func(k string) string { return env[k] }); !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths() = %v, want %v", got, want)
+	}
+
+	env["GOBITTORRENT_CONFIG"] = "/tmp/gbt.toml"
+	if got := Find(func(k string) string { return env[k] }); got != "/tmp/gbt.toml" {
+		t.Errorf("Find() = %q, want the explicit path", got)
+	}
+
+	dir := t.TempDir()
+	env = map[string]string{"XDG_CONFIG_HOME": dir}
+	if err := os.MkdirAll(filepath.Join(dir, "gobittorrent"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "gobittorrent", "config.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := Find(func(k string) string { return env[k] }); got != path {
+		t.Errorf("Find() = %q, want %q", got, path)
+	}
+}
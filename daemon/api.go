@@ -0,0 +1,178 @@
+// Package daemon is the JSON HTTP API that controls the torrents of a running session, and the
+// client of that API.
+package daemon
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+var (
+	ErrInvalidInfoHash = errors.New("daemon: the info hash is not 40 hex characters")
+	ErrInvalidRequest  = errors.New("daemon: invalid request")
+	ErrNotFound        = errors.New("daemon: not found")
+	ErrRequestFailed   = errors.New("daemon: request failed")
+	ErrUnauthorized    = errors.New("daemon: the API token is missing or wrong")
+)
+
+// Torrent is a torrent of the session and its progress.
+type Torrent struct {
+	InfoHash      string  `json:"info_hash"`
+	Name          string  `json:"name"`
+	Status        string  `json:"status"` // see p2p.Stats.Status
+	Length        int64   `json:"length"`
+	PiecesTotal   int     `json:"pieces_total"`
+	PiecesDone    int     `json:"pieces_done"`
+	Progress      float64 `json:"progress"`
+	Downloaded    int64   `json:"downloaded"`
+	Uploaded      int64   `json:"uploaded"`
+	DownloadRate  float64 `json:"download_rate"` // bytes per second
+	UploadRate    float64 `json:"upload_rate"`
+	Peers         int     `json:"peers"`
+	Seeds         int     `json:"seeds"`
+	ETA           int64   `json:"eta"` // seconds, -1 when nothing is being downloaded
+	DownloadLimit int     `json:"download_limit"`
+	UploadLimit   int     `json:"upload_limit"`
+	Sequential    bool    `json:"sequential"`
+	Window        int     `json:"window"` // of the sequential download, 0 means no limit
+}
+
+// Details is a torrent with its metadata, trackers and files.
+type Details struct {
+	Torrent
+	PieceLength int        `json:"piece_length"`
+	Private     bool       `json:"private"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	Trackers    [][]string `json:"trackers"`
+	Files       []File     `json:"files"`
+}
+
+// Peer is a connected peer of a torrent.
+type Peer struct {
+	Addr         string         `json:"addr"`
+	Client       p2p.PeerClient `json:"client"`
+	Flags        string         `json:"flags"` // see p2p.Connection.Flags
+	DownloadRate float64        `json:"download_rate"`
+	UploadRate   float64        `json:"upload_rate"`
+	Progress     float64        `json:"progress"`
+}
+
+// File is a file of a torrent.
+type File struct {
+	Path     string  `json:"path"`
+	Length   int64   `json:"length"`
+	Priority string  `json:"priority"` // skip, low, normal or high
+	Progress float64 `json:"progress"`
+}
+
+// Session is the state of the whole session.
+type Session struct {
+	Port           int     `json:"port"`
+	Torrents       int     `json:"torrents"`
+	Connections    int     `json:"connections"`
+	DownloadRate   float64 `json:"download_rate"`
+	UploadRate     float64 `json:"upload_rate"`
+	DownloadLimit  int     `json:"download_limit"`
+	UploadLimit    int     `json:"upload_limit"`
+	MaxConnections int     `json:"max_connections"`
+}
+
+// Limits changes the limits of the session or of a torrent, the nil fields are kept.
+// MaxConnections only applies to the session.
+type Limits struct {
+	DownloadLimit  *int `json:"download_limit,omitempty"` // bytes per second, 0 means no limit
+	UploadLimit    *int `json:"upload_limit,omitempty"`
+	MaxConnections *int `json:"max_connections,omitempty"`
+}
+
+// SequentialRequest switches the sequential download of a torrent on or off, see p2p.Client.SetSequential.
+type SequentialRequest struct {
+	Enabled bool `json:"enabled"`
+	Window  int  `json:"window,omitempty"`
+}
+
+// AddRequest adds a torrent from the contents of a .torrent file or from a magnet link.
+type AddRequest struct {
+	Torrent []byte `json:"torrent,omitempty"` // base64 in JSON
+	Magnet  string `json:"magnet,omitempty"`
+}
+
+// errorResponse is the body of the failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// FormatInfoHash returns the hex info hash the API identifies torrents with.
+func FormatInfoHash(infoHash [20]byte) string {
+	return hex.EncodeToString(infoHash[:])
+}
+
+// ParseInfoHash parses a hex info hash.
+func ParseInfoHash(s string) ([20]byte, error) {
+	var infoHash [20]byte
+	if len(s) != hex.EncodedLen(len(infoHash)) {
+		return infoHash, fmt.Errorf("%w, %q", ErrInvalidInfoHash, s)
+	}
+	if _, err := hex.Decode(infoHash[:], []byte(s)); err != nil {
+		return infoHash, fmt.Errorf("%w, %q", ErrInvalidInfoHash, s)
+	}
+	return infoHash, nil
+}
+
+func newTorrent(c *p2p.Client) Torrent {
+	s := c.Stats()
+	sequential, window := c.Sequential()
+	eta := int64(-1)
+	if d := s.ETA(); d >= 0 {
+		eta = int64(d / time.Second)
+	}
+	return Torrent{
+		InfoHash:      FormatInfoHash(s.InfoHash),
+		Name:          s.Name,
+		Status:        s.Status(),
+		Length:        s.Length,
+		PiecesTotal:   s.PiecesTotal,
+		PiecesDone:    s.PiecesDone,
+		Progress:      s.Progress(),
+		Downloaded:    s.Downloaded,
+		Uploaded:      s.Uploaded,
+		DownloadRate:  s.DownloadRate,
+		UploadRate:    s.UploadRate,
+		Peers:         s.Peers,
+		Seeds:         s.Seeds,
+		ETA:           eta,
+		DownloadLimit: c.DownloadLimit(),
+		UploadLimit:   c.UploadLimit(),
+		Sequential:    sequential,
+		Window:        window,
+	}
+}
+
+func newPeers(c *p2p.Client) []Peer {
+	stats := c.PeerStats()
+	peers := make([]Peer, 0, len(stats))
+	for _, p := range stats {
+		peers = append(peers, Peer{
+			Addr:         p.Addr,
+			Client:       p.Client,
+			Flags:        p.Flags,
+			DownloadRate: p.DownloadRate,
+			UploadRate:   p.UploadRate,
+			Progress:     p.Progress,
+		})
+	}
+	return peers
+}
+
+func newFiles(c *p2p.Client) []File {
+	stats := c.Files()
+	files := make([]File, 0, len(stats))
+	for _, f := range stats {
+		files = append(files, File{Path: f.Path, Length: f.Length, Priority: f.Priority.String(), Progress: f.Progress})
+	}
+	return files
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client calls the API of a daemon.
+type Client struct {
+	URL        string // "http://127.0.0.1:9091"
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client of the daemon at addr, a "host:port" or a URL.
+func NewClient(addr, token string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{URL: strings.TrimSuffix(addr, "/"), Token: token, HTTPClient: http.DefaultClient}
+}
+
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	var session Session
+	return &session, c.do(ctx, http.MethodGet, "/api/session", nil, &session)
+}
+
+// SetSessionLimits changes the limits of the whole session.
+func (c *Client) SetSessionLimits(ctx context.Context, limits Limits) (*Session, error) {
+	var session Session
+	return &session, c.do(ctx, http.MethodPut, "/api/session/limits", limits, &session)
+}
+
+func (c *Client) Torrents(ctx context.Context) ([]Torrent, error) {
+	var torrents []Torrent
+	return torrents, c.do(ctx, http.MethodGet, "/api/torrents", nil, &torrents)
+}
+
+// Add adds the torrent from the contents of a .torrent file.
+func (c *Client) Add(ctx context.Context, torrent []byte) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPost, "/api/torrents", AddRequest{Torrent: torrent}, &t)
+}
+
+// AddMagnet adds the torrent of a magnet link, the daemon first fetches it from the peers, which
+// takes up to MetadataTimeout.
+func (c *Client) AddMagnet(ctx context.Context, magnet string) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPost, "/api/torrents", AddRequest{Magnet: magnet}, &t)
+}
+
+func (c *Client) Torrent(ctx context.Context, infoHash string) (*Details, error) {
+	var details Details
+	return &details, c.do(ctx, http.MethodGet, "/api/torrents/"+infoHash, nil, &details)
+}
+
+func (c *Client) Remove(ctx context.Context, infoHash string) error {
+	return c.do(ctx, http.MethodDelete, "/api/torrents/"+infoHash, nil, nil)
+}
+
+func (c *Client) Pause(ctx context.Context, infoHash string) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPost, "/api/torrents/"+infoHash+"/pause", nil, &t)
+}
+
+func (c *Client) Resume(ctx context.Context, infoHash string) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPost, "/api/torrents/"+infoHash+"/resume", nil, &t)
+}
+
+// SetLimits changes the limits of a torrent.
+func (c *Client) SetLimits(ctx context.Context, infoHash string, limits Limits) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPut, "/api/torrents/"+infoHash+"/limits", limits, &t)
+}
+
+// SetSequential switches the sequential download of a torrent on or off.
+func (c *Client) SetSequential(ctx context.Context, infoHash string, req SequentialRequest) (*Torrent, error) {
+	var t Torrent
+	return &t, c.do(ctx, http.MethodPut, "/api/torrents/"+infoHash+"/sequential", req, &t)
+}
+
+func (c *Client) Peers(ctx context.Context, infoHash string) ([]Peer, error) {
+	var peers []Peer
+	return peers, c.do(ctx, http.MethodGet, "/api/torrents/"+infoHash+"/peers", nil, &peers)
+}
+
+func (c *Client) Trackers(ctx context.Context, infoHash string) ([][]string, error) {
+	var trackers [][]string
+	return trackers, c.do(ctx, http.MethodGet, "/api/torrents/"+infoHash+"/trackers", nil, &trackers)
+}
+
+func (c *Client) Files(ctx context.Context, infoHash string) ([]File, error) {
+	var files []File
+	return files, c.do(ctx, http.MethodGet, "/api/torrents/"+infoHash+"/files", nil, &files)
+}
+
+// do sends the request with the JSON of in and decodes the response into out, if they are not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return ErrUnauthorized
+		case http.StatusNotFound:
+			return fmt.Errorf("%w, %s", ErrNotFound, e.Error)
+		}
+		return fmt.Errorf("%w, %s", ErrRequestFailed, e.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w, the response is not JSON: %w", ErrRequestFailed, err)
+	}
+	return nil
+}
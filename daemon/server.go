@@ -0,0 +1,323 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/metrics"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// MaxRequestSize limits the body of the requests, it fits large .torrent files in base64.
+const MaxRequestSize = 32 << 20
+
+// MetadataTimeout limits how long the torrent of a magnet link is looked for at the peers.
+const MetadataTimeout = 2 * time.Minute
+
+// AddFunc adds a torrent to the session and starts downloading it.
+type AddFunc func(torrent *bencode.Torrent) (*p2p.Client, error)
+
+// Server serves the API of a session, the Transmission RPC endpoint and the Prometheus metrics at
+// /metrics. Every request needs the token in an "Authorization: Bearer <token>" header, or as the
+// password of basic auth, which Transmission clients use.
+type Server struct {
+	// DownloadDir is where the added torrents are saved, Transmission clients show it.
+	DownloadDir string
+
+	session *p2p.Session
+	token   string
+	add     AddFunc
+	log     *slog.Logger
+	mux     *http.ServeMux
+	started time.Time
+
+	// The state of the Transmission RPC endpoint.
+	transmissionSession          string
+	mu                           sync.Mutex
+	ids                          map[[20]byte]int // the torrent IDs
+	lastID                       int
+	speedLimitDown, speedLimitUp int // kB/s, kept while the limits are disabled
+}
+
+// NewServer returns the API of the session, add is called for the added torrents. Without a token
+// every request is rejected.
+func NewServer(log *slog.Logger, session *p2p.Session, token string, add AddFunc) *Server {
+	s := &Server{
+		session:             session,
+		token:               token,
+		add:                 add,
+		log:                 log,
+		mux:                 http.NewServeMux(),
+		started:             time.Now(),
+		transmissionSession: newTransmissionSession(),
+		ids:                 make(map[[20]byte]int),
+		speedLimitDown:      session.DownloadLimit() / transmissionSpeedBytes,
+		speedLimitUp:        session.UploadLimit() / transmissionSpeedBytes,
+	}
+
+	s.mux.HandleFunc("GET /api/session", s.handleSession)
+	s.mux.HandleFunc("PUT /api/session/limits", s.handleSessionLimits)
+	s.mux.HandleFunc("GET /api/torrents", s.handleTorrents)
+	s.mux.HandleFunc("POST /api/torrents", s.handleAdd)
+	s.mux.HandleFunc("GET /api/torrents/{hash}", s.withTorrent(s.handleDetails))
+	s.mux.HandleFunc("DELETE /api/torrents/{hash}", s.withTorrent(s.handleRemove))
+	s.mux.HandleFunc("POST /api/torrents/{hash}/pause", s.withTorrent(s.handlePause))
+	s.mux.HandleFunc("POST /api/torrents/{hash}/resume", s.withTorrent(s.handleResume))
+	s.mux.HandleFunc("PUT /api/torrents/{hash}/limits", s.withTorrent(s.handleLimits))
+	s.mux.HandleFunc("PUT /api/torrents/{hash}/sequential", s.withTorrent(s.handleSequential))
+	s.mux.HandleFunc("GET /api/torrents/{hash}/peers", s.withTorrent(s.handlePeers))
+	s.mux.HandleFunc("GET /api/torrents/{hash}/trackers", s.withTorrent(s.handleTrackers))
+	s.mux.HandleFunc("GET /api/torrents/{hash}/files", s.withTorrent(s.handleFiles))
+	s.mux.HandleFunc("/transmission/rpc", s.handleTransmission)
+	s.mux.Handle("GET /metrics", metrics.Handler(session))
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gobittorrent"`)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestSize)
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// withTorrent looks up the torrent of the {hash} in the path.
+func (s *Server) withTorrent(handle func(w http.ResponseWriter, r *http.Request, c *p2p.Client)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		infoHash, err := ParseInfoHash(r.PathValue("hash"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		c, ok := s.session.Torrent(infoHash)
+		if !ok {
+			writeError(w, http.StatusNotFound, p2p.ErrTorrentNotFound)
+			return
+		}
+		handle(w, r, c)
+	}
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	torrents := s.session.Torrents()
+	session := Session{
+		Port:           s.session.Port(),
+		Torrents:       len(torrents),
+		Connections:    s.session.ConnectionCount(),
+		DownloadLimit:  s.session.DownloadLimit(),
+		UploadLimit:    s.session.UploadLimit(),
+		MaxConnections: s.session.MaxConnections(),
+	}
+	for _, c := range torrents {
+		stats := c.Stats()
+		session.DownloadRate += stats.DownloadRate
+		session.UploadRate += stats.UploadRate
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleSessionLimits(w http.ResponseWriter, r *http.Request) {
+	var limits Limits
+	if !readLimits(w, r, &limits) {
+		return
+	}
+	if limits.DownloadLimit != nil {
+		s.session.SetDownloadLimit(*limits.DownloadLimit)
+	}
+	if limits.UploadLimit != nil {
+		s.session.SetUploadLimit(*limits.UploadLimit)
+	}
+	if limits.MaxConnections != nil {
+		s.session.SetMaxConnections(*limits.MaxConnections)
+	}
+	s.log.Info("Changed the limits of the session", "download_limit", s.session.DownloadLimit(), "upload_limit", s.session.UploadLimit(), "max_connections", s.session.MaxConnections())
+	s.handleSession(w, r)
+}
+
+func (s *Server) handleTorrents(w http.ResponseWriter, _ *http.Request) {
+	clients := s.session.Torrents()
+	torrents := make([]Torrent, 0, len(clients))
+	for _, c := range clients {
+		torrents = append(torrents, newTorrent(c))
+	}
+	writeJSON(w, http.StatusOK, torrents)
+}
+
+func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
+	var req AddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, %w", ErrInvalidRequest, err))
+		return
+	}
+
+	var torrent *bencode.Torrent
+	switch {
+	case req.Magnet != "" && len(req.Torrent) > 0:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, both a torrent and a magnet link", ErrInvalidRequest))
+		return
+	case req.Magnet != "":
+		magnet, err := bencode.ParseMagnet(req.Magnet)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if torrent, err = s.fetchMagnet(r.Context(), magnet); err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	case len(req.Torrent) == 0:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, no torrent or magnet link", ErrInvalidRequest))
+		return
+	default:
+		var err error
+		if torrent, err = bencode.NewTorrent(bytes.NewReader(req.Torrent)); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w, %w", ErrInvalidRequest, err))
+			return
+		}
+	}
+
+	c, err := s.add(torrent)
+	switch {
+	case errors.Is(err, p2p.ErrTorrentExists):
+		writeError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.log.Info("Added a torrent", "torrent", torrent.File.Info.Name)
+	writeJSON(w, http.StatusCreated, newTorrent(c))
+}
+
+// fetchMagnet returns the torrent of the magnet link. The torrents of the session are returned as
+// they are, the others are fetched from the peers of the trackers of the link.
+func (s *Server) fetchMagnet(ctx context.Context, magnet *bencode.Magnet) (*bencode.Torrent, error) {
+	if c, ok := s.session.Torrent(magnet.InfoHash); ok {
+		return c.Torrent(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, MetadataTimeout)
+	defer cancel()
+	return s.session.FetchMetadata(ctx, magnet)
+}
+
+func (s *Server) handleDetails(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	f := &c.Torrent().File
+	writeJSON(w, http.StatusOK, Details{
+		Torrent:     newTorrent(c),
+		PieceLength: int(f.Info.PieceLength),
+		Private:     f.Info.Private,
+		CreatedBy:   string(f.CreatedBy),
+		Trackers:    c.Trackers(),
+		Files:       newFiles(c),
+	})
+}
+
+func (s *Server) handleRemove(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	if err := s.session.Remove(c.InfoHash()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.log.Info("Removed a torrent", "torrent", c.Stats().Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	c.Pause()
+	writeJSON(w, http.StatusOK, newTorrent(c))
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	c.Resume()
+	writeJSON(w, http.StatusOK, newTorrent(c))
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request, c *p2p.Client) {
+	var limits Limits
+	if !readLimits(w, r, &limits) {
+		return
+	}
+	if limits.MaxConnections != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, max_connections only applies to the session", ErrInvalidRequest))
+		return
+	}
+	if limits.DownloadLimit != nil {
+		c.SetDownloadLimit(*limits.DownloadLimit)
+	}
+	if limits.UploadLimit != nil {
+		c.SetUploadLimit(*limits.UploadLimit)
+	}
+	writeJSON(w, http.StatusOK, newTorrent(c))
+}
+
+func (s *Server) handleSequential(w http.ResponseWriter, r *http.Request, c *p2p.Client) {
+	var req SequentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, %w", ErrInvalidRequest, err))
+		return
+	}
+	if req.Window < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, negative window %d", ErrInvalidRequest, req.Window))
+		return
+	}
+	c.SetSequential(req.Enabled, req.Window)
+	writeJSON(w, http.StatusOK, newTorrent(c))
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	writeJSON(w, http.StatusOK, newPeers(c))
+}
+
+func (s *Server) handleTrackers(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	writeJSON(w, http.StatusOK, c.Trackers())
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, _ *http.Request, c *p2p.Client) {
+	writeJSON(w, http.StatusOK, newFiles(c))
+}
+
+// readLimits decodes the limits of the request and rejects the negative ones.
+func readLimits(w http.ResponseWriter, r *http.Request, limits *Limits) bool {
+	if err := json.NewDecoder(r.Body).Decode(limits); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w, %w", ErrInvalidRequest, err))
+		return false
+	}
+	for _, limit := range []*int{limits.DownloadLimit, limits.UploadLimit, limits.MaxConnections} {
+		if limit != nil && *limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w, negative limit %d", ErrInvalidRequest, *limit))
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
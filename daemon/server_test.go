@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+const testToken = "secret"
+
+// newTestTorrent returns a .torrent file of random data announced to a tracker without peers.
+func newTestTorrent(t *testing.T, name string) []byte {
+	t.Helper()
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	t.Cleanup(tracker.Close)
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte(name), 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := bencode.NewBuilder(path)
+	b.Announce = tracker.URL + "/announce"
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestServer starts a daemon with an empty session.
+func newTestServer(t *testing.T) (*p2p.Session, *httptest.Server) {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	session, err := p2p.NewSession(log, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	add := func(torrent *bencode.Torrent) (*p2p.Client, error) { return session.Add(torrent) }
+	srv := httptest.NewServer(NewServer(log, session, testToken, add))
+	t.Cleanup(srv.Close)
+	return session, srv
+}
+
+func TestServerAuth(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "No token", want: http.StatusUnauthorized},
+		{name: "Wrong token", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "Not a bearer token", header: "Basic " + testToken, want: http.StatusUnauthorized},
+		{name: "Token", header: "Bearer " + testToken, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/torrents", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewClient(srv.URL, "wrong").Torrents(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Torrents() error = %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	_, srv := newTestServer(t)
+	if _, err := NewClient(srv.URL, testToken).Add(context.Background(), newTestTorrent(t, "a.bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `gobittorrent_pieces_completed{info_hash=`) {
+		t.Errorf("status = %d, metrics:\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestServer(t *testing.T) {
+	session, srv := newTestServer(t)
+	client := NewClient(srv.URL, testToken)
+	ctx := context.Background()
+
+	added, err := client.Add(ctx, newTestTorrent(t, "a.bin"))
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if added.Name != "a.bin" || added.Length != 50000 || added.PiecesTotal == 0 {
+		t.Errorf("Add() = %+v", added)
+	}
+	hash := added.InfoHash
+
+	if _, err := client.Add(ctx, newTestTorrent(t, "b.bin")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := client.Add(ctx, []byte("not a torrent")); !errors.Is(err, ErrRequestFailed) {
+		t.Errorf("Add() error = %v, want %v", err, ErrRequestFailed)
+	}
+	if _, err := client.AddMagnet(ctx, "magnet:?xt=urn:btih:"+hash); !errors.Is(err, ErrRequestFailed) || !strings.Contains(err.Error(), p2p.ErrTorrentExists.Error()) {
+		t.Errorf("AddMagnet() of an added torrent error = %v, want %v", err, p2p.ErrTorrentExists)
+	}
+	if _, err := client.AddMagnet(ctx, "magnet:?xt=urn:btih:"+strings.Repeat("ab", 20)); !errors.Is(err, ErrRequestFailed) || !strings.Contains(err.Error(), p2p.ErrNoMetadata.Error()) {
+		t.Errorf("AddMagnet() without trackers error = %v, want %v", err, p2p.ErrNoMetadata)
+	}
+
+	torrents, err := client.Torrents(ctx)
+	if err != nil {
+		t.Fatalf("Torrents() error = %v", err)
+	}
+	if len(torrents) != 2 || torrents[0].InfoHash != hash || torrents[1].Name != "b.bin" {
+		t.Errorf("Torrents() = %+v", torrents)
+	}
+
+	details, err := client.Torrent(ctx, hash)
+	if err != nil {
+		t.Fatalf("Torrent() error = %v", err)
+	}
+	if len(details.Files) != 1 || details.Files[0].Path != "a.bin" || len(details.Trackers) != 1 || details.PieceLength == 0 {
+		t.Errorf("Torrent() = %+v", details)
+	}
+	if _, err := client.Torrent(ctx, FormatInfoHash([20]byte{1})); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Torrent() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := client.Torrent(ctx, "abc"); !errors.Is(err, ErrRequestFailed) {
+		t.Errorf("Torrent() error = %v, want %v", err, ErrRequestFailed)
+	}
+
+	paused, err := client.Pause(ctx, hash)
+	if err != nil || paused.Status != "paused" {
+		t.Errorf("Pause() = %+v, %v, want a paused torrent", paused, err)
+	}
+	resumed, err := client.Resume(ctx, hash)
+	if err != nil || resumed.Status == "paused" {
+		t.Errorf("Resume() = %+v, %v, want a running torrent", resumed, err)
+	}
+
+	download, upload := 1024, 0
+	limited, err := client.SetLimits(ctx, hash, Limits{DownloadLimit: &download})
+	if err != nil || limited.DownloadLimit != 1024 || limited.UploadLimit != 0 {
+		t.Errorf("SetLimits() = %+v, %v", limited, err)
+	}
+	negative := -1
+	if _, err := client.SetLimits(ctx, hash, Limits{UploadLimit: &negative}); !errors.Is(err, ErrRequestFailed) {
+		t.Errorf("SetLimits() error = %v, want %v", err, ErrRequestFailed)
+	}
+
+	sequential, err := client.SetSequential(ctx, hash, SequentialRequest{Enabled: true, Window: 4})
+	if err != nil || !sequential.Sequential || sequential.Window != 4 {
+		t.Errorf("SetSequential() = %+v, %v", sequential, err)
+	}
+	if _, err := client.SetSequential(ctx, hash, SequentialRequest{Enabled: true, Window: -1}); !errors.Is(err, ErrRequestFailed) {
+		t.Errorf("SetSequential() error = %v, want %v", err, ErrRequestFailed)
+	}
+
+	connections := 10
+	s, err := client.SetSessionLimits(ctx, Limits{UploadLimit: &upload, MaxConnections: &connections})
+	if err != nil || s.MaxConnections != 10 || s.Torrents != 2 || s.Port != session.Port() {
+		t.Errorf("SetSessionLimits() = %+v, %v", s, err)
+	}
+
+	peers, err := client.Peers(ctx, hash)
+	if err != nil || len(peers) != 0 {
+		t.Errorf("Peers() = %+v, %v, want no peers", peers, err)
+	}
+	trackers, err := client.Trackers(ctx, hash)
+	if err != nil || len(trackers) != 1 {
+		t.Errorf("Trackers() = %v, %v, want one tier", trackers, err)
+	}
+	files, err := client.Files(ctx, hash)
+	if err != nil || len(files) != 1 || files[0].Priority != "normal" {
+		t.Errorf("Files() = %+v, %v", files, err)
+	}
+
+	if err := client.Remove(ctx, hash); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := client.Remove(ctx, hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() error = %v, want %v", err, ErrNotFound)
+	}
+	if torrents, err := client.Torrents(ctx); err != nil || len(torrents) != 1 {
+		t.Errorf("Torrents() = %+v, %v, want the remaining torrent", torrents, err)
+	}
+}
@@ -0,0 +1,507 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// The Transmission RPC protocol the endpoint implements, the version of Transmission 3.
+const (
+	TransmissionRPCVersion        = 17
+	TransmissionRPCVersionMinimum = 14
+	TransmissionSessionHeader     = "X-Transmission-Session-Id"
+)
+
+// transmissionSpeedBytes is the size of the kB Transmission measures speed limits in.
+const transmissionSpeedBytes = 1000
+
+// The torrent statuses of Transmission.
+const (
+	transmissionStopped     = 0
+	transmissionDownloading = 4
+	transmissionSeeding     = 6
+)
+
+// transmissionRequest is the body of a request to /transmission/rpc.
+type transmissionRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       *int            `json:"tag,omitempty"`
+}
+
+type transmissionResponse struct {
+	Result    string `json:"result"` // "success" or the error
+	Arguments any    `json:"arguments"`
+	Tag       *int   `json:"tag,omitempty"`
+}
+
+// transmissionIDs is the "ids" argument: a torrent ID, a hash string, a list of them or
+// "recently-active". It selects every torrent when it is missing.
+type transmissionIDs struct {
+	IDs json.RawMessage `json:"ids"`
+}
+
+// handleTransmission is the /transmission/rpc endpoint. Clients first get a 409 response with
+// the session ID, which they send back in the X-Transmission-Session-Id header of the following
+// requests to prove they are not cross-site requests.
+func (s *Server) handleTransmission(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(TransmissionSessionHeader) != s.transmissionSession {
+		w.Header().Set(TransmissionSessionHeader, s.transmissionSession)
+		http.Error(w, "409: Conflict, the X-Transmission-Session-Id header is missing or outdated", http.StatusConflict)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405: Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req transmissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "400: Bad Request, "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Arguments) == 0 {
+		req.Arguments = json.RawMessage("{}")
+	}
+
+	var (
+		args any
+		err  error
+	)
+	switch req.Method {
+	case "torrent-add":
+		args, err = s.transmissionAdd(r.Context(), req.Arguments)
+	case "torrent-get":
+		args, err = s.transmissionGet(req.Arguments)
+	case "torrent-start", "torrent-start-now":
+		args, err = s.transmissionEach(req.Arguments, func(c *p2p.Client) error { c.Resume(); return nil })
+	case "torrent-stop":
+		args, err = s.transmissionEach(req.Arguments, func(c *p2p.Client) error { c.Pause(); return nil })
+	case "torrent-remove":
+		args, err = s.transmissionEach(req.Arguments, func(c *p2p.Client) error { return s.session.Remove(c.InfoHash()) })
+	case "session-get":
+		args, err = s.transmissionSessionGet()
+	case "session-set":
+		args, err = s.transmissionSessionSet(req.Arguments)
+	case "session-stats":
+		args, err = s.transmissionStats()
+	default:
+		err = fmt.Errorf("method name not recognized: %q", req.Method)
+	}
+
+	resp := transmissionResponse{Result: "success", Arguments: args, Tag: req.Tag}
+	if err != nil {
+		resp.Result = err.Error()
+		resp.Arguments = struct{}{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func newTransmissionSession() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// transmissionID returns the ID of the torrent, the IDs are numbered in the order the torrents
+// were first seen and are not reused.
+func (s *Server) transmissionID(c *p2p.Client) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.ids[c.InfoHash()]
+	if !ok {
+		s.lastID++
+		id = s.lastID
+		s.ids[c.InfoHash()] = id
+	}
+	return id
+}
+
+// transmissionTorrents returns the torrents selected by the "ids" argument.
+func (s *Server) transmissionTorrents(raw json.RawMessage) ([]*p2p.Client, error) {
+	var args transmissionIDs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	clients := s.session.Torrents()
+	if len(args.IDs) == 0 {
+		return clients, nil
+	}
+
+	var ids any
+	if err := json.Unmarshal(args.IDs, &ids); err != nil {
+		return nil, err
+	}
+	if ids == "recently-active" {
+		// Every torrent is reported, there is no record of when they were last active.
+		return clients, nil
+	}
+	list, ok := ids.([]any)
+	if !ok {
+		list = []any{ids}
+	}
+
+	var selected []*p2p.Client
+	for _, c := range clients {
+		id, hash := s.transmissionID(c), FormatInfoHash(c.InfoHash())
+		if slices.ContainsFunc(list, func(v any) bool {
+			switch v := v.(type) {
+			case float64:
+				return int(v) == id
+			case string:
+				return strings.EqualFold(v, hash)
+			}
+			return false
+		}) {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+// transmissionEach runs the action on the torrents selected by the "ids" argument.
+func (s *Server) transmissionEach(raw json.RawMessage, action func(c *p2p.Client) error) (any, error) {
+	clients, err := s.transmissionTorrents(raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clients {
+		if err := action(c); err != nil {
+			return nil, err
+		}
+	}
+	return struct{}{}, nil
+}
+
+func (s *Server) transmissionAdd(ctx context.Context, raw json.RawMessage) (any, error) {
+	var args struct {
+		Filename string `json:"filename"` // a magnet link or a .torrent file of the daemon
+		Metainfo []byte `json:"metainfo"` // the base64 contents of a .torrent file
+		Paused   bool   `json:"paused"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var (
+		torrent *bencode.Torrent
+		data    = args.Metainfo
+	)
+	switch {
+	case len(data) > 0:
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		magnet, err := bencode.ParseMagnet(args.Filename)
+		if err != nil {
+			return nil, err
+		}
+		if torrent, err = s.fetchMagnet(ctx, magnet); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(args.Filename, "http://"), strings.HasPrefix(args.Filename, "https://"):
+		return nil, errors.New("adding a torrent from a URL is not supported, send its metainfo")
+	case args.Filename != "":
+		var err error
+		if data, err = os.ReadFile(args.Filename); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("no filename or metainfo")
+	}
+
+	if torrent == nil {
+		var err error
+		if torrent, err = bencode.NewTorrent(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	added := "torrent-added"
+	c, err := s.add(torrent)
+	if errors.Is(err, p2p.ErrTorrentExists) {
+		added = "torrent-duplicate"
+		var ok bool
+		if c, ok = s.session.Torrent(torrent.File.InfoHashSum); !ok {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if args.Paused && added == "torrent-added" {
+		c.Pause()
+	}
+
+	return map[string]any{added: map[string]any{
+		"id":         s.transmissionID(c),
+		"name":       string(torrent.File.Info.Name),
+		"hashString": FormatInfoHash(c.InfoHash()),
+	}}, nil
+}
+
+func (s *Server) transmissionGet(raw json.RawMessage) (any, error) {
+	var args struct {
+		Fields []string `json:"fields"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if len(args.Fields) == 0 {
+		return nil, errors.New("no fields")
+	}
+	clients, err := s.transmissionTorrents(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := make([]map[string]any, 0, len(clients))
+	for _, c := range clients {
+		t := transmissionTorrent{Client: c, id: s.transmissionID(c), stats: c.Stats(), dir: s.DownloadDir}
+		fields := make(map[string]any, len(args.Fields))
+		for _, name := range args.Fields {
+			// Unknown fields are left out, like Transmission does.
+			if field, ok := transmissionFields[name]; ok {
+				fields[name] = field(&t)
+			}
+		}
+		torrents = append(torrents, fields)
+	}
+	return map[string]any{"torrents": torrents}, nil
+}
+
+// transmissionTorrent is a torrent while its fields are read.
+type transmissionTorrent struct {
+	*p2p.Client
+	id    int
+	stats p2p.Stats
+	dir   string
+}
+
+func (t *transmissionTorrent) left() int64 {
+	return t.stats.Length - int64(float64(t.stats.Length)*t.stats.Progress())
+}
+
+func (t *transmissionTorrent) status() int {
+	switch {
+	case t.stats.Paused:
+		return transmissionStopped
+	case t.stats.Done():
+		return transmissionSeeding
+	}
+	return transmissionDownloading
+}
+
+// transmissionFields are the fields torrent-get can return.
+var transmissionFields = map[string]func(t *transmissionTorrent) any{
+	"id":             func(t *transmissionTorrent) any { return t.id },
+	"name":           func(t *transmissionTorrent) any { return t.stats.Name },
+	"hashString":     func(t *transmissionTorrent) any { return FormatInfoHash(t.stats.InfoHash) },
+	"status":         func(t *transmissionTorrent) any { return t.status() },
+	"error":          func(t *transmissionTorrent) any { return 0 },
+	"errorString":    func(t *transmissionTorrent) any { return "" },
+	"downloadDir":    func(t *transmissionTorrent) any { return t.dir },
+	"totalSize":      func(t *transmissionTorrent) any { return t.stats.Length },
+	"sizeWhenDone":   func(t *transmissionTorrent) any { return t.stats.Length },
+	"leftUntilDone":  func(t *transmissionTorrent) any { return t.left() },
+	"haveValid":      func(t *transmissionTorrent) any { return t.stats.Length - t.left() },
+	"percentDone":    func(t *transmissionTorrent) any { return t.stats.Progress() },
+	"isFinished":     func(t *transmissionTorrent) any { return t.stats.Done() },
+	"rateDownload":   func(t *transmissionTorrent) any { return int64(t.stats.DownloadRate) },
+	"rateUpload":     func(t *transmissionTorrent) any { return int64(t.stats.UploadRate) },
+	"downloadedEver": func(t *transmissionTorrent) any { return t.stats.Downloaded },
+	"uploadedEver":   func(t *transmissionTorrent) any { return t.stats.Uploaded },
+	"uploadRatio": func(t *transmissionTorrent) any {
+		if t.stats.Downloaded == 0 {
+			return -1 // Transmission's TR_RATIO_NA
+		}
+		return float64(t.stats.Uploaded) / float64(t.stats.Downloaded)
+	},
+	"eta": func(t *transmissionTorrent) any {
+		if eta := t.stats.ETA(); eta >= 0 {
+			return int64(eta / time.Second)
+		}
+		return -1
+	},
+	"peersConnected":   func(t *transmissionTorrent) any { return t.stats.Peers },
+	"peersSendingToUs": func(t *transmissionTorrent) any { return t.stats.Peers },
+	"pieceCount":       func(t *transmissionTorrent) any { return t.stats.PiecesTotal },
+	"pieceSize":        func(t *transmissionTorrent) any { return int64(t.Torrent().File.Info.PieceLength) },
+	"isPrivate":        func(t *transmissionTorrent) any { return t.Torrent().File.Info.Private },
+	"downloadLimit":    func(t *transmissionTorrent) any { return t.DownloadLimit() / transmissionSpeedBytes },
+	"downloadLimited":  func(t *transmissionTorrent) any { return t.DownloadLimit() > 0 },
+	"uploadLimit":      func(t *transmissionTorrent) any { return t.UploadLimit() / transmissionSpeedBytes },
+	"uploadLimited":    func(t *transmissionTorrent) any { return t.UploadLimit() > 0 },
+	"files": func(t *transmissionTorrent) any {
+		var files []map[string]any
+		for _, f := range t.Files() {
+			files = append(files, map[string]any{"name": f.Path, "length": f.Length, "bytesCompleted": int64(float64(f.Length) * f.Progress)})
+		}
+		return files
+	},
+	"fileStats": func(t *transmissionTorrent) any {
+		var files []map[string]any
+		for _, f := range t.Files() {
+			files = append(files, map[string]any{
+				"bytesCompleted": int64(float64(f.Length) * f.Progress),
+				"wanted":         f.Priority != p2p.PrioritySkip,
+				"priority":       int(max(f.Priority, p2p.PriorityLow)),
+			})
+		}
+		return files
+	},
+	"priorities": func(t *transmissionTorrent) any {
+		var priorities []int
+		for _, f := range t.Files() {
+			priorities = append(priorities, int(max(f.Priority, p2p.PriorityLow)))
+		}
+		return priorities
+	},
+	"peers": func(t *transmissionTorrent) any {
+		var peers []map[string]any
+		for _, p := range t.PeerStats() {
+			host, port, _ := net.SplitHostPort(p.Addr)
+			portNumber, _ := strconv.Atoi(port)
+			peers = append(peers, map[string]any{
+				"address":      host,
+				"port":         portNumber,
+				"clientName":   p.Client.String(),
+				"flagStr":      p.Flags,
+				"isIncoming":   strings.Contains(p.Flags, "I"),
+				"isUTP":        strings.Contains(p.Flags, "P"),
+				"progress":     p.Progress,
+				"rateToClient": int64(p.DownloadRate),
+				"rateToPeer":   int64(p.UploadRate),
+			})
+		}
+		return peers
+	},
+	"trackers": func(t *transmissionTorrent) any {
+		var trackers []map[string]any
+		for tier, urls := range t.Trackers() {
+			for _, u := range urls {
+				trackers = append(trackers, map[string]any{"id": len(trackers), "announce": u, "tier": tier})
+			}
+		}
+		return trackers
+	},
+}
+
+func (s *Server) transmissionSessionGet() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{
+		"version":                  "gobittorrent",
+		"rpc-version":              TransmissionRPCVersion,
+		"rpc-version-minimum":      TransmissionRPCVersionMinimum,
+		"session-id":               s.transmissionSession,
+		"download-dir":             s.DownloadDir,
+		"peer-port":                s.session.Port(),
+		"peer-limit-global":        s.session.MaxConnections(),
+		"speed-limit-down":         s.speedLimitDown,
+		"speed-limit-down-enabled": s.session.DownloadLimit() > 0,
+		"speed-limit-up":           s.speedLimitUp,
+		"speed-limit-up-enabled":   s.session.UploadLimit() > 0,
+		"dht-enabled":              false,
+		"encryption":               "tolerated",
+		"units":                    map[string]any{"speed-bytes": transmissionSpeedBytes, "speed-units": []string{"kB/s", "MB/s", "GB/s", "TB/s"}},
+	}, nil
+}
+
+func (s *Server) transmissionSessionSet(raw json.RawMessage) (any, error) {
+	var args struct {
+		SpeedLimitDown        *int  `json:"speed-limit-down"` // kB/s
+		SpeedLimitDownEnabled *bool `json:"speed-limit-down-enabled"`
+		SpeedLimitUp          *int  `json:"speed-limit-up"`
+		SpeedLimitUpEnabled   *bool `json:"speed-limit-up-enabled"`
+		PeerLimitGlobal       *int  `json:"peer-limit-global"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	for _, limit := range []*int{args.SpeedLimitDown, args.SpeedLimitUp, args.PeerLimitGlobal} {
+		if limit != nil && *limit < 0 {
+			return nil, fmt.Errorf("negative limit %d", *limit)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A limit is kept while it is disabled, like Transmission does.
+	apply := func(limit *int, enabled *bool, kept *int, current int, set func(int)) {
+		if limit == nil && enabled == nil {
+			return
+		}
+		if limit != nil {
+			*kept = *limit
+		}
+		on := current > 0
+		if enabled != nil {
+			on = *enabled
+		}
+		if on {
+			set(*kept * transmissionSpeedBytes)
+		} else {
+			set(0)
+		}
+	}
+	apply(args.SpeedLimitDown, args.SpeedLimitDownEnabled, &s.speedLimitDown, s.session.DownloadLimit(), s.session.SetDownloadLimit)
+	apply(args.SpeedLimitUp, args.SpeedLimitUpEnabled, &s.speedLimitUp, s.session.UploadLimit(), s.session.SetUploadLimit)
+	if args.PeerLimitGlobal != nil {
+		s.session.SetMaxConnections(*args.PeerLimitGlobal)
+	}
+	return struct{}{}, nil
+}
+
+func (s *Server) transmissionStats() (any, error) {
+	clients := s.session.Torrents()
+	var (
+		paused               int
+		download, upload     float64
+		downloaded, uploaded int64
+	)
+	for _, c := range clients {
+		stats := c.Stats()
+		if stats.Paused {
+			paused++
+		}
+		download += stats.DownloadRate
+		upload += stats.UploadRate
+		downloaded += stats.Downloaded
+		uploaded += stats.Uploaded
+	}
+
+	// The session does not outlive the daemon, the cumulative stats are the current ones.
+	current := map[string]any{
+		"downloadedBytes": downloaded,
+		"uploadedBytes":   uploaded,
+		"filesAdded":      len(clients),
+		"sessionCount":    1,
+		"secondsActive":   int64(time.Since(s.started) / time.Second),
+	}
+	return map[string]any{
+		"torrentCount":       len(clients),
+		"activeTorrentCount": len(clients) - paused,
+		"pausedTorrentCount": paused,
+		"downloadSpeed":      int64(download),
+		"uploadSpeed":        int64(upload),
+		"current-stats":      current,
+		"cumulative-stats":   current,
+	}, nil
+}
@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// transmissionClient calls the Transmission endpoint like the Transmission clients do.
+type transmissionClient struct {
+	t       *testing.T
+	url     string
+	session string
+}
+
+// call runs the method and returns the result and the arguments of the response.
+func (c *transmissionClient) call(method string, args any) (string, map[string]any) {
+	c.t.Helper()
+
+	body, err := json.Marshal(map[string]any{"method": method, "arguments": args, "tag": 7})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for range 2 {
+		req, err := http.NewRequest(http.MethodPost, c.url+"/transmission/rpc", bytes.NewReader(body))
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		req.SetBasicAuth("admin", testToken)
+		req.Header.Set(TransmissionSessionHeader, c.session)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusConflict {
+			c.session = resp.Header.Get(TransmissionSessionHeader)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.t.Fatalf("%s: status = %d", method, resp.StatusCode)
+		}
+
+		var result struct {
+			Result    string         `json:"result"`
+			Arguments map[string]any `json:"arguments"`
+			Tag       int            `json:"tag"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			c.t.Fatal(err)
+		}
+		if result.Tag != 7 {
+			c.t.Errorf("%s: tag = %d, want 7", method, result.Tag)
+		}
+		return result.Result, result.Arguments
+	}
+
+	c.t.Fatalf("%s: the session ID was refused twice", method)
+	return "", nil
+}
+
+func TestTransmissionSessionID(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/transmission/rpc", bytes.NewReader([]byte(`{"method":"session-get"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("", testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(TransmissionSessionHeader) == "" {
+		t.Errorf("status = %d, session ID = %q, want 409 with a session ID", resp.StatusCode, resp.Header.Get(TransmissionSessionHeader))
+	}
+
+	req.SetBasicAuth("", "wrong")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d with a wrong password, want 401", resp.StatusCode)
+	}
+}
+
+func TestTransmission(t *testing.T) {
+	session, srv := newTestServer(t)
+	c := &transmissionClient{t: t, url: srv.URL}
+
+	result, args := c.call("torrent-add", map[string]any{"metainfo": newTestTorrent(t, "a.bin"), "paused": true})
+	added, _ := args["torrent-added"].(map[string]any)
+	if result != "success" || added["name"] != "a.bin" || added["id"] != 1.0 {
+		t.Fatalf("torrent-add = %q, %v", result, args)
+	}
+	hash := added["hashString"].(string)
+
+	data := newTestTorrent(t, "b.bin")
+	if result, _ := c.call("torrent-add", map[string]any{"metainfo": data}); result != "success" {
+		t.Fatalf("torrent-add = %q", result)
+	}
+	if _, args := c.call("torrent-add", map[string]any{"metainfo": data}); args["torrent-duplicate"] == nil {
+		t.Errorf("torrent-add of the same torrent = %v, want torrent-duplicate", args)
+	}
+	if _, args := c.call("torrent-add", map[string]any{"filename": "magnet:?xt=urn:btih:" + hash}); args["torrent-duplicate"] == nil {
+		t.Errorf("torrent-add of the magnet link of an added torrent = %v, want torrent-duplicate", args)
+	}
+	if result, _ := c.call("torrent-add", map[string]any{"filename": "magnet:?xt=urn:btih:abababababababababababababababababababab"}); result == "success" {
+		t.Error("torrent-add of a magnet link without trackers succeeded")
+	}
+
+	// Field selection, the unknown fields are left out.
+	result, args = c.call("torrent-get", map[string]any{"ids": []any{hash}, "fields": []string{"id", "name", "status", "totalSize", "files", "unknown"}})
+	torrents, _ := args["torrents"].([]any)
+	if result != "success" || len(torrents) != 1 {
+		t.Fatalf("torrent-get = %q, %v", result, args)
+	}
+	torrent := torrents[0].(map[string]any)
+	if len(torrent) != 5 || torrent["id"] != 1.0 || torrent["status"] != float64(transmissionStopped) || torrent["totalSize"] != 50000.0 {
+		t.Errorf("torrent-get = %v", torrent)
+	}
+
+	tests := []struct {
+		name string
+		ids  any
+		want int
+	}{
+		{name: "Every torrent", want: 2},
+		{name: "ID", ids: 2, want: 1},
+		{name: "IDs and hashes", ids: []any{1, hash}, want: 1},
+		{name: "Recently active", ids: "recently-active", want: 2},
+		{name: "Unknown ID", ids: []any{9}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"fields": []string{"id"}}
+			if tt.ids != nil {
+				args["ids"] = tt.ids
+			}
+			_, resp := c.call("torrent-get", args)
+			if torrents, _ := resp["torrents"].([]any); len(torrents) != tt.want {
+				t.Errorf("torrent-get = %v, want %d torrents", resp, tt.want)
+			}
+		})
+	}
+
+	if result, _ := c.call("torrent-start", map[string]any{"ids": 1}); result != "success" {
+		t.Errorf("torrent-start = %q", result)
+	}
+	if client, _ := session.Torrent(mustParseInfoHash(t, hash)); client.Paused() {
+		t.Error("the torrent is paused after torrent-start")
+	}
+	if result, _ := c.call("torrent-stop", map[string]any{"ids": []any{hash}}); result != "success" {
+		t.Errorf("torrent-stop = %q", result)
+	}
+	if client, _ := session.Torrent(mustParseInfoHash(t, hash)); !client.Paused() {
+		t.Error("the torrent is running after torrent-stop")
+	}
+
+	if result, _ := c.call("session-set", map[string]any{"speed-limit-down": 100, "speed-limit-down-enabled": true, "peer-limit-global": 20}); result != "success" {
+		t.Errorf("session-set = %q", result)
+	}
+	if session.DownloadLimit() != 100*transmissionSpeedBytes || session.MaxConnections() != 20 {
+		t.Errorf("session-set: download limit = %d, max connections = %d", session.DownloadLimit(), session.MaxConnections())
+	}
+	c.call("session-set", map[string]any{"speed-limit-down-enabled": false})
+	if session.DownloadLimit() != 0 {
+		t.Errorf("session-set: download limit = %d after it was disabled, want 0", session.DownloadLimit())
+	}
+	_, args = c.call("session-get", nil)
+	if args["speed-limit-down"] != 100.0 || args["speed-limit-down-enabled"] != false || args["rpc-version"] != float64(TransmissionRPCVersion) {
+		t.Errorf("session-get = %v", args)
+	}
+
+	_, args = c.call("session-stats", nil)
+	if args["torrentCount"] != 2.0 || args["pausedTorrentCount"] != 1.0 {
+		t.Errorf("session-stats = %v", args)
+	}
+
+	if result, _ := c.call("torrent-remove", map[string]any{"ids": []any{1}}); result != "success" {
+		t.Errorf("torrent-remove = %q", result)
+	}
+	if n := len(session.Torrents()); n != 1 {
+		t.Errorf("the session has %d torrents after torrent-remove, want 1", n)
+	}
+
+	if result, _ := c.call("torrent-reannounce", nil); result == "success" {
+		t.Error("an unknown method succeeded")
+	}
+}
+
+func mustParseInfoHash(t *testing.T, s string) [20]byte {
+	t.Helper()
+	infoHash, err := ParseInfoHash(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return infoHash
+}
@@ -0,0 +1,112 @@
+// Package metrics exports the stats of a session in the Prometheus format.
+package metrics
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the names of the metrics.
+const Namespace = "gobittorrent"
+
+// Collector reads the stats of the torrents of a session on every scrape, so the torrents that are
+// added or removed show up without registering anything.
+type Collector struct {
+	session *p2p.Session
+
+	torrents        *prometheus.Desc
+	connections     *prometheus.Desc
+	downloaded      *prometheus.Desc
+	uploaded        *prometheus.Desc
+	piecesTotal     *prometheus.Desc
+	piecesCompleted *prometheus.Desc
+	hashFailures    *prometheus.Desc
+	peers           *prometheus.Desc
+	chokeState      *prometheus.Desc
+	queueDepth      *prometheus.Desc
+	announces       *prometheus.Desc
+	announceLatency *prometheus.Desc
+}
+
+// NewCollector returns the collector of the session.
+func NewCollector(session *p2p.Session) *Collector {
+	torrentLabels := []string{"torrent", "info_hash"}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", name), help, labels, nil)
+	}
+	return &Collector{
+		session:         session,
+		torrents:        desc("torrents", "The torrents in the session."),
+		connections:     desc("connections", "The open peer connections of the session."),
+		downloaded:      desc("downloaded_bytes_total", "The bytes received from peers and web seeds.", torrentLabels...),
+		uploaded:        desc("uploaded_bytes_total", "The bytes sent to peers.", torrentLabels...),
+		piecesTotal:     desc("pieces", "The pieces of the torrent.", torrentLabels...),
+		piecesCompleted: desc("pieces_completed", "The downloaded and verified pieces.", torrentLabels...),
+		hashFailures:    desc("hash_failures_total", "The downloaded pieces that did not match their hash.", torrentLabels...),
+		peers:           desc("peers", "The peers by state: connected, seed or half_open.", append(torrentLabels, "state")...),
+		chokeState:      desc("peer_choke_state", "The connected peers by whether they choke us: choked or unchoked.", append(torrentLabels, "state")...),
+		queueDepth:      desc("queue_depth", "The pieces waiting for a peer and the requested blocks that did not arrive yet.", append(torrentLabels, "queue")...),
+		announces:       desc("tracker_announces_total", "The announces to the tracker by result: success or failure.", append(torrentLabels, "tracker", "result")...),
+		announceLatency: desc("tracker_announce_duration_seconds", "The time the announces to the tracker took.", append(torrentLabels, "tracker")...),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.torrents, c.connections, c.downloaded, c.uploaded, c.piecesTotal, c.piecesCompleted,
+		c.hashFailures, c.peers, c.chokeState, c.queueDepth, c.announces, c.announceLatency,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	torrents := c.session.Torrents()
+	ch <- prometheus.MustNewConstMetric(c.torrents, prometheus.GaugeValue, float64(len(torrents)))
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(c.session.ConnectionCount()))
+
+	for _, t := range torrents {
+		stats := t.Stats()
+		infoHash := stats.InfoHash
+		labels := []string{stats.Name, hex.EncodeToString(infoHash[:])}
+		metric := func(desc *prometheus.Desc, typ prometheus.ValueType, v float64, extra ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, typ, v, append(labels[:2:2], extra...)...)
+		}
+
+		metric(c.downloaded, prometheus.CounterValue, float64(stats.Downloaded))
+		metric(c.uploaded, prometheus.CounterValue, float64(stats.Uploaded))
+		metric(c.piecesTotal, prometheus.GaugeValue, float64(stats.PiecesTotal))
+		metric(c.piecesCompleted, prometheus.GaugeValue, float64(stats.PiecesDone))
+		metric(c.hashFailures, prometheus.CounterValue, float64(stats.HashFailures))
+		metric(c.peers, prometheus.GaugeValue, float64(stats.Peers), "connected")
+		metric(c.peers, prometheus.GaugeValue, float64(stats.Seeds), "seed")
+		metric(c.peers, prometheus.GaugeValue, float64(stats.HalfOpen), "half_open")
+		metric(c.chokeState, prometheus.GaugeValue, float64(stats.Choked), "choked")
+		metric(c.chokeState, prometheus.GaugeValue, float64(stats.Peers-stats.Choked), "unchoked")
+		metric(c.queueDepth, prometheus.GaugeValue, float64(stats.QueuedPieces), "pieces")
+		metric(c.queueDepth, prometheus.GaugeValue, float64(stats.Requests), "requests")
+
+		for _, tracker := range t.TrackerStats() {
+			metric(c.announces, prometheus.CounterValue, float64(tracker.Announces), tracker.URL, "success")
+			metric(c.announces, prometheus.CounterValue, float64(tracker.Failures), tracker.URL, "failure")
+			ch <- prometheus.MustNewConstSummary(c.announceLatency, uint64(tracker.Announces+tracker.Failures),
+				tracker.Latency.Seconds(), nil, append(labels[:2:2], tracker.URL)...)
+		}
+	}
+}
+
+// Handler serves the metrics of the session, with the ones of the Go runtime and the process.
+func Handler(session *p2p.Session) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		NewCollector(session),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
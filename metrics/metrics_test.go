@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+func TestHandler(t *testing.T) {
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("gobittorrent"), 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := bencode.NewBuilder(path)
+	b.Announce = tracker.URL + "/announce"
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := bencode.NewTorrent(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := p2p.NewSession(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	c, err := session.Add(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Pause()
+	if _, err := c.Announce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(session))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infoHash := c.InfoHash()
+	hash := `info_hash="` + hex.EncodeToString(infoHash[:]) + `"`
+	labels := hash + `,torrent="file.bin"`
+	trackerLabel := `tracker="` + tracker.URL + `/announce"`
+	for _, want := range []string{
+		"gobittorrent_torrents 1",
+		"gobittorrent_downloaded_bytes_total{" + labels + "} 0",
+		"gobittorrent_pieces{" + labels + "} 8",
+		"gobittorrent_pieces_completed{" + labels + "} 0",
+		"gobittorrent_hash_failures_total{" + labels + "} 0",
+		"gobittorrent_peers{" + hash + `,state="connected",torrent="file.bin"} 0`,
+		"gobittorrent_peer_choke_state{" + hash + `,state="choked",torrent="file.bin"} 0`,
+		"gobittorrent_queue_depth{" + hash + `,queue="requests",torrent="file.bin"} 0`,
+		"gobittorrent_tracker_announces_total{" + hash + `,result="failure",torrent="file.bin",` + trackerLabel + "} 0",
+		"gobittorrent_tracker_announces_total{" + hash + `,result="success",torrent="file.bin",` + trackerLabel + "} ", // the session announces too
+		"gobittorrent_tracker_announce_duration_seconds_count{" + labels + "," + trackerLabel + "} ",
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("the metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -1,136 +1,370 @@
 package p2p
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
-	"net/http"
-	"runtime"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/ratelimit"
+	"github.com/handsomefox/gobittorrent/utp"
 )
 
 const (
 	ChunkSize = 16 * 1024
 
 	HandshakeMessageLength               = 68
-	ReadDeadline           time.Duration = time.Second * 10
-	WriteDeadline          time.Duration = time.Second * 10
+	ReadDeadline           time.Duration = time.Second * 10 // the default ClientConfig.ReadTimeout
+	WriteDeadline          time.Duration = time.Second * 10 // the default ClientConfig.WriteTimeout
+	UTPDialTimeout         time.Duration = time.Second * 3  // the default ClientConfig.UTPDialTimeout
+	// AnnounceRetryInterval is how long to wait before announcing again if the first announce failed.
+	AnnounceRetryInterval time.Duration = time.Second * 30
+	// DefaultPort is the port reported to the trackers by clients that are not part of a Session.
+	DefaultPort = 6881
 )
 
 type Piece struct {
-	Hash           string
+	Hash           string // hex SHA-1 for v1 and hybrid torrents, hex merkle root for v2 only ones
 	Chunks         []int
 	TotalSize      int
 	DownloadedSize int
 	Index          uint32
+	V2             *PieceV2 // nil for v1 only torrents
 }
 
 type Connection struct {
 	net.Conn
-	quitch chan struct{}
-	peerID string
-	peer   bencode.Peer
+	quitch   chan struct{}
+	peerID   string
+	client   PeerClient
+	peer     bencode.Peer
+	download *ratelimit.Limiter // the per-peer limits
+	upload   *ratelimit.Limiter
+
+	downloaded *rateMeter
+	uploaded   *rateMeter
+	pieces     int  // of the torrent
+	incoming   bool // the peer connected to us
+	bitfield   []byte
+	haveMu     sync.Mutex
+
+	unchoked   atomic.Bool  // the peer unchoked us
+	interested atomic.Bool  // we told the peer we are interested
+	requests   atomic.Int64 // the requested blocks that did not arrive yet
 }
 
 func (c *Connection) PeerID() string {
 	return c.peerID
 }
 
+// Client returns the client software of the peer, parsed from its peer ID.
+func (c *Connection) Client() PeerClient {
+	return c.client
+}
+
 func (c *Connection) Addr() string {
 	return c.peer.Addr()
 }
 
 // Client is the structure for receiving and sending commands between bittorrent clients.
 type Client struct {
-	log    *slog.Logger
-	t      *bencode.Torrent
-	quitch chan struct{}
-	peerID []byte
-
-	pieceQueue chan *Piece
+	log     *slog.Logger
+	t       *bencode.Torrent
+	peerID  []byte
+	key     string      // sent with every announce, stays the same for the lifetime of the client
+	port    int         // the port we accept connections on, reported to the trackers
+	utp     *utp.Socket // nil if the UDP socket could not be opened, then only TCP is used
+	ownsUTP bool        // the socket is closed with the client, unless it is shared by a session
+	session *Session    // nil if the client runs on its own
+	cfg     ClientConfig
+
+	allPieces []Piece // the layout of the pieces, Pieces hands out copies
+
+	peers          *connLimit // the open connections of the torrent
+	slots          *connLimit // the open connections of all the torrents of a session
+	halfOpen       *connLimit // the dials in progress of the torrent
+	globalHalfOpen *connLimit // the dials in progress of all the torrents of a session
+	retries        *retryList
+
+	download     *ratelimit.Limiter // the per-torrent limits
+	upload       *ratelimit.Limiter
+	peerDownload atomic.Int64 // the rates of the limiters of new connections
+	peerUpload   atomic.Int64
+
+	runMu   sync.Mutex
+	running bool
+	closed  bool
+	quitch  chan struct{}      // closed when the client is paused or closed, a new one is made on resume
+	cancel  context.CancelFunc // cancels the context of the run with the quitch, the announces and web seed requests
+
+	pieceQueue *pieceQueue // locked after haveMu
+	trackers   *trackerList
 
 	conns      map[string]*Connection // Addr - Conn
 	connsMu    sync.RWMutex
 	connsCount atomic.Int64
 
-	pieces          map[string][]byte // Hash - Data
+	pieces          map[string][]byte // Hash - Data of the pieces that are being downloaded
 	piecesMu        sync.RWMutex
 	piecesCompleted atomic.Int64
+	hashFailures    atomic.Int64  // the downloaded pieces that did not match their hash
+	completed       chan struct{} // closed when every piece is verified
+	closedch        chan struct{} // closed by Close
+	storage         PieceStorage  // the verified pieces
+	pieceCount      int
+	have            []bool        // the verified pieces by index
+	haveCh          chan struct{} // closed and replaced when a piece is verified or the skipped files change
+	files           []fileSpan
+	priorities      []FilePriority         // of the files
+	streams         map[*Reader]pieceRange // the pieces the readers need next
+	wanted          []bool                 // the pieces that hold a file that is not skipped
+	front           int                    // the first wanted piece that is not verified
+	sequential      bool                   // the pieces are downloaded front to back
+	window          int                    // the pieces from the front a sequential download takes, 0 for all
+	haveMu          sync.RWMutex           // guards have, haveCh, priorities, wanted, streams and the sequential fields
+	queueOnce       sync.Once              // queues the missing pieces
+
+	downloaded *rateMeter // the traffic of all the connections and web seeds
+	uploaded   *rateMeter
+
+	events *eventHub
 }
 
 // NewClient returns a new client that immediately tries to initiate a handshake with the peer.
-func NewClient(log *slog.Logger, peerID []byte, torrent *bencode.Torrent) (*Client, error) {
+// The context bounds the first announce, a torrent with web seeds starts even if it fails. Without
+// options it uses DefaultClientConfig.
+func NewClient(ctx context.Context, log *slog.Logger, torrent *bencode.Torrent, opts ...Option) (*Client, error) {
+	c, err := newClient(log, torrent, newClientConfig(opts...))
+	if err != nil {
+		return nil, err
+	}
+	c.slots = newConnLimit(0)
+	c.globalHalfOpen = newConnLimit(0)
+
+	socket, err := utp.Listen("udp", ":0")
+	if err != nil {
+		log.Warn("Failed to open the uTP socket, using only TCP", "err", err)
+	} else {
+		c.utp = socket
+		c.ownsUTP = true
+	}
+
+	announce, err := c.Announce(ctx)
+	switch {
+	case err != nil && (len(torrent.File.URLList) < 1 || ctx.Err() != nil):
+		c.Close()
+		return nil, err
+	case err != nil:
+		// The web seeds have the data, the trackers are asked again later. The error is in
+		// TrackerStats and the EventAnnounce.
+		log.Warn("Announce failed, starting with the web seeds", "err", err, "retry", AnnounceRetryInterval)
+		announce = &bencode.AnnounceResponse{}
+	}
+
+	// TODO: Maybe continue refetching?
+	if len(announce.Peers) < 1 && len(torrent.File.URLList) < 1 {
+		c.Close()
+		return nil, ErrNoPeers
+	}
+
+	c.start(announce)
+
+	return c, nil
+}
+
+// newClient builds the client from a config that went through withDefaults.
+func newClient(log *slog.Logger, torrent *bencode.Torrent, cfg ClientConfig) (*Client, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	pieces, err := torrentPieces(&torrent.File, cfg.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := cfg.Storage.OpenTorrent(torrent)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Client{
 		log:        log,
 		t:          torrent,
-		quitch:     make(chan struct{}),
-		peerID:     peerID,
-		pieceQueue: make(chan *Piece, len(torrent.File.Info.Pieces)),
+		allPieces:  pieces,
+		peerID:     cfg.PeerID,
+		key:        newKey(),
+		port:       cfg.Port,
+		cfg:        cfg,
+		storage:    storage,
+		peers:      newConnLimit(cfg.MaxPeers),
+		halfOpen:   newConnLimit(cfg.MaxHalfOpen),
+		retries:    newRetryList(cfg.RetryMinBackoff, cfg.RetryMaxBackoff),
+		trackers:   newTrackerList(&torrent.File),
 		conns:      make(map[string]*Connection),
 		connsCount: atomic.Int64{},
 		connsMu:    sync.RWMutex{},
 		pieces:     make(map[string][]byte),
 		piecesMu:   sync.RWMutex{},
+		download:   ratelimit.NewLimiter(ratelimit.Unlimited),
+		upload:     ratelimit.NewLimiter(ratelimit.Unlimited),
+		downloaded: newRateMeter(),
+		uploaded:   newRateMeter(),
+		events:     newEventHub(),
 	}
-
-	announce, err := c.Announce(context.TODO())
-	if err != nil {
-		return nil, err
+	c.pieceCount = len(c.allPieces)
+	c.completed = make(chan struct{})
+	c.closedch = make(chan struct{})
+	if c.pieceCount == 0 {
+		close(c.completed)
 	}
+	c.have = make([]bool, c.pieceCount)
+	c.haveCh = make(chan struct{})
+	c.streams = make(map[*Reader]pieceRange)
+	c.files = fileSpans(&torrent.File)
+	c.priorities = make([]FilePriority, len(c.files))
+	c.wanted = make([]bool, c.pieceCount)
+	c.pieceQueue = newPieceQueue(c.pieceCount)
+	c.updateWantedLocked()
+	c.updateQueueLocked()
+	return c, nil
+}
 
-	// TODO: Maybe continue refetching?
-	if len(announce.Peers) < 1 {
-		return nil, ErrNoPeers
+// start runs the web seeds, the peer connections and the announce loop until the client is paused or closed.
+// If announce is nil, the trackers are asked for peers first.
+func (c *Client) start(announce *bencode.AnnounceResponse) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	if c.running || c.closed {
+		return
+	}
+	c.running = true
+	c.quitch = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.emit(Event{Type: EventStateChanged, State: StateRunning})
+
+	var globalDownload *ratelimit.Limiter
+	if c.session != nil {
+		globalDownload = c.session.download
+	}
+	for _, u := range c.t.File.URLList {
+		ws := NewWebSeed(string(u), c.cfg.HTTPClient)
+		ws.UserAgent = c.cfg.UserAgent
+		ws.Download = []*ratelimit.Limiter{globalDownload, c.download}
+		go c.handleWebSeed(ctx, ws, c.quitch)
 	}
 
-	go c.addMissingConnections(announce)
-	go c.refetchAnnounce(int64(announce.Interval))
+	if announce != nil {
+		go c.addMissingConnections(announce, c.quitch)
+		go c.refetchAnnounce(ctx, time.Second*time.Duration(announce.Interval), c.quitch)
+		return
+	}
 
-	return c, nil
+	go func(quitch chan struct{}) {
+		announce, err := c.Announce(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.log.Warn("Announce failed, retrying later", "err", err, "retry", AnnounceRetryInterval)
+			}
+			c.refetchAnnounce(ctx, AnnounceRetryInterval, quitch)
+			return
+		}
+		c.addMissingConnections(announce, quitch)
+		c.refetchAnnounce(ctx, time.Second*time.Duration(announce.Interval), quitch)
+	}(c.quitch)
 }
 
-// Download starts the download and blocks until the download finished or errors out.
-func (c *Client) Download(w io.Writer) error {
-	pieces := c.Pieces()
+// stop closes the connections and stops the goroutines started by start, the downloaded pieces are kept.
+// It reports whether the client was running.
+func (c *Client) stop() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
 
-	go func() {
-		for _, p := range pieces {
-			p := p
-			c.pieceQueue <- &p
-		}
-	}()
+	if !c.running {
+		return false
+	}
+	c.running = false
+	close(c.quitch)
+	c.cancel()
+	c.clearConnections()
+	return true
+}
+
+// runQuitch returns the channel that is closed when the current run stops, nil if the client is not running.
+func (c *Client) runQuitch() chan struct{} {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if !c.running {
+		return nil
+	}
+	return c.quitch
+}
 
-	for c.piecesCompleted.Load() != int64(len(pieces)) {
-		runtime.Gosched()
+// Pause disconnects from the peers and stops announcing, the download continues after Resume.
+func (c *Client) Pause() {
+	if c.stop() {
+		c.emit(Event{Type: EventStateChanged, State: StatePaused})
 	}
+}
 
-	c.piecesMu.Lock()
-	defer c.piecesMu.Unlock()
+// Resume starts a paused client again.
+func (c *Client) Resume() {
+	c.start(nil)
+}
 
-	for _, hash := range c.t.File.Info.PieceHashes {
-		piece, ok := c.pieces[hash]
-		if !ok {
-			return ErrPieceNotFound
-		}
+// Paused reports whether the client is paused (or closed).
+func (c *Client) Paused() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	return !c.running
+}
 
-		sumBytes := sha1.Sum(piece)
-		sumHex := hex.EncodeToString(sumBytes[:])
+// InfoHash returns the info hash the client uses in handshakes and announces.
+func (c *Client) InfoHash() [20]byte {
+	return c.t.File.ProtocolInfoHash()
+}
 
-		if sumHex != hash {
-			return ErrInvalidPieceHash
+// Torrent returns the torrent the client downloads.
+func (c *Client) Torrent() *bencode.Torrent {
+	return c.t
+}
+
+// Download starts the download and writes the data to w in order, every piece as soon as it and
+// the pieces before it are verified, so w does not have to seek. It blocks until the whole torrent
+// is written, returns ErrClientClosed if the client is closed before that and the error of the
+// context when it is done, the client keeps running then, see Shutdown. SetSequential makes the
+// writes steady instead of bursty. It returns ErrPieceSkipped when it gets to a piece of skipped
+// files, use Wait and a file storage to download some of the files.
+func (c *Client) Download(ctx context.Context, w io.Writer) error {
+	c.queuePieces()
+
+	pieces := c.Pieces()
+	for i := range pieces {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.waitPiece(int(pieces[i].Index), ctx.Done(), ctx.Err); err != nil {
+			return err
 		}
 
-		if _, err := w.Write(piece); err != nil {
+		data, err := c.storage.ReadPiece(pieces[i].Index)
+		if err != nil {
+			return err
+		}
+
+		if pieces[i].V2 != nil { // Skip the padding of hybrid torrents.
+			data = data[:pieces[i].V2.Length]
+		}
+		if _, err := w.Write(data); err != nil {
 			return err
 		}
 	}
@@ -170,17 +404,65 @@ func (c *Client) HasConnection(addr string) bool {
 }
 
 // Close closes all of the clients connections and stops the refetch announce goroutine.
+// A client that is part of a session is removed from it.
 func (c *Client) Close() error {
 	slog.Debug("closing the client")
-	c.quitch <- struct{}{}
-	close(c.quitch)
-	c.clearConnections()
-	return nil
+	c.runMu.Lock()
+	wasClosed := c.closed
+	if !c.closed {
+		close(c.closedch)
+	}
+	c.closed = true
+	c.runMu.Unlock()
+
+	c.stop()
+	if c.session != nil {
+		c.session.forget(c)
+	}
+	if !wasClosed {
+		c.emit(Event{Type: EventStateChanged, State: StateClosed})
+		c.events.close()
+	}
+	c.closeUTP()
+	return c.storage.Close()
+}
+
+// Shutdown closes the client gracefully: it disconnects from the peers, tells the trackers it
+// stopped (event=stopped) and closes the storage. The client is closed even if the context is done
+// before the trackers answer, the error of the context is returned then.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.runMu.Lock()
+	closed := c.closed
+	c.runMu.Unlock()
+	if closed {
+		return nil
+	}
+
+	c.stop()
+	if err := c.announceStopped(ctx); err != nil {
+		c.log.Debug("Failed to tell the trackers the client stopped", "err", err)
+	}
+	return errors.Join(ctx.Err(), c.Close())
+}
+
+func (c *Client) closeUTP() {
+	if c.utp != nil && c.ownsUTP {
+		c.utp.Close()
+	}
 }
 
 func (c *Client) PieceLengths() []int {
+	return pieceLengths(&c.t.File)
+}
+
+func (c *Client) Pieces() []Piece {
+	return slices.Clone(c.allPieces)
+}
+
+// pieceLengths returns the lengths of the v1 pieces, the last one is shorter.
+func pieceLengths(f *bencode.File) []int {
 	var (
-		info    = &c.t.File.Info
+		info    = &f.Info
 		total   = bencode.Integer(0)
 		lengths = make([]int, 0, len(info.PieceHashes))
 	)
@@ -197,81 +479,66 @@ func (c *Client) PieceLengths() []int {
 	return lengths
 }
 
-func (c *Client) Pieces() []Piece {
-	lengths := c.PieceLengths()
-	pieces := make([]Piece, 0, len(lengths))
+// torrentPieces returns the pieces of the torrent split into blocks of blockSize.
+func torrentPieces(f *bencode.File, blockSize int) ([]Piece, error) {
+	var v2 map[uint32]*PieceV2
+	if f.IsV2() {
+		v2 = v2Pieces(f)
+	}
 
-	for i, l := range lengths {
-		chunks := make([]int, 0)
-		total := 0
-		for total != l {
-			if total+ChunkSize < l {
-				chunks = append(chunks, ChunkSize)
-				total += ChunkSize
-			} else {
-				l := l - total
-				chunks = append(chunks, l)
-				total += l
+	if !f.IsV1() { // Pieces of v2 only torrents come from the file tree.
+		pieces := make([]Piece, 0, len(v2))
+		for i := range uint32(len(v2)) {
+			p := v2[i]
+			hash, err := p.Hash(f)
+			if err != nil {
+				return nil, err
 			}
+			pieces = append(pieces, Piece{
+				Index:     i,
+				Chunks:    chunkSizes(p.Length, blockSize),
+				TotalSize: p.Length,
+				Hash:      hex.EncodeToString(hash[:]),
+				V2:        p,
+			})
 		}
+		return pieces, nil
+	}
+
+	lengths := pieceLengths(f)
+	pieces := make([]Piece, 0, len(lengths))
 
+	for i, l := range lengths {
 		pieces = append(pieces, Piece{
 			Index:     uint32(i),
-			Chunks:    chunks,
-			TotalSize: total,
-			Hash:      c.t.File.Info.PieceHashes[i],
+			Chunks:    chunkSizes(l, blockSize),
+			TotalSize: l,
+			Hash:      f.Info.PieceHashes[i],
+			V2:        v2[uint32(i)],
 		})
 	}
 
-	return pieces
+	return pieces, nil
 }
 
-// Announce sends the request to the tracker to get the latest announce message.
-func (c *Client) Announce(ctx context.Context) (*bencode.AnnounceResponse, error) {
-	announceReq := bencode.AnnounceMessage{
-		Announce:   c.t.File.Announce,
-		InfoHash:   bencode.String(hex.EncodeToString(c.t.File.InfoHashSum[:])),
-		PeerID:     "00112233445566778899",
-		Port:       6881,
-		Uploaded:   0,
-		Downloaded: 0,
-		Left:       c.t.File.Info.Length,
-		Compact:    1,
-	}
-
-	u, err := announceReq.URL()
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// chunkSizes splits the piece into the blocks that are requested from peers.
+func chunkSizes(l, blockSize int) []int {
+	if blockSize <= 0 {
+		blockSize = ChunkSize
 	}
-
-	decoded, err := bencode.NewDecoder(bytes.NewReader(body)).Decode()
-	if err != nil {
-		return nil, err
-	}
-
-	announce := new(bencode.AnnounceResponse)
-
-	if err := announce.Unmarshal(decoded); err != nil {
-		return nil, err
+	chunks := make([]int, 0)
+	total := 0
+	for total != l {
+		if total+blockSize < l {
+			chunks = append(chunks, blockSize)
+			total += blockSize
+		} else {
+			l := l - total
+			chunks = append(chunks, l)
+			total += l
+		}
 	}
-
-	return announce, nil
+	return chunks
 }
 
 // DiscoverPeers returns the peers from the announce message.
@@ -287,6 +554,7 @@ func (c *Client) addConnection(conn *Connection) {
 	c.connsMu.Lock()
 	defer c.connsMu.Unlock()
 	c.conns[conn.peer.Addr()] = conn
+	c.emit(Event{Type: EventPeerConnected, Addr: conn.Addr()})
 }
 
 // removeConnection removes connections based on their peer.Addr().
@@ -299,8 +567,10 @@ func (c *Client) removeConnection(addr string) {
 		return
 	}
 
-	conn.quitch <- struct{}{}
+	close(conn.quitch)
+	conn.Close()
 	delete(c.conns, addr)
+	c.emit(Event{Type: EventPeerDisconnected, Addr: addr})
 }
 
 // clearConnections removes all entries from the connections map.
@@ -310,9 +580,10 @@ func (c *Client) clearConnections() {
 
 	for _, conn := range c.conns {
 		slog.Debug("Closing connection", "addr", conn.Addr())
-		conn.quitch <- struct{}{}
 		close(conn.quitch)
+		conn.Close()
 		slog.Debug("Closed connection", "addr", conn.Addr())
+		c.emit(Event{Type: EventPeerDisconnected, Addr: conn.Addr()})
 	}
 
 	clear(c.conns)
@@ -320,31 +591,126 @@ func (c *Client) clearConnections() {
 
 // startHandshake does the handshake with the peer (by calling sendHandshake) and adds the connection to the pool in the client.
 func (c *Client) startHandshake(peer bencode.Peer, infoHash [20]byte, peerID []byte) error {
-	addr := peer.Addr()
-	conn, err := net.Dial("tcp", addr)
+	if !c.acquireSlot() {
+		return ErrTooManyConnections
+	}
+
+	conn, err := c.dial(peer.Addr())
 	if err != nil {
+		c.releaseSlot()
+		return err
+	}
+
+	pc := c.newConnection(conn, peer)
+	if err := c.sendHandshake(pc, infoHash, peerID); err != nil {
+		pc.Close()
+		c.releaseSlot()
 		return err
 	}
 
-	if err := c.sendHandshake(&Connection{
-		Conn:   conn,
-		quitch: make(chan struct{}),
-		peer:   peer,
-	}, infoHash, peerID); err != nil {
+	return nil
+}
+
+// acceptConnection answers the handshake of a peer that connected to us and adds the connection to the pool.
+func (c *Client) acceptConnection(conn net.Conn, handshake *HandshakeMessage) error {
+	if c.Paused() {
+		return ErrTorrentPaused
+	}
+	if !c.acquireSlot() {
+		return ErrTooManyConnections
+	}
+
+	peer := peerFromAddr(conn.RemoteAddr())
+	if c.HasConnection(peer.Addr()) {
+		c.releaseSlot()
+		return fmt.Errorf("%w, %s", ErrAlreadyConnected, peer.Addr())
+	}
+
+	pc := c.newConnection(conn, peer)
+	pc.incoming = true
+	pc.peerID = hex.EncodeToString(handshake.PeerID)
+	pc.client = ParsePeerID(handshake.PeerID)
+
+	if err := pc.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout)); err != nil {
+		c.releaseSlot()
+		return err
+	}
+	if err := NewHandshakeEncoder(pc).Encode(c.handshakeMessage(handshake.InfoHash)); err != nil {
+		c.releaseSlot()
 		return err
 	}
 
+	c.addConnection(pc)
+	go c.handleConnection(pc)
+
 	return nil
 }
 
+// newConnection wraps the connection with the global, the torrent and the peer rate limiters.
+func (c *Client) newConnection(conn net.Conn, peer bencode.Peer) *Connection {
+	pc := &Connection{
+		quitch:     make(chan struct{}),
+		peer:       peer,
+		download:   ratelimit.NewLimiter(int(c.peerDownload.Load())),
+		upload:     ratelimit.NewLimiter(int(c.peerUpload.Load())),
+		downloaded: newRateMeter(),
+		uploaded:   newRateMeter(),
+		pieces:     c.pieceCount,
+	}
+
+	var globalDownload, globalUpload *ratelimit.Limiter
+	if c.session != nil {
+		globalDownload, globalUpload = c.session.download, c.session.upload
+	}
+	pc.Conn = &meteredConn{
+		Conn: ratelimit.NewConn(conn,
+			[]*ratelimit.Limiter{globalDownload, c.download, pc.download},
+			[]*ratelimit.Limiter{globalUpload, c.upload, pc.upload},
+		),
+		read:    []*rateMeter{c.downloaded, pc.downloaded},
+		written: []*rateMeter{c.uploaded, pc.uploaded},
+	}
+
+	return pc
+}
+
+// peerFromAddr converts the remote address of a TCP or uTP connection to a peer.
+func peerFromAddr(addr net.Addr) bencode.Peer {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return bencode.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	case *net.UDPAddr:
+		return bencode.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	}
+	return bencode.Peer{}
+}
+
+// dial connects to the peer over uTP and falls back to TCP if the peer does not answer.
+func (c *Client) dial(addr string) (net.Conn, error) {
+	if c.utp != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.UTPDialTimeout)
+		defer cancel()
+
+		conn, err := c.utp.DialContext(ctx, addr)
+		if err == nil {
+			return conn, nil
+		}
+		c.log.Debug("uTP dial failed, falling back to TCP", "peer", addr, "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.DialTimeout)
+	defer cancel()
+	return c.cfg.Dialer.DialContext(ctx, "tcp", addr)
+}
+
 // sendHandshake encodes a new handshake message to the connection and decodes the response.
 // If everything is successfull, the connection is then added to the pool.
 func (c *Client) sendHandshake(conn *Connection, infoHash [20]byte, peerID []byte) error {
-	msg := &HandshakeMessage{
-		InfoHash: infoHash[:],
-		PeerID:   peerID,
-	}
+	msg := c.handshakeMessage(infoHash[:])
+	msg.PeerID = peerID
 
+	if err := conn.SetDeadline(time.Now().Add(c.cfg.HandshakeTimeout)); err != nil {
+		return err
+	}
 	if err := NewHandshakeEncoder(conn).Encode(msg); err != nil {
 		return err
 	}
@@ -355,6 +721,7 @@ func (c *Client) sendHandshake(conn *Connection, infoHash [20]byte, peerID []byt
 	}
 
 	conn.peerID = hex.EncodeToString(decoded.PeerID)
+	conn.client = ParsePeerID(decoded.PeerID)
 
 	c.addConnection(conn)
 	go c.handleConnection(conn)
@@ -362,43 +729,109 @@ func (c *Client) sendHandshake(conn *Connection, infoHash [20]byte, peerID []byt
 	return nil
 }
 
+// handshakeMessage returns our handshake for the info hash.
+func (c *Client) handshakeMessage(infoHash []byte) *HandshakeMessage {
+	msg := &HandshakeMessage{
+		InfoHash: infoHash,
+		PeerID:   c.peerID,
+	}
+	if c.t.File.IsV2() {
+		msg.Reserved[7] |= ReservedV2
+	}
+	return msg
+}
+
 // handleConnection is the main loop for handling the command exchange between clients.
 func (c *Client) handleConnection(conn *Connection) {
 	c.connsCount.Add(1)
 	defer c.connsCount.Add(-1)
+	defer c.releaseSlot()
 	defer conn.Close()
 
 	for {
-		select {
-		case <-conn.quitch:
+		piece, ok := c.pieceQueue.pop(conn.quitch)
+		if !ok {
 			return
-		case piece := <-c.pieceQueue:
-			if err := c.tryDownloadPiece(conn, piece); err != nil {
-				if errors.Is(err, io.EOF) {
-					c.log.Info("Reached EOF, closing this connection")
-					go func() {
-						c.removeConnection(conn.Addr())
-					}()
-					continue
-				}
-
-				c.log.Error("Error downloading a piece", "err", err)
-
+		}
+		if err := c.tryDownloadPiece(conn, piece); err != nil {
+			if errors.Is(err, io.EOF) {
+				c.log.Info("Reached EOF, closing this connection")
 				go func() {
-					slog.Info("Restarting the connection")
 					c.removeConnection(conn.Addr())
-					if err := c.startHandshake(conn.peer, c.t.File.InfoHashSum, c.peerID); err != nil {
-						slog.Error("Error restarting the connection", "err", err)
-					}
-					c.pieceQueue <- piece
 				}()
-
 				continue
 			}
+
+			select {
+			case <-conn.quitch: // The connection was closed on purpose, let someone else download the piece.
+				c.requeuePiece(piece)
+				return
+			default:
+			}
+
+			c.log.Error("Error downloading a piece", "err", err)
+
+			go func() {
+				slog.Info("Restarting the connection")
+				c.removeConnection(conn.Addr())
+				c.requeuePiece(piece)
+				c.reconnect(conn.peer)
+			}()
 		}
 	}
 }
 
+// completePiece verifies a downloaded piece and moves it to the storage.
+func (c *Client) completePiece(piece *Piece, data []byte) error {
+	if err := VerifyPiece(&c.t.File, piece, data); err != nil {
+		c.hashFailures.Add(1)
+		c.emit(Event{Type: EventPieceFailed, Piece: int(piece.Index), Err: err})
+		return err
+	}
+	return c.storePiece(piece, data)
+}
+
+// storePiece moves a verified piece to the storage.
+func (c *Client) storePiece(piece *Piece, data []byte) error {
+	if err := c.storage.WritePiece(piece.Index, data); err != nil {
+		c.emit(Event{Type: EventError, Err: err})
+		return err
+	}
+	if !c.setHave(piece.Index) {
+		return nil
+	}
+	c.emit(Event{Type: EventPieceVerified, Piece: int(piece.Index)})
+	if c.piecesCompleted.Add(1) == int64(c.pieceCount) {
+		close(c.completed)
+		c.emit(Event{Type: EventCompleted})
+	}
+	c.log.Debug("Piece completed", "piece", piece.Index)
+	return nil
+}
+
+// requeuePiece puts a piece that failed to download back into the queue, the partial download is discarded.
+func (c *Client) requeuePiece(piece *Piece) {
+	piece.DownloadedSize = 0
+	c.pieceQueue.push(piece)
+}
+
+// queuePieces queues the pieces that are not verified yet, the first call of Download or
+// NewReader does it.
+func (c *Client) queuePieces() {
+	c.queueOnce.Do(func() {
+		pieces := c.Pieces()
+		missing := make([]*Piece, 0, len(pieces))
+		c.haveMu.RLock()
+		for i := range pieces {
+			if !c.have[pieces[i].Index] {
+				missing = append(missing, &pieces[i])
+			}
+		}
+		c.haveMu.RUnlock()
+		c.pieceQueue.push(missing...)
+	})
+}
+
 // tryDownloadPiece tries to download the piece from the peer.
 // On success, the piece is written to the c.pieces.
 func (c *Client) tryDownloadPiece(conn *Connection, piece *Piece) error {
@@ -410,7 +843,7 @@ func (c *Client) tryDownloadPiece(conn *Connection, piece *Piece) error {
 				return err
 			}
 		}
-		if err := conn.SetReadDeadline(time.Now().Add(ReadDeadline)); err != nil {
+		if err := conn.SetReadDeadline(time.Now().Add(c.cfg.ReadTimeout)); err != nil {
 			return err
 		}
 		next, err := c.readNext(conn)
@@ -423,22 +856,31 @@ func (c *Client) tryDownloadPiece(conn *Connection, piece *Piece) error {
 
 // exchangeCommands acts accordingly to the command MessageID.
 func (c *Client) exchangeCommands(conn *Connection, command *Command, piece *Piece) error {
-	if err := conn.SetWriteDeadline(time.Now().Add(WriteDeadline)); err != nil {
+	if err := conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout)); err != nil {
 		return err
 	}
 	switch command.MessageID {
 	case CommandBitfield: // Send an Interested command
-		return c.writecommand(conn, &Command{Length: 2, MessageID: CommandInterested, Payload: []byte{}})
+		conn.setBitfield(command.Payload)
+		if err := c.writecommand(conn, &Command{Length: 2, MessageID: CommandInterested, Payload: []byte{}}); err != nil {
+			return err
+		}
+		conn.interested.Store(true)
+	case CommandChoke:
+		conn.unchoked.Store(false)
 	case CommandUnchoke:
+		conn.unchoked.Store(true)
 		go func() {
 			offset := 0
 			for _, blockSize := range piece.Chunks {
 				payload := newUnchokePayload(piece.Index, uint32(offset), uint32(blockSize))
+				conn.requests.Add(1)
 				if err := c.writecommand(conn, &Command{
 					Length:    2 + uint32(len(payload)),
 					MessageID: CommandRequest,
 					Payload:   payload,
 				}); err != nil {
+					conn.requests.Add(-1)
 					c.log.Error("Failed to send request command", "err", err)
 					return
 				}
@@ -463,15 +905,28 @@ func (c *Client) exchangeCommands(conn *Connection, command *Command, piece *Pie
 		block := buf
 
 		copy(pieceBuf[begin:], block)
+		if conn.requests.Add(-1) < 0 {
+			conn.requests.Store(0) // a block we did not request, or one requested before a reconnect
+		}
 
 		piece.DownloadedSize += len(block)
 		if piece.DownloadedSize == piece.TotalSize {
-			c.piecesCompleted.Add(1)
-			slog.Info("PIECE COMPLETED!")
+			delete(c.pieces, piece.Hash)
+			if err := c.completePiece(piece, pieceBuf); err != nil {
+				c.log.Warn("Discarding a downloaded piece", "addr", conn.Addr(), "piece", piece.Index, "err", err)
+				c.requeuePiece(piece)
+			}
 
 			return io.EOF
 		}
 		_ = index
+	case CommandHave:
+		conn.setHave(command.Payload)
+	case CommandHashRequest:
+		return c.answerHashRequest(conn, command.Payload)
+	case CommandHashes, CommandHashReject:
+		// The torrent has every piece layer, so hashes are never requested.
+		c.log.Debug("Ignoring an answer to a hash request that was not sent", "addr", conn.Addr(), "type", command.MessageID)
 	default:
 		c.log.Debug("Unexpected command type", "type", command.MessageID)
 	}
@@ -479,6 +934,26 @@ func (c *Client) exchangeCommands(conn *Connection, command *Command, piece *Pie
 	return nil
 }
 
+// answerHashRequest sends the requested hashes from the piece layers, or rejects the request if we can't answer it.
+func (c *Client) answerHashRequest(conn *Connection, payload []byte) error {
+	req := new(HashRequest)
+	if err := req.UnmarshalBinary(payload); err != nil {
+		return err
+	}
+
+	msg, err := hashesFor(&c.t.File, req)
+	if err != nil {
+		c.log.Debug("Rejecting a hash request", "addr", conn.Addr(), "err", err)
+		return c.writecommand(conn, &Command{Length: 1 + hashRequestLength, MessageID: CommandHashReject, Payload: payload[:hashRequestLength]})
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.writecommand(conn, &Command{Length: 1 + uint32(len(data)), MessageID: CommandHashes, Payload: data})
+}
+
 func (c *Client) writecommand(w io.Writer, command *Command) error {
 	c.log.Debug("Sending command", "command", command)
 	return NewCommandEncoder(w).Encode(command)
@@ -500,16 +975,20 @@ func (c *Client) readNext(r io.Reader) (*Command, error) {
 	return command, nil
 }
 
-// refetchAnnounce refetches announce every interval * time.Second
+// refetchAnnounce refetches announce every interval until quitch is closed,
 // closes the peers that no longer exist in the announce and adds the new ones to the pool.
-func (c *Client) refetchAnnounce(interval int64) {
+func (c *Client) refetchAnnounce(ctx context.Context, interval time.Duration, quitch chan struct{}) {
+	if interval <= 0 {
+		interval = AnnounceRetryInterval
+	}
 	var (
-		tt        = time.NewTicker(time.Second * time.Duration(interval))
-		ctx       = context.Background()
+		tt        = time.NewTicker(interval)
 		errCount  = 0
 		maxErrors = 10
+		lastErr   error
 		once      sync.Once
 	)
+	defer tt.Stop()
 
 	slog.Debug("Starting refetching announce", "interval", interval)
 	defer slog.Debug("Closing refetch announce")
@@ -517,8 +996,9 @@ func (c *Client) refetchAnnounce(interval int64) {
 	for {
 		select {
 		case <-tt.C:
-			if errCount > maxErrors { // Close the client.
+			if errCount > maxErrors && len(c.t.File.URLList) < 1 { // Close the client, unless the web seeds keep it going.
 				once.Do(func() {
+					c.emit(Event{Type: EventError, Err: fmt.Errorf("%w, closing after %d failed announces", lastErr, errCount)})
 					c.Close()
 				})
 				continue
@@ -527,41 +1007,19 @@ func (c *Client) refetchAnnounce(interval int64) {
 			announce, err := c.Announce(ctx)
 			if err != nil {
 				errCount++
+				lastErr = err
 				slog.Debug("Failed to refetch annouce", "err", err, "err_count", errCount)
 				continue
 			}
 
-			go c.removeMissingConnections(announce)
-			go c.addMissingConnections(announce)
+			go c.addMissingConnections(announce, quitch)
 
-		case <-c.quitch:
+		case <-quitch:
 			return
 		}
 	}
 }
 
-func (c *Client) removeMissingConnections(announce *bencode.AnnounceResponse) {
-	for _, peer := range announce.Peers {
-		if c.HasConnection(peer.Addr()) {
-			slog.Debug("Removing a peer that is now missing", "peer", peer.Addr())
-			c.removeConnection(peer.Addr())
-		}
-	}
-}
-
-func (c *Client) addMissingConnections(announce *bencode.AnnounceResponse) {
-	for _, peer := range announce.Peers {
-		if !c.HasConnection(peer.Addr()) {
-			slog.Debug("Adding a missing peer", "peer", peer.Addr())
-			go func() {
-				if err := c.startHandshake(peer, c.t.File.InfoHashSum, c.peerID); err != nil {
-					c.log.Error("Handshake error", "err", err, "peer", peer.Addr())
-				}
-			}()
-		}
-	}
-}
-
 // newUnchokePayload is a helper for creating a payload for the Unchoke command type.
 func newUnchokePayload(index, begin, length uint32) []byte {
 	total := make([]byte, 12)
@@ -0,0 +1,96 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestClientShutdown(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.URL.Query().Get("event"))
+		mu.Unlock()
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+	// The web seed never answers, so the download can not finish.
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer seed.Close()
+
+	files := map[string][]byte{"file.bin": randomBytes(t, 50000)}
+	torrent, _ := newTestTorrent(t, tracker.URL, "file.bin", 16384, files, []string{"file.bin"}, seed.URL+"/")
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := NewClient(context.Background(), log, torrent, WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	if err := client.Download(ctx, io.Discard); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Download() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Download() returned %v after the context was done", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if err := client.Download(context.Background(), io.Discard); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Download() after Shutdown() error = %v, want %v", err, ErrClientClosed)
+	}
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) < 2 || events[0] != "" || !slices.Contains(events, "stopped") {
+		t.Errorf("the tracker got the events %q, want an announce and a stopped one", events)
+	}
+}
+
+func TestClientShutdownDeadline(t *testing.T) {
+	tracker := newTestTracker(t)
+	files := map[string][]byte{"file.bin": randomBytes(t, 100)}
+	torrent, _ := newTestTorrent(t, tracker.URL, "file.bin", 16384, files, []string{"file.bin"}, tracker.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), torrent)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.Canceled)
+	}
+	if !client.Paused() {
+		t.Error("the client runs after Shutdown() with a canceled context")
+	}
+}
@@ -30,6 +30,17 @@ const (
 	CommandCancel
 )
 
+// CommandExtended carries the messages of the extension protocol (BEP 10), the first byte of the
+// payload is the ID of the extension.
+const CommandExtended MessageID = 20
+
+// BitTorrent v2 (BEP 52) messages.
+const (
+	CommandHashRequest MessageID = iota + 21
+	CommandHashes
+	CommandHashReject
+)
+
 func (id MessageID) String() string {
 	switch id {
 	case CommandChoke:
@@ -50,6 +61,14 @@ func (id MessageID) String() string {
 		return "Piece"
 	case CommandCancel:
 		return "Cancel"
+	case CommandExtended:
+		return "Extended"
+	case CommandHashRequest:
+		return "HashRequest"
+	case CommandHashes:
+		return "Hashes"
+	case CommandHashReject:
+		return "HashReject"
 	default:
 		return strconv.FormatUint(uint64(id), 10)
 	}
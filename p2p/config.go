@@ -0,0 +1,242 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The defaults of ClientConfig.
+const (
+	DefaultMaxPeers                       = 50
+	DefaultMaxHalfOpen                    = 8
+	DefaultDialTimeout      time.Duration = time.Second * 5
+	DefaultHandshakeTimeout time.Duration = time.Second * 10
+	DefaultRetryMinBackoff  time.Duration = time.Second * 15
+	DefaultRetryMaxBackoff  time.Duration = time.Minute * 30
+	DefaultPeerIDPrefix                   = "-GB0100-"
+	DefaultUserAgent                      = "gobittorrent/0.1"
+	DefaultReadahead                      = 4 << 20
+)
+
+// Dialer opens the TCP connections to peers, *net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ClientConfig controls how a client talks to trackers, web seeds and peers.
+// Zero values are replaced with the defaults, zero limits mean no limit.
+type ClientConfig struct {
+	Port         int    // the port announced to the trackers, a session uses its listening port
+	PeerID       []byte // generated from PeerIDPrefix if empty
+	PeerIDPrefix string
+	UserAgent    string // sent to trackers and web seeds
+	HTTPClient   *http.Client
+	Dialer       Dialer
+	Storage      Storage // where the verified pieces are kept, in memory by default
+	BlockSize    int     // the size of the requests to peers, most peers reject more than 16KiB
+	Readahead    int64   // the bytes after the position of a Reader that are downloaded first
+
+	MaxPeers         int           // open connections of the torrent
+	MaxHalfOpen      int           // dials and handshakes in progress at once
+	DialTimeout      time.Duration // connecting over TCP
+	UTPDialTimeout   time.Duration // connecting over uTP, before falling back to TCP
+	HandshakeTimeout time.Duration
+	ReadTimeout      time.Duration // waiting for the next message of a peer
+	WriteTimeout     time.Duration
+	RetryMinBackoff  time.Duration // the wait before dialing a peer that failed, doubled with every failure
+	RetryMaxBackoff  time.Duration
+}
+
+// Option changes the ClientConfig.
+type Option func(cfg *ClientConfig)
+
+// DefaultClientConfig returns the config used by NewClient without options.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Port:             DefaultPort,
+		PeerIDPrefix:     DefaultPeerIDPrefix,
+		UserAgent:        DefaultUserAgent,
+		HTTPClient:       http.DefaultClient,
+		Dialer:           &net.Dialer{},
+		Storage:          NewMemoryStorage(),
+		BlockSize:        ChunkSize,
+		Readahead:        DefaultReadahead,
+		MaxPeers:         DefaultMaxPeers,
+		MaxHalfOpen:      DefaultMaxHalfOpen,
+		DialTimeout:      DefaultDialTimeout,
+		UTPDialTimeout:   UTPDialTimeout,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		ReadTimeout:      ReadDeadline,
+		WriteTimeout:     WriteDeadline,
+		RetryMinBackoff:  DefaultRetryMinBackoff,
+		RetryMaxBackoff:  DefaultRetryMaxBackoff,
+	}
+}
+
+// newClientConfig applies the options on top of the defaults.
+func newClientConfig(opts ...Option) ClientConfig {
+	cfg := DefaultClientConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg.withDefaults()
+}
+
+// validate checks the options the defaults can't fill in.
+func (cfg ClientConfig) validate() error {
+	if len(cfg.PeerID) != 20 {
+		return fmt.Errorf("%w, %q has %d bytes", ErrInvalidPeerID, cfg.PeerID, len(cfg.PeerID))
+	}
+	return nil
+}
+
+// withDefaults fills in the zero values.
+func (cfg ClientConfig) withDefaults() ClientConfig {
+	defaults := DefaultClientConfig()
+
+	if cfg.Port <= 0 {
+		cfg.Port = defaults.Port
+	}
+	if cfg.PeerIDPrefix == "" {
+		cfg.PeerIDPrefix = defaults.PeerIDPrefix
+	}
+	if len(cfg.PeerID) == 0 {
+		cfg.PeerID = GeneratePeerID(cfg.PeerIDPrefix)
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaults.UserAgent
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaults.HTTPClient
+	}
+	if cfg.Dialer == nil {
+		cfg.Dialer = defaults.Dialer
+	}
+	if cfg.Storage == nil {
+		cfg.Storage = defaults.Storage
+	}
+	if cfg.BlockSize <= 0 {
+		cfg.BlockSize = defaults.BlockSize
+	}
+	if cfg.Readahead <= 0 {
+		cfg.Readahead = defaults.Readahead
+	}
+
+	for _, d := range []struct{ v, def *time.Duration }{
+		{&cfg.DialTimeout, &defaults.DialTimeout},
+		{&cfg.UTPDialTimeout, &defaults.UTPDialTimeout},
+		{&cfg.HandshakeTimeout, &defaults.HandshakeTimeout},
+		{&cfg.ReadTimeout, &defaults.ReadTimeout},
+		{&cfg.WriteTimeout, &defaults.WriteTimeout},
+		{&cfg.RetryMinBackoff, &defaults.RetryMinBackoff},
+		{&cfg.RetryMaxBackoff, &defaults.RetryMaxBackoff},
+	} {
+		if *d.v <= 0 {
+			*d.v = *d.def
+		}
+	}
+	return cfg
+}
+
+// peerIDChars are the characters of the random part of generated peer IDs.
+const peerIDChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// GeneratePeerID returns a 20 byte peer ID that starts with the prefix and ends with random characters.
+func GeneratePeerID(prefix string) []byte {
+	id := make([]byte, 20)
+	n := copy(id, prefix)
+
+	random := make([]byte, len(id)-n)
+	_, _ = rand.Read(random)
+	for i, b := range random {
+		id[n+i] = peerIDChars[int(b)%len(peerIDChars)]
+	}
+	return id
+}
+
+// WithConfig replaces the whole config, the options after it change the replacement.
+func WithConfig(cfg ClientConfig) Option {
+	return func(c *ClientConfig) { *c = cfg }
+}
+
+func WithPort(port int) Option {
+	return func(c *ClientConfig) { c.Port = port }
+}
+
+// WithPeerID sets the exact peer ID, which has to be 20 bytes long, NewClient and NewSession
+// return ErrInvalidPeerID otherwise.
+func WithPeerID(id []byte) Option {
+	return func(c *ClientConfig) { c.PeerID = id }
+}
+
+// WithPeerIDPrefix generates the peer ID from the prefix, for example "-GB0100-".
+func WithPeerIDPrefix(prefix string) Option {
+	return func(c *ClientConfig) {
+		c.PeerIDPrefix = prefix
+		c.PeerID = nil
+	}
+}
+
+func WithUserAgent(ua string) Option {
+	return func(c *ClientConfig) { c.UserAgent = ua }
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *ClientConfig) { c.HTTPClient = client }
+}
+
+func WithDialer(d Dialer) Option {
+	return func(c *ClientConfig) { c.Dialer = d }
+}
+
+func WithStorage(s Storage) Option {
+	return func(c *ClientConfig) { c.Storage = s }
+}
+
+func WithBlockSize(n int) Option {
+	return func(c *ClientConfig) { c.BlockSize = n }
+}
+
+// WithReadahead sets the default readahead of the readers, see Reader.SetReadahead.
+func WithReadahead(n int64) Option {
+	return func(c *ClientConfig) { c.Readahead = n }
+}
+
+func WithMaxPeers(n int) Option {
+	return func(c *ClientConfig) { c.MaxPeers = n }
+}
+
+func WithMaxHalfOpen(n int) Option {
+	return func(c *ClientConfig) { c.MaxHalfOpen = n }
+}
+
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *ClientConfig) { c.DialTimeout = d }
+}
+
+func WithUTPDialTimeout(d time.Duration) Option {
+	return func(c *ClientConfig) { c.UTPDialTimeout = d }
+}
+
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(c *ClientConfig) { c.HandshakeTimeout = d }
+}
+
+// WithReadWriteTimeouts sets how long to wait for the next message of a peer and for sending one.
+func WithReadWriteTimeouts(read, write time.Duration) Option {
+	return func(c *ClientConfig) {
+		c.ReadTimeout = read
+		c.WriteTimeout = write
+	}
+}
+
+func WithRetryBackoff(min, max time.Duration) Option {
+	return func(c *ClientConfig) {
+		c.RetryMinBackoff = min
+		c.RetryMaxBackoff = max
+	}
+}
@@ -0,0 +1,149 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+func TestNewClientConfig(t *testing.T) {
+	cfg := newClientConfig()
+	if len(cfg.PeerID) != 20 || !strings.HasPrefix(string(cfg.PeerID), DefaultPeerIDPrefix) {
+		t.Errorf("PeerID = %q, want 20 bytes starting with %q", cfg.PeerID, DefaultPeerIDPrefix)
+	}
+	if cfg.Port != DefaultPort || cfg.BlockSize != ChunkSize || cfg.ReadTimeout != ReadDeadline || cfg.Storage == nil {
+		t.Errorf("the defaults were not applied: %+v", cfg)
+	}
+
+	cfg = newClientConfig(
+		WithPort(7000),
+		WithPeerIDPrefix("-XX1234-"),
+		WithBlockSize(8192),
+		WithReadWriteTimeouts(time.Second, 2*time.Second),
+		WithConfig(ClientConfig{MaxPeers: 3}), // Replaces everything before it.
+		WithUserAgent("test/1.0"),
+	)
+	if cfg.Port != DefaultPort || !strings.HasPrefix(string(cfg.PeerID), DefaultPeerIDPrefix) || cfg.BlockSize != ChunkSize {
+		t.Errorf("the options before WithConfig were kept: %+v", cfg)
+	}
+	if cfg.MaxPeers != 3 || cfg.UserAgent != "test/1.0" || cfg.DialTimeout != DefaultDialTimeout {
+		t.Errorf("config = %+v, want MaxPeers 3, the user agent and default timeouts", cfg)
+	}
+
+	if a, b := GeneratePeerID("-XX1234-"), GeneratePeerID("-XX1234-"); string(a) == string(b) {
+		t.Errorf("GeneratePeerID() returned %q twice", a)
+	}
+}
+
+func TestInvalidPeerID(t *testing.T) {
+	torrent, _ := newTestTorrent(t, "http://127.0.0.1:1/announce", "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, id := range [][]byte{[]byte("-GB0100-short"), []byte("-GB0100-abcdefghijklmnop")} {
+		if _, err := NewClient(context.Background(), log, torrent, WithPeerID(id)); !errors.Is(err, ErrInvalidPeerID) {
+			t.Errorf("NewClient() with the peer ID %q error = %v, want %v", id, err, ErrInvalidPeerID)
+		}
+		if _, err := NewSession(log, "127.0.0.1:0", WithPeerID(id)); !errors.Is(err, ErrInvalidPeerID) {
+			t.Errorf("NewSession() with the peer ID %q error = %v, want %v", id, err, ErrInvalidPeerID)
+		}
+	}
+
+	s, err := NewSession(log, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Add(torrent, WithPeerID([]byte("short"))); !errors.Is(err, ErrInvalidPeerID) {
+		t.Errorf("Add() with a short peer ID error = %v, want %v", err, ErrInvalidPeerID)
+	}
+}
+
+type countingDialer struct {
+	dials atomic.Int64
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.dials.Add(1)
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+func TestClientOptions(t *testing.T) {
+	var userAgent atomic.Value
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent.Store(r.UserAgent())
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	var (
+		dialer    = new(countingDialer)
+		transport = new(countingTransport)
+		data      = randomBytes(t, 40000)
+	)
+	torrent, _ := newTestTorrent(t, tracker.URL, "a.bin", 32768, map[string][]byte{"a.bin": data}, []string{"a.bin"})
+
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig(
+		WithUserAgent("test/1.0"),
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithDialer(dialer),
+		WithBlockSize(4096),
+		WithPort(7000),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.slots = newConnLimit(0)
+	c.globalHalfOpen = newConnLimit(0)
+	defer c.Close()
+
+	if _, err := c.Announce(context.Background()); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if got := userAgent.Load(); got != "test/1.0" {
+		t.Errorf("tracker got User-Agent %q, want %q", got, "test/1.0")
+	}
+	if transport.requests.Load() != 1 {
+		t.Errorf("the HTTP client made %d requests, want 1", transport.requests.Load())
+	}
+	if !strings.Contains(transport.lastURL.Load().(string), "port=7000") {
+		t.Errorf("announce %q does not have the port", transport.lastURL.Load())
+	}
+
+	addr := peer.Addr().(*net.TCPAddr)
+	c.connect(bencode.Peer{IP: addr.IP, Port: uint16(addr.Port)})
+	if dialer.dials.Load() != 1 {
+		t.Errorf("the dialer was used %d times, want 1", dialer.dials.Load())
+	}
+
+	pieces := c.Pieces()
+	if len(pieces[0].Chunks) != 32768/4096 || len(pieces[1].Chunks) != 2 {
+		t.Errorf("chunks = %v, %v, want blocks of 4096", pieces[0].Chunks, pieces[1].Chunks)
+	}
+}
+
+type countingTransport struct {
+	requests atomic.Int64
+	lastURL  atomic.Value
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	t.lastURL.Store(req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,218 @@
+package p2p
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// connLimit caps the amount of open connections or dials in progress. A client has its own limits
+// and shares the global ones with the other torrents of its session.
+type connLimit struct {
+	mu    sync.Mutex
+	max   int // 0 means no limit
+	open  int
+	freed chan struct{} // closed and replaced on every release, so the waiters can try again
+}
+
+func newConnLimit(n int) *connLimit {
+	return &connLimit{max: max(n, 0), freed: make(chan struct{})}
+}
+
+func (l *connLimit) setMax(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max(n, 0)
+	l.notifyLocked() // A higher limit lets the waiters in.
+}
+
+func (l *connLimit) limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max
+}
+
+func (l *connLimit) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open
+}
+
+func (l *connLimit) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.open >= l.max {
+		return false
+	}
+	l.open++
+	return true
+}
+
+// wait blocks until there is room or quitch is closed.
+func (l *connLimit) wait(quitch <-chan struct{}) bool {
+	for {
+		l.mu.Lock()
+		if l.max == 0 || l.open < l.max {
+			l.open++
+			l.mu.Unlock()
+			return true
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-quitch:
+			return false
+		case <-freed:
+		}
+	}
+}
+
+func (l *connLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+	l.notifyLocked()
+}
+
+func (l *connLimit) notifyLocked() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// retryList remembers the peers that could not be connected to and when to try them again.
+type retryList struct {
+	mu         sync.Mutex
+	peers      map[string]*peerRetry // Addr - retry
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+}
+
+type peerRetry struct {
+	failures int
+	next     time.Time
+}
+
+func newRetryList(minBackoff, maxBackoff time.Duration) *retryList {
+	return &retryList{
+		peers:      make(map[string]*peerRetry),
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		now:        time.Now,
+	}
+}
+
+// ready reports whether the peer may be dialed.
+func (r *retryList) ready(addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	retry, ok := r.peers[addr]
+	return !ok || !r.now().Before(retry.next)
+}
+
+// failed records a failed attempt and returns how long to wait before the next one.
+func (r *retryList) failed(addr string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	retry, ok := r.peers[addr]
+	if !ok {
+		retry = new(peerRetry)
+		r.peers[addr] = retry
+	}
+	retry.failures++
+	backoff := min(r.minBackoff<<min(retry.failures-1, 16), r.maxBackoff)
+	retry.next = r.now().Add(backoff)
+	return backoff
+}
+
+func (r *retryList) succeeded(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.peers, addr)
+}
+
+// acquireSlot takes a connection slot of the torrent and of the session.
+func (c *Client) acquireSlot() bool {
+	if !c.peers.acquire() {
+		return false
+	}
+	if !c.slots.acquire() {
+		c.peers.release()
+		return false
+	}
+	return true
+}
+
+func (c *Client) releaseSlot() {
+	c.slots.release()
+	c.peers.release()
+}
+
+// acquireDial waits until the torrent and the session allow another half-open connection.
+func (c *Client) acquireDial(quitch <-chan struct{}) bool {
+	if !c.halfOpen.wait(quitch) {
+		return false
+	}
+	if !c.globalHalfOpen.wait(quitch) {
+		c.halfOpen.release()
+		return false
+	}
+	return true
+}
+
+func (c *Client) releaseDial() {
+	c.globalHalfOpen.release()
+	c.halfOpen.release()
+}
+
+// shouldDial reports whether we are not connected to the peer and it is not backed off.
+func (c *Client) shouldDial(addr string) bool {
+	return !c.HasConnection(addr) && c.retries.ready(addr)
+}
+
+// connect dials the peer and does the handshake, the caller holds a dial slot.
+func (c *Client) connect(peer bencode.Peer) {
+	addr := peer.Addr()
+	if err := c.startHandshake(peer, c.InfoHash(), c.peerID); err != nil {
+		if errors.Is(err, ErrTooManyConnections) {
+			return // Not the peer's fault.
+		}
+		backoff := c.retries.failed(addr)
+		c.log.Debug("Failed to connect to the peer", "peer", addr, "err", err, "retry_in", backoff)
+		return
+	}
+	c.retries.succeeded(addr)
+}
+
+// addMissingConnections dials the peers we are not connected to, at most MaxHalfOpen at once.
+func (c *Client) addMissingConnections(announce *bencode.AnnounceResponse, quitch <-chan struct{}) {
+	for _, peer := range announce.Peers {
+		if !c.shouldDial(peer.Addr()) {
+			continue
+		}
+		if !c.acquireDial(quitch) {
+			return
+		}
+		c.log.Debug("Adding a missing peer", "peer", peer.Addr())
+		go func() {
+			defer c.releaseDial()
+			c.connect(peer)
+		}()
+	}
+}
+
+// reconnect dials the peer again after its connection failed.
+func (c *Client) reconnect(peer bencode.Peer) {
+	quitch := c.runQuitch()
+	if quitch == nil || !c.shouldDial(peer.Addr()) || !c.acquireDial(quitch) {
+		return
+	}
+	defer c.releaseDial()
+	c.connect(peer)
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+func TestConnLimit(t *testing.T) {
+	l := newConnLimit(2)
+	if !l.acquire() || !l.acquire() {
+		t.Fatal("acquire() under the limit = false")
+	}
+	if l.acquire() {
+		t.Error("acquire() over the limit = true")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.wait(nil)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("wait() returned while the limit is reached")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release()
+	if !<-acquired {
+		t.Error("wait() after release() = false")
+	}
+
+	quitch := make(chan struct{})
+	close(quitch)
+	if l.wait(quitch) {
+		t.Error("wait() with a closed quitch = true")
+	}
+
+	l.setMax(0)
+	for range 10 {
+		if !l.acquire() {
+			t.Fatal("acquire() without a limit = false")
+		}
+	}
+	if l.count() != 12 {
+		t.Errorf("count() = %d, want %d", l.count(), 12)
+	}
+}
+
+func TestRetryList(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newRetryList(time.Second, 5*time.Second)
+	r.now = func() time.Time { return now }
+
+	const addr = "1.2.3.4:5"
+	if !r.ready(addr) {
+		t.Fatal("ready() of a new peer = false")
+	}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := r.failed(addr); got != want {
+			t.Errorf("failed() = %v, want %v", got, want)
+		}
+	}
+	if r.ready(addr) {
+		t.Error("ready() right after a failure = true")
+	}
+
+	now = now.Add(5 * time.Second)
+	if !r.ready(addr) {
+		t.Error("ready() after the backoff = false")
+	}
+
+	r.succeeded(addr)
+	if got := r.failed(addr); got != time.Second {
+		t.Errorf("failed() after a success = %v, want %v", got, time.Second)
+	}
+}
+
+// TestAddMissingConnections dials peers that never answer the handshake, which keeps every dial
+// half-open until the handshake timeout, so the dials have to happen in rounds.
+func TestAddMissingConnections(t *testing.T) {
+	const (
+		peers            = 6
+		maxHalfOpen      = 2
+		handshakeTimeout = 100 * time.Millisecond
+	)
+
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int64
+		announce = &bencode.AnnounceResponse{}
+	)
+	for range peers {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			accepted.Add(1)
+			io.Copy(io.Discard, conn) // Until the client gives up.
+		}()
+
+		addr := l.Addr().(*net.TCPAddr)
+		announce.Peers = append(announce.Peers, bencode.Peer{IP: addr.IP, Port: uint16(addr.Port)})
+	}
+
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"})
+	c, err := newClient(slog.Default(), torrent, newClientConfig(
+		WithMaxHalfOpen(maxHalfOpen),
+		WithHandshakeTimeout(handshakeTimeout),
+		WithRetryBackoff(time.Hour, time.Hour),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.slots = newConnLimit(0)
+	c.globalHalfOpen = newConnLimit(0)
+
+	start := time.Now()
+	c.addMissingConnections(announce, make(chan struct{}))
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if accepted.Load() != peers {
+		t.Errorf("%d peers were dialed, want %d", accepted.Load(), peers)
+	}
+	if rounds := time.Duration(peers / maxHalfOpen); elapsed < rounds*handshakeTimeout {
+		t.Errorf("dialing took %v, less than %d rounds of the handshake timeout, the half-open limit was not used", elapsed, rounds)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("dialing took %v, the handshake timeout was not used", elapsed)
+	}
+
+	// Wait for the last dial slots to be released.
+	for deadline := time.Now().Add(time.Second); c.halfOpen.count() != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for _, peer := range announce.Peers {
+		if c.shouldDial(peer.Addr()) {
+			t.Errorf("shouldDial(%s) = true right after the peer failed", peer.Addr())
+		}
+	}
+}
+
+func TestClientMaxPeers(t *testing.T) {
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"})
+
+	c, err := newClient(slog.Default(), torrent, newClientConfig(WithMaxPeers(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.slots = newConnLimit(2)
+	if !c.acquireSlot() {
+		t.Fatal("acquireSlot() under the limit = false")
+	}
+	if c.acquireSlot() {
+		t.Error("acquireSlot() over the torrent limit = true")
+	}
+	if c.slots.count() != 1 {
+		t.Errorf("a refused slot was taken from the session, count = %d, want %d", c.slots.count(), 1)
+	}
+}
@@ -5,8 +5,32 @@ import "errors"
 var (
 	ErrInvalidHandshakeFormat = errors.New("p2p: invalid handshake format")
 	ErrNoPeers                = errors.New("p2p: no peers")
+	ErrNoTrackers             = errors.New("p2p: the torrent has no trackers")
+	ErrPrivateTorrent         = errors.New("p2p: not allowed for private torrents")
 	ErrWriteConn              = errors.New("p2p: failed to write to the connection")
 	ErrPieceNotFound          = errors.New("p2p: downloaded piece was not found in the buffer") // Should technically never happen?
 	ErrInvalidPieceHash       = errors.New("p2p: invalid downloaded piece hash")
 	ErrNoCommand              = errors.New("p2p: command from the connection was nil")
+	ErrInvalidHashMessage     = errors.New("p2p: invalid hash request or hashes message")
+	ErrMissingPieceLayer      = errors.New("p2p: the piece layer of the file is not known")
+	ErrWebSeedStatus          = errors.New("p2p: unexpected web seed response status")
+	ErrWebSeedShortRead       = errors.New("p2p: web seed returned less data than requested")
+	ErrTooManyConnections     = errors.New("p2p: connection limit reached")
+	ErrAlreadyConnected       = errors.New("p2p: already connected to the peer")
+	ErrTorrentPaused          = errors.New("p2p: the torrent is paused")
+	ErrTorrentExists          = errors.New("p2p: the torrent is already in the session")
+	ErrTorrentNotFound        = errors.New("p2p: the torrent is not in the session")
+	ErrSessionClosed          = errors.New("p2p: the session is closed")
+	ErrInvalidSchedule        = errors.New("p2p: invalid bandwidth schedule")
+	ErrFileNotFound           = errors.New("p2p: the file is not in the torrent")
+	ErrClientClosed           = errors.New("p2p: the client is closed")
+	ErrReaderClosed           = errors.New("p2p: the reader is closed")
+	ErrInvalidSeek            = errors.New("p2p: invalid seek")
+	ErrInvalidPriority        = errors.New("p2p: invalid file priority")
+	ErrPieceSkipped           = errors.New("p2p: the piece only holds skipped files")
+	ErrUnsafePath             = errors.New("p2p: the path of the file leaves the download directory")
+	ErrInvalidPieceState      = errors.New("p2p: invalid piece state")
+	ErrNoMetadata             = errors.New("p2p: no peer sent the metadata of the torrent")
+	ErrInvalidMetadata        = errors.New("p2p: invalid metadata from the peer")
+	ErrInvalidPeerID          = errors.New("p2p: the peer ID is not 20 bytes long")
 )
@@ -0,0 +1,147 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	EventPeerConnected    EventType = iota + 1 // Addr is set
+	EventPeerDisconnected                      // Addr is set
+	EventPieceVerified                         // Piece is set
+	EventPieceFailed                           // Piece and Err are set, the piece is downloaded again
+	EventAnnounce                              // Tracker and Latency are set, Peers on success and Err on failure
+	EventCompleted                             // every piece is verified
+	EventError                                 // Err is set, the torrent may stop because of it
+	EventStateChanged                          // State is set
+)
+
+// The states of EventStateChanged.
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateClosed  = "closed"
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPeerConnected:
+		return "peer connected"
+	case EventPeerDisconnected:
+		return "peer disconnected"
+	case EventPieceVerified:
+		return "piece verified"
+	case EventPieceFailed:
+		return "piece failed"
+	case EventAnnounce:
+		return "announce"
+	case EventCompleted:
+		return "completed"
+	case EventError:
+		return "error"
+	case EventStateChanged:
+		return "state changed"
+	}
+	return "unknown"
+}
+
+// Event is something that happened to a torrent, the fields that are set depend on the Type.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	InfoHash [20]byte
+	Addr     string // of the peer
+	Piece    int    // the index of the piece
+	Tracker  string
+	Peers    int           // in the response of the tracker
+	Latency  time.Duration // of the announce
+	State    string        // StateRunning, StatePaused or StateClosed
+	Err      error
+}
+
+// DefaultEventBuffer is the size of the subscription channels when Subscribe gets 0.
+const DefaultEventBuffer = 256
+
+// eventHub sends the events to the subscribers. It never blocks: the events that do not fit in the
+// channel of a slow subscriber are dropped for it, so it can not stall the downloads.
+type eventHub struct {
+	subs   map[chan Event]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]struct{})}
+}
+
+func (h *eventHub) subscribe(size int) (<-chan Event, func()) {
+	if size <= 0 {
+		size = DefaultEventBuffer
+	}
+	ch := make(chan Event, size)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// close closes the channels of the subscribers, later events are dropped.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+	}
+	clear(h.subs)
+}
+
+// Subscribe returns a channel of the events of the torrent, with room for size events (0 means
+// DefaultEventBuffer). The events that do not fit are dropped, so read them promptly. The channel
+// is closed by unsubscribe or after the StateClosed event when the client is closed.
+func (c *Client) Subscribe(size int) (events <-chan Event, unsubscribe func()) {
+	return c.events.subscribe(size)
+}
+
+// Subscribe returns a channel of the events of every torrent of the session, see Client.Subscribe.
+// The channel is closed by unsubscribe or when the session is closed.
+func (s *Session) Subscribe(size int) (events <-chan Event, unsubscribe func()) {
+	return s.events.subscribe(size)
+}
+
+// emit sends the event to the subscribers of the client and of its session.
+func (c *Client) emit(e Event) {
+	e.Time = time.Now()
+	e.InfoHash = c.InfoHash()
+	c.events.publish(e)
+	if c.session != nil {
+		c.session.events.publish(e)
+	}
+}
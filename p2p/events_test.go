@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub()
+	slow, _ := h.subscribe(1)
+	events, unsubscribe := h.subscribe(0)
+
+	h.publish(Event{Type: EventPieceVerified, Piece: 1})
+	h.publish(Event{Type: EventPieceVerified, Piece: 2})
+	if e := <-slow; e.Piece != 1 {
+		t.Errorf("the slow subscriber got piece %d, want 1", e.Piece)
+	}
+	if len(slow) != 0 {
+		t.Errorf("the slow subscriber has %d events, want the second one dropped", len(slow))
+	}
+	if len(events) != 2 {
+		t.Errorf("the subscriber has %d events, want 2", len(events))
+	}
+
+	unsubscribe()
+	unsubscribe()
+	h.publish(Event{Type: EventCompleted})
+	if n := len(events); n != 2 {
+		t.Errorf("the subscriber has %d events after unsubscribe, want 2", n)
+	}
+
+	h.close()
+	if e := <-slow; e.Type != EventCompleted {
+		t.Errorf("the slow subscriber got %v, want %v", e.Type, EventCompleted)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("the channel is open after close")
+	}
+	late, _ := h.subscribe(1)
+	if _, ok := <-late; ok {
+		t.Error("a subscription after close is open")
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	files := map[string][]byte{"file.bin": randomBytes(t, 100000)}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "file.bin", 32768, files, []string{"file.bin"}, srv.URL+"/")
+
+	session, err := NewSession(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	events, _ := session.Subscribe(0)
+
+	client, err := session.Add(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientEvents, _ := client.Subscribe(0)
+	if err := client.Download(context.Background(), io.Discard); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	client.Pause()
+	client.Close()
+
+	counts := make(map[EventType]int)
+	var states []string
+	for e := range clientEvents {
+		if e.InfoHash != client.InfoHash() || e.Time.IsZero() {
+			t.Errorf("event %v has info hash %x and time %v", e.Type, e.InfoHash, e.Time)
+		}
+		counts[e.Type]++
+		if e.Type == EventStateChanged {
+			states = append(states, e.State)
+		}
+	}
+	if counts[EventPieceVerified] != 4 || counts[EventCompleted] != 1 {
+		t.Errorf("events = %v, want 4 verified pieces and a completed download", counts)
+	}
+	if len(states) != 2 || states[0] != StatePaused || states[1] != StateClosed {
+		t.Errorf("states = %v, want [%s %s]", states, StatePaused, StateClosed)
+	}
+
+	// The session got the events from before the client was subscribed too.
+	session.Close()
+	clear(counts)
+	for e := range events {
+		counts[e.Type]++
+	}
+	if counts[EventStateChanged] != 3 || counts[EventAnnounce] == 0 || counts[EventPieceVerified] != 4 {
+		t.Errorf("session events = %v, want 3 state changes, announces and 4 verified pieces", counts)
+	}
+}
@@ -0,0 +1,257 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// FilePriority decides which files are downloaded first, the skipped files are not downloaded.
+type FilePriority int
+
+const (
+	PrioritySkip   FilePriority = -2
+	PriorityLow    FilePriority = -1
+	PriorityNormal FilePriority = 0
+	PriorityHigh   FilePriority = 1
+
+	// priorityStreaming is the priority of the pieces a Reader needs next.
+	priorityStreaming FilePriority = 2
+)
+
+func (p FilePriority) String() string {
+	switch {
+	case p <= PrioritySkip:
+		return "skip"
+	case p < PriorityNormal:
+		return "low"
+	case p > PriorityNormal:
+		return "high"
+	}
+	return "normal"
+}
+
+// FileStats is a file of the torrent and its progress.
+type FileStats struct {
+	Path     string // relative to the torrent directory, the name for single-file torrents
+	Length   int64
+	Priority FilePriority
+	Progress float64 // the fraction of the pieces of the file that are done
+}
+
+// fileSpan is the range of pieces a file is stored in.
+type fileSpan struct {
+	path        string
+	offset      int64 // of the first byte in the pieces
+	length      int64
+	first, last int // piece indexes, last < first for empty files
+}
+
+// fileSpans returns the files of the torrent without the padding files of hybrid torrents.
+func fileSpans(f *bencode.File) []fileSpan {
+	pieceLength := int64(f.Info.PieceLength)
+	span := func(name string, offset, length int64) fileSpan {
+		s := fileSpan{path: name, offset: offset, length: length, first: int(offset / pieceLength)}
+		s.last = s.first - 1 // An empty file is in no piece.
+		if length > 0 {
+			s.last = int((offset + length - 1) / pieceLength)
+		}
+		return s
+	}
+
+	switch {
+	case !f.IsV1(): // Every file of a v2 only torrent starts at a new piece.
+		spans := make([]fileSpan, 0, len(f.Info.FileTree))
+		offset := int64(0)
+		for _, file := range f.Info.FileTree {
+			spans = append(spans, span(joinPath(file.Path), offset, int64(file.Length)))
+			offset += (int64(file.Length) + pieceLength - 1) / pieceLength * pieceLength
+		}
+		return spans
+	case f.Info.IsMultiFile():
+		spans := make([]fileSpan, 0, len(f.Info.Files))
+		offset := int64(0)
+		for _, file := range f.Info.Files {
+			if !file.IsPadding() {
+				spans = append(spans, span(joinPath(file.Path), offset, int64(file.Length)))
+			}
+			offset += int64(file.Length)
+		}
+		return spans
+	}
+	return []fileSpan{span(string(f.Info.Name), 0, int64(f.Info.Length))}
+}
+
+func joinPath(components []bencode.String) string {
+	parts := make([]string, 0, len(components))
+	for _, c := range components {
+		parts = append(parts, string(c))
+	}
+	return path.Join(parts...)
+}
+
+// TorrentFiles returns the files of the torrent in the order of Client.Files, without the padding
+// files of hybrid torrents, with the normal priority and no progress.
+func TorrentFiles(t *bencode.Torrent) []FileStats {
+	spans := fileSpans(&t.File)
+	files := make([]FileStats, 0, len(spans))
+	for _, span := range spans {
+		files = append(files, FileStats{Path: span.path, Length: span.length})
+	}
+	return files
+}
+
+// Files returns the files of the torrent with their priorities and progress.
+func (c *Client) Files() []FileStats {
+	c.haveMu.RLock()
+	defer c.haveMu.RUnlock()
+
+	files := make([]FileStats, 0, len(c.files))
+	for i, span := range c.files {
+		fs := FileStats{Path: span.path, Length: span.length, Priority: c.priorities[i], Progress: 1}
+		if span.last >= span.first {
+			done := 0
+			for _, ok := range c.have[span.first : span.last+1] {
+				if ok {
+					done++
+				}
+			}
+			fs.Progress = float64(done) / float64(span.last-span.first+1)
+		}
+		files = append(files, fs)
+	}
+	return files
+}
+
+// ParseFilePriority parses the String of a priority.
+func ParseFilePriority(s string) (FilePriority, error) {
+	for _, p := range []FilePriority{PrioritySkip, PriorityLow, PriorityNormal, PriorityHigh} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q, want skip, low, normal or high", ErrInvalidPriority, s)
+}
+
+// SetFilePriority changes the priority of the file at the index of Files, the pieces of the
+// files with a higher priority are downloaded first. Only the pieces that hold a file that is not
+// skipped are downloaded, a storage that is a FileSkipper keeps the skipped files out of the file
+// system.
+func (c *Client) SetFilePriority(index int, priority FilePriority) error {
+	if index < 0 || index >= len(c.files) {
+		return fmt.Errorf("%w, file %d of %d", ErrFileNotFound, index, len(c.files))
+	}
+	priority = max(PrioritySkip, min(priority, PriorityHigh))
+	if skipper, ok := c.storage.(FileSkipper); ok {
+		if err := skipper.SkipFile(index, priority == PrioritySkip); err != nil {
+			return err
+		}
+	}
+
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+	c.priorities[index] = priority
+	c.updateWantedLocked()
+	c.updateQueueLocked()
+	close(c.haveCh) // The waits for the pieces that were skipped end.
+	c.haveCh = make(chan struct{})
+	return nil
+}
+
+// updateWantedLocked marks the pieces that hold a file that is not skipped, or no file at all,
+// and moves the front to the first of them that is missing.
+func (c *Client) updateWantedLocked() {
+	covered := make([]bool, c.pieceCount)
+	clear(c.wanted)
+	for i, span := range c.files {
+		for p := span.first; p <= span.last; p++ {
+			covered[p] = true
+			if c.priorities[i] != PrioritySkip {
+				c.wanted[p] = true
+			}
+		}
+	}
+	for p := range c.wanted {
+		c.wanted[p] = c.wanted[p] || !covered[p]
+	}
+
+	c.front = 0
+	c.advanceFrontLocked()
+}
+
+// advanceFrontLocked moves the front over the verified and the skipped pieces.
+func (c *Client) advanceFrontLocked() {
+	for c.front < len(c.have) && (c.have[c.front] || !c.wanted[c.front]) {
+		c.front++
+	}
+}
+
+// Wait blocks until every piece that holds a file that is not skipped is verified, the client is
+// closed or the context is done. The missing pieces are queued if Download did not do it yet.
+func (c *Client) Wait(ctx context.Context) error {
+	c.queuePieces()
+	for {
+		c.haveMu.RLock()
+		done, verified := c.front == len(c.have), c.haveCh
+		c.haveMu.RUnlock()
+		if done {
+			return nil
+		}
+
+		select {
+		case <-verified:
+		case <-c.closedch:
+			return ErrClientClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// updateQueueLocked gives the queue the priorities of the pieces: the highest priority of the files
+// that are not skipped in a piece, normal for a sequential download and for the pieces without
+// files, and the window of a sequential download. The pieces that readers wait for come first.
+func (c *Client) updateQueueLocked() {
+	priorities := make([]FilePriority, c.pieceCount)
+	for p := range priorities {
+		priorities[p] = PrioritySkip
+	}
+	for i, span := range c.files {
+		if c.priorities[i] == PrioritySkip {
+			continue
+		}
+		for p := span.first; p <= span.last; p++ {
+			priorities[p] = max(priorities[p], c.priorities[i])
+		}
+	}
+	for p := range priorities {
+		switch {
+		case !c.wanted[p]:
+			priorities[p] = PrioritySkip
+		case c.sequential || priorities[p] == PrioritySkip:
+			priorities[p] = PriorityNormal
+		}
+	}
+	c.pieceQueue.setPriorities(priorities, c.queueLimitLocked())
+}
+
+// queueLimitLocked returns the end of the window of a sequential download, the pieces from it on
+// are held back.
+func (c *Client) queueLimitLocked() int {
+	if c.sequential && c.window > 0 {
+		return min(c.front+c.window, c.pieceCount)
+	}
+	return c.pieceCount
+}
+
+// streamingLocked reports whether a reader needs the piece next.
+func (c *Client) streamingLocked(index uint32) bool {
+	for _, pieces := range c.streams {
+		if int(index) >= pieces.first && int(index) <= pieces.last {
+			return true
+		}
+	}
+	return false
+}
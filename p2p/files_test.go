@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+func TestClientFiles(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-1, b.txt in 1-2 and c.txt in 2-3.
+	files := map[string][]byte{
+		"dir/a.txt": randomBytes(t, 15),
+		"dir/b.txt": randomBytes(t, 10),
+		"dir/c.txt": randomBytes(t, 15),
+	}
+	// The tracker has no peers, the web seed is never asked for data.
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "dir", 10, files, []string{"dir/a.txt", "dir/b.txt", "dir/c.txt"}, tracker.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.Default(), torrent)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	client.setHave(0)
+	client.setHave(1)
+
+	want := []FileStats{
+		{Path: "dir/a.txt", Length: 15, Progress: 1},
+		{Path: "dir/b.txt", Length: 10, Progress: 0.5},
+		{Path: "dir/c.txt", Length: 15, Progress: 0},
+	}
+	if got := client.Files(); !slices.Equal(got, want) {
+		t.Errorf("Files() = %+v, want %+v", got, want)
+	}
+
+	if err := client.SetFilePriority(3, PriorityHigh); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("SetFilePriority(3) error = %v, want %v", err, ErrFileNotFound)
+	}
+	if err := client.SetFilePriority(2, PriorityHigh+5); err != nil {
+		t.Fatalf("SetFilePriority() error = %v", err)
+	}
+	if err := client.SetFilePriority(0, PriorityLow); err != nil {
+		t.Fatalf("SetFilePriority() error = %v", err)
+	}
+	if got := client.Files()[2].Priority; got != PriorityHigh {
+		t.Errorf("priority = %v, want %v", got, PriorityHigh)
+	}
+
+	// A piece shared by two files takes the higher priority.
+	for index, want := range []FilePriority{PriorityLow, PriorityNormal, PriorityHigh, PriorityHigh} {
+		if got := client.pieceQueue.priority(uint32(index)); got != want {
+			t.Errorf("priority(%d) = %v, want %v", index, got, want)
+		}
+	}
+}
+
+func TestEmptyFiles(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-1 and the empty files are in none.
+	files := map[string][]byte{"empty.txt": {}, "a.txt": randomBytes(t, 15), "mid.txt": {}, "last.txt": {}}
+	order := []string{"empty.txt", "a.txt", "mid.txt", "last.txt"}
+	torrent, _ := newTestTorrent(t, "http://localhost/announce", "dir", 10, files, order)
+
+	var got [][2]int
+	for _, span := range fileSpans(&torrent.File) {
+		got = append(got, [2]int{span.first, span.last})
+	}
+	if want := [][2]int{{0, -1}, {0, 1}, {1, 0}, {1, 0}}; !slices.Equal(got, want) {
+		t.Errorf("fileSpans() pieces = %v, want %v", got, want)
+	}
+
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetFilePriority(1, PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.PiecesSkipped != 2 || !s.Done() {
+		t.Errorf("Stats() skipped %d pieces, done %t, want 2 and true", s.PiecesSkipped, s.Done())
+	}
+	for _, f := range c.Files() {
+		if f.Length == 0 && f.Progress != 1 {
+			t.Errorf("Files() progress of the empty %s = %v, want 1", f.Path, f.Progress)
+		}
+	}
+}
+
+func TestPieceQueue(t *testing.T) {
+	q := newPieceQueue(8)
+	q.setPriorities([]FilePriority{PriorityLow, PriorityNormal, PriorityHigh, PriorityHigh, PriorityNormal, 0, 0, 0}, 8)
+
+	for _, i := range []uint32{4, 0, 3, 1, 2} {
+		q.push(&Piece{Index: i})
+	}
+
+	// The priorities are read when a piece is taken, a change applies to the queued pieces.
+	q.setPriorities([]FilePriority{PriorityLow, PriorityNormal, PriorityHigh, PriorityHigh, PriorityHigh, 0, 0, 0}, 8)
+
+	var got []uint32
+	for q.len() > 0 {
+		piece, ok := q.pop(nil)
+		if !ok {
+			t.Fatal("pop() failed with pieces in the queue")
+		}
+		got = append(got, piece.Index)
+	}
+	if want := []uint32{2, 3, 4, 1, 0}; !slices.Equal(got, want) {
+		t.Errorf("pop() order = %v, want %v", got, want)
+	}
+
+	quitch := make(chan struct{})
+	close(quitch)
+	if _, ok := q.pop(quitch); ok {
+		t.Error("pop() on an empty queue succeeded after quitch was closed")
+	}
+
+	// A blocked pop takes the piece pushed later.
+	done := make(chan uint32)
+	go func() {
+		piece, _ := q.pop(nil)
+		done <- piece.Index
+	}()
+	q.push(&Piece{Index: 7})
+	if index := <-done; index != 7 {
+		t.Errorf("pop() = %d, want 7", index)
+	}
+
+	// The skipped pieces and the ones past the limit are held back until a reader needs them.
+	q.setPriorities([]FilePriority{PrioritySkip, 0, 0, 0, 0, 0, 0, 0}, 3)
+	q.push(&Piece{Index: 0}, &Piece{Index: 5})
+	if piece, ok := q.pop(quitch); ok {
+		t.Errorf("pop() = %d, want the pieces held back", piece.Index)
+	}
+	q.stream(pieceRange{first: 0, last: -1}, pieceRange{first: 5, last: 5})
+	if piece, ok := q.pop(quitch); !ok || piece.Index != 5 || q.priority(5) != priorityStreaming {
+		t.Errorf("pop() with a reader at piece 5 = %v, %t", piece, ok)
+	}
+	q.setLimit(8)
+	q.stream(pieceRange{first: 5, last: 5}, pieceRange{first: 0, last: 0})
+	if piece, ok := q.pop(quitch); !ok || piece.Index != 0 {
+		t.Errorf("pop() with a reader at the skipped piece 0 = %v, %t", piece, ok)
+	}
+}
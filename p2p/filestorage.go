@@ -0,0 +1,256 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// NewFileStorage returns a storage that writes the pieces into the files of the torrent under dir,
+// the files of a multi-file torrent into a directory named after it. The parts of the pieces that
+// belong to skipped files go to a parts file in dir, ".<info hash>.parts", so a skipped file is not
+// created unless a piece of it is needed later. The padding of hybrid torrents is not written.
+func NewFileStorage(dir string) Storage {
+	return fileStorage{dir: dir}
+}
+
+type fileStorage struct {
+	dir string
+}
+
+func (s fileStorage) OpenTorrent(t *bencode.Torrent) (PieceStorage, error) {
+	if err := checkTorrentName(string(t.File.Info.Name)); err != nil {
+		return nil, err
+	}
+	root := s.dir
+	if t.File.Info.IsMultiFile() {
+		root = filepath.Join(s.dir, string(t.File.Info.Name))
+	}
+	infoHash := t.File.ProtocolInfoHash()
+
+	p := &filePieces{
+		partsPath:   filepath.Join(s.dir, "."+hex.EncodeToString(infoHash[:])+".parts"),
+		pieceLength: int64(t.File.Info.PieceLength),
+		lengths:     make(map[uint32]int),
+	}
+	for _, span := range fileSpans(&t.File) {
+		if !filepath.IsLocal(filepath.FromSlash(span.path)) {
+			return nil, fmt.Errorf("%w, %q", ErrUnsafePath, span.path)
+		}
+		p.files = append(p.files, &storedFile{
+			path:   filepath.Join(root, filepath.FromSlash(span.path)),
+			offset: span.offset,
+			length: span.length,
+		})
+	}
+	return p, nil
+}
+
+// checkTorrentName checks that the name of the torrent is a single element of a path, the files of
+// the torrent are stored under it.
+func checkTorrentName(name string) error {
+	if name == "" || name == "." || strings.ContainsAny(name, `/\`) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("%w, the name of the torrent is %q", ErrUnsafePath, name)
+	}
+	return nil
+}
+
+// filePieces keeps the pieces of a torrent in its files.
+type filePieces struct {
+	mu          sync.Mutex
+	files       []*storedFile
+	parts       *os.File // opened with the first part of a skipped file
+	partsPath   string
+	pieceLength int64
+	lengths     map[uint32]int // the written pieces
+}
+
+// storedFile is a file of the torrent on disk.
+type storedFile struct {
+	path    string
+	offset  int64 // in the torrent
+	length  int64
+	inParts bool     // the file is skipped and not created, its data is in the parts file
+	f       *os.File // opened with the first write or read
+}
+
+// filePart is the part of a piece stored in a file.
+type filePart struct {
+	file   *storedFile
+	offset int64 // in the file
+	start  int   // in the piece
+	end    int
+}
+
+// partsLocked returns the parts of the piece of the length that are stored in files, the padding
+// between them is not.
+func (p *filePieces) partsLocked(index uint32, length int) []filePart {
+	start := int64(index) * p.pieceLength
+	end := start + int64(length)
+
+	var parts []filePart
+	for _, f := range p.files {
+		from, to := max(start, f.offset), min(end, f.offset+f.length)
+		if from >= to {
+			continue
+		}
+		parts = append(parts, filePart{file: f, offset: from - f.offset, start: int(from - start), end: int(to - start)})
+	}
+	return parts
+}
+
+func (p *filePieces) WritePiece(index uint32, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, part := range p.partsLocked(index, len(data)) {
+		if err := p.writePartLocked(part, data[part.start:part.end]); err != nil {
+			return err
+		}
+	}
+	p.lengths[index] = len(data)
+	return nil
+}
+
+// writePartLocked writes the part to its file, or to the parts file at the offset in the torrent
+// if the file is skipped.
+func (p *filePieces) writePartLocked(part filePart, data []byte) error {
+	f, offset, err := p.openPartLocked(part)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, offset)
+	return err
+}
+
+func (p *filePieces) ReadPiece(index uint32) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	length, ok := p.lengths[index]
+	if !ok {
+		return nil, fmt.Errorf("%w, piece %d", ErrPieceNotFound, index)
+	}
+	data := make([]byte, length)
+	for _, part := range p.partsLocked(index, length) {
+		f, offset, err := p.openPartLocked(part)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.ReadAt(data[part.start:part.end], offset); err != nil {
+			return nil, fmt.Errorf("%w, piece %d", err, index)
+		}
+	}
+	return data, nil
+}
+
+// openPartLocked returns the file the part is stored in and its offset there.
+func (p *filePieces) openPartLocked(part filePart) (*os.File, int64, error) {
+	if part.file.inParts {
+		if p.parts == nil {
+			parts, err := os.OpenFile(p.partsPath, os.O_RDWR|os.O_CREATE, 0o644)
+			if err != nil {
+				return nil, 0, err
+			}
+			p.parts = parts
+		}
+		return p.parts, part.file.offset + part.offset, nil
+	}
+
+	if part.file.f == nil {
+		if err := os.MkdirAll(filepath.Dir(part.file.path), 0o755); err != nil {
+			return nil, 0, err
+		}
+		f, err := os.OpenFile(part.file.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, 0, err
+		}
+		part.file.f = f
+	}
+	return part.file.f, part.offset, nil
+}
+
+// SkipFile keeps a skipped file that was not created yet out of the file system. When it is
+// wanted again the parts of it that were written are moved into the file.
+func (p *filePieces) SkipFile(file int, skip bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if file < 0 || file >= len(p.files) {
+		return fmt.Errorf("%w, file %d of %d", ErrFileNotFound, file, len(p.files))
+	}
+	f := p.files[file]
+	if skip {
+		if f.f != nil {
+			return nil // Its data is already in the file.
+		}
+		if _, err := os.Stat(f.path); err == nil {
+			return nil // It was created before.
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		f.inParts = true
+		return nil
+	}
+	if !f.inParts {
+		return nil
+	}
+
+	// Read the written parts of the file before they are looked for in the file.
+	var moved []filePart
+	var data [][]byte
+	for index, length := range p.lengths {
+		for _, part := range p.partsLocked(index, length) {
+			if part.file != f {
+				continue
+			}
+			buf := make([]byte, part.end-part.start)
+			if _, err := p.parts.ReadAt(buf, f.offset+part.offset); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			moved, data = append(moved, part), append(data, buf)
+		}
+	}
+	f.inParts = false
+	for i, part := range moved {
+		if err := p.writePartLocked(part, data[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the files, the parts file is removed when no skipped file has data in it.
+func (p *filePieces) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, f := range p.files {
+		if f.f != nil {
+			errs = append(errs, f.f.Close())
+			f.f = nil
+		}
+	}
+	if p.parts != nil {
+		errs = append(errs, p.parts.Close())
+		p.parts = nil
+
+		used := false
+		for _, f := range p.files {
+			used = used || f.inParts
+		}
+		if !used {
+			errs = append(errs, os.Remove(p.partsPath))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,179 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorage(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-1, dir/b.txt in 1-2 and c.txt in 2-3.
+	files := map[string][]byte{
+		"a.txt":     randomBytes(t, 15),
+		"dir/b.txt": randomBytes(t, 10),
+		"c.txt":     randomBytes(t, 15),
+	}
+	order := []string{"a.txt", "dir/b.txt", "c.txt"}
+	torrent, data := newTestTorrent(t, "http://localhost/announce", "root", 10, files, order)
+
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir).OpenTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipper := storage.(FileSkipper)
+	if err := skipper.SkipFile(1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i += 10 {
+		if err := storage.WritePiece(uint32(i/10), data[i:min(i+10, len(data))]); err != nil {
+			t.Fatalf("WritePiece(%d) error = %v", i/10, err)
+		}
+	}
+	for i := 0; i < len(data); i += 10 {
+		got, err := storage.ReadPiece(uint32(i / 10))
+		if err != nil || !bytes.Equal(got, data[i:min(i+10, len(data))]) {
+			t.Errorf("ReadPiece(%d) = %x, %v, want %x", i/10, got, err, data[i:min(i+10, len(data))])
+		}
+	}
+	if _, err := storage.ReadPiece(4); !errors.Is(err, ErrPieceNotFound) {
+		t.Errorf("ReadPiece(4) error = %v, want %v", err, ErrPieceNotFound)
+	}
+
+	root := filepath.Join(dir, "root")
+	for _, name := range []string{"a.txt", "c.txt"} {
+		if got, err := os.ReadFile(filepath.Join(root, name)); err != nil || !bytes.Equal(got, files[name]) {
+			t.Errorf("%s = %x, %v, want %x", name, got, err, files[name])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("the directory of the skipped file exists, Stat() error = %v", err)
+	}
+
+	// The parts of the skipped file move into it when it is wanted again.
+	if err := skipper.SkipFile(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "dir", "b.txt")); err != nil || !bytes.Equal(got, files["dir/b.txt"]) {
+		t.Errorf("dir/b.txt = %x, %v, want %x", got, err, files["dir/b.txt"])
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "root" {
+		t.Errorf("the download directory has %v, %v, want only root", entries, err)
+	}
+}
+
+func TestFileStorageUnsafePath(t *testing.T) {
+	files := map[string][]byte{"../evil.txt": randomBytes(t, 10), "ok.txt": randomBytes(t, 10)}
+	tests := []struct {
+		name  string // of the torrent
+		order []string
+	}{
+		{name: "root", order: []string{"ok.txt", "../evil.txt"}},
+		{name: "..", order: []string{"ok.txt"}},
+		{name: "../../x", order: []string{"ok.txt"}},
+		{name: "a/b", order: []string{"ok.txt"}},
+		{name: ".", order: []string{"ok.txt"}},
+		{name: "", order: []string{"ok.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent, _ := newTestTorrent(t, "http://localhost/announce", tt.name, 10, files, tt.order)
+			dir := filepath.Join(t.TempDir(), "downloads")
+			if _, err := NewFileStorage(dir).OpenTorrent(torrent); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("OpenTorrent() error = %v, want %v", err, ErrUnsafePath)
+			}
+			if _, err := Verify(context.Background(), torrent, dir, 1); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrUnsafePath)
+			}
+		})
+	}
+}
+
+func TestClientSkippedFiles(t *testing.T) {
+	// Pieces of 16KiB: a.bin is in 0-1, b.bin in 1-3 and c.bin in 3-4, only 0 and 4 are skipped.
+	files := map[string][]byte{
+		"a.bin": randomBytes(t, 20000),
+		"b.bin": randomBytes(t, 30000),
+		"c.bin": randomBytes(t, 20000),
+	}
+	order := []string{"a.bin", "b.bin", "c.bin"}
+	seedDir := t.TempDir()
+	writeTestFiles(t, filepath.Join(seedDir, "root"), files)
+	seed := httptest.NewServer(http.FileServer(http.Dir(seedDir)))
+	defer seed.Close()
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "root", 16384, files, order, seed.URL+"/")
+
+	dir := t.TempDir()
+	client, err := NewClient(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, WithStorage(NewFileStorage(dir)))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	for i, priority := range []FilePriority{PrioritySkip, PriorityHigh, PrioritySkip} {
+		if err := client.SetFilePriority(i, priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := client.Stats(); s.PiecesSkipped != 2 || s.Done() {
+		t.Errorf("Stats() skipped %d pieces, done %t, want 2 and false", s.PiecesSkipped, s.Done())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := client.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if s := client.Stats(); !s.Done() || s.Progress() != 1 || s.PiecesDone != 3 {
+		t.Errorf("Stats() after Wait() = %d done, %d skipped of %d, want 3 done", s.PiecesDone, s.PiecesSkipped, s.PiecesTotal)
+	}
+	if err := client.Download(ctx, io.Discard); !errors.Is(err, ErrPieceSkipped) {
+		t.Errorf("Download() error = %v, want %v", err, ErrPieceSkipped)
+	}
+
+	root := filepath.Join(dir, "root")
+	if got, err := os.ReadFile(filepath.Join(root, "b.bin")); err != nil || !bytes.Equal(got, files["b.bin"]) {
+		t.Errorf("b.bin has %d bytes, %v, want the %d of the file", len(got), err, len(files["b.bin"]))
+	}
+	for _, name := range []string{"a.bin", "c.bin"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("the skipped %s exists, Stat() error = %v", name, err)
+		}
+	}
+
+	// A reader gets the pieces of a skipped file.
+	r, err := client.NewReader(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, files["c.bin"]) {
+		t.Errorf("ReadAll() of the skipped c.bin read %d bytes, %v", len(got), err)
+	}
+}
+
+func TestParseFilePriority(t *testing.T) {
+	for _, want := range []FilePriority{PrioritySkip, PriorityLow, PriorityNormal, PriorityHigh} {
+		if got, err := ParseFilePriority(want.String()); got != want || err != nil {
+			t.Errorf("ParseFilePriority(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if _, err := ParseFilePriority("urgent"); !errors.Is(err, ErrInvalidPriority) {
+		t.Errorf("ParseFilePriority(urgent) error = %v, want %v", err, ErrInvalidPriority)
+	}
+}
@@ -24,6 +24,23 @@ type (
 	HandshakeDecoder struct{ r io.Reader }
 )
 
+// ReservedV2 is the bit in the last reserved byte that tells the peer we support BitTorrent v2 (BEP 52).
+const ReservedV2 byte = 0x10
+
+// ReservedExtension is the bit in the sixth reserved byte that tells the peer we support the
+// extension protocol (BEP 10).
+const ReservedExtension byte = 0x10
+
+// SupportsExtensions reports whether the peer set the extension protocol bit in the reserved bytes.
+func (msg *HandshakeMessage) SupportsExtensions() bool {
+	return msg.Reserved[5]&ReservedExtension != 0
+}
+
+// SupportsV2 reports whether the peer set the BitTorrent v2 bit in the reserved bytes.
+func (msg *HandshakeMessage) SupportsV2() bool {
+	return msg.Reserved[7]&ReservedV2 != 0
+}
+
 func NewHandshakeEncoder(w io.Writer) *HandshakeEncoder { return &HandshakeEncoder{w: w} }
 func NewHandshakeDecoder(r io.Reader) *HandshakeDecoder { return &HandshakeDecoder{r: r} }
 
@@ -56,8 +73,8 @@ func (enc *HandshakeEncoder) Encode(msg *HandshakeMessage) error {
 	if err != nil {
 		return fmt.Errorf("%w, because: %w", ErrWriteConn, err)
 	}
-	// 3. eight reserved bytes, which are all set to zero (8 bytes)
-	_, err = enc.w.Write(make([]byte, 8))
+	// 3. eight reserved bytes, which are used to signal the supported extensions (8 bytes)
+	_, err = enc.w.Write(msg.Reserved[:])
 	if err != nil {
 		return fmt.Errorf("%w, because: %w", ErrWriteConn, err)
 	}
@@ -76,8 +93,9 @@ func (enc *HandshakeEncoder) Encode(msg *HandshakeMessage) error {
 }
 
 func (dec *HandshakeDecoder) Decode() (*HandshakeMessage, error) {
-	buf := make([]byte, 128)
-	n, err := io.ReadAtLeast(dec.r, buf, HandshakeMessageLength)
+	// Read exactly the handshake, the messages that follow it may already be on the wire.
+	buf := make([]byte, HandshakeMessageLength)
+	n, err := io.ReadFull(dec.r, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +118,7 @@ func (dec *HandshakeDecoder) Decode() (*HandshakeMessage, error) {
 	msg.Protocol = string(buf[index:msg.ProtocolLength])
 	index += int(msg.ProtocolLength)
 
-	msg.Reserved = [8]byte{}
+	copy(msg.Reserved[:], buf[index:index+8])
 	index += 8
 
 	msg.InfoHash = buf[index : index+20]
@@ -0,0 +1,256 @@
+package p2p
+
+import (
+	"crypto/sha1"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*HashRequest)(nil)
+	_ encoding.BinaryUnmarshaler = (*HashRequest)(nil)
+	_ encoding.BinaryMarshaler   = (*HashesMessage)(nil)
+	_ encoding.BinaryUnmarshaler = (*HashesMessage)(nil)
+)
+
+const (
+	hashRequestLength = 32 + 4*4
+	// MaxHashesPerRequest is the most base layer hashes a peer may ask for in a single request.
+	MaxHashesPerRequest = 512
+)
+
+// HashRequest asks for a range of hashes of a file's merkle tree, it is also the payload of a hash reject.
+type HashRequest struct {
+	PiecesRoot  [32]byte
+	BaseLayer   uint32 // the layer of the requested hashes, 0 is the 16KiB blocks
+	Index       uint32 // the index of the first hash within the layer
+	Length      uint32 // the amount of hashes, a power of two
+	ProofLayers uint32 // how many layers of uncle hashes to include
+}
+
+// HashesMessage is the answer to a HashRequest.
+type HashesMessage struct {
+	HashRequest
+	Hashes [][32]byte // the requested hashes followed by the uncle hashes
+}
+
+func (r *HashRequest) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, hashRequestLength)
+	copy(buf, r.PiecesRoot[:])
+	binary.BigEndian.PutUint32(buf[32:], r.BaseLayer)
+	binary.BigEndian.PutUint32(buf[36:], r.Index)
+	binary.BigEndian.PutUint32(buf[40:], r.Length)
+	binary.BigEndian.PutUint32(buf[44:], r.ProofLayers)
+	return buf, nil
+}
+
+func (r *HashRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < hashRequestLength {
+		return fmt.Errorf("%w: hash request is too small len=%d", ErrInvalidHashMessage, len(data))
+	}
+	copy(r.PiecesRoot[:], data)
+	r.BaseLayer = binary.BigEndian.Uint32(data[32:])
+	r.Index = binary.BigEndian.Uint32(data[36:])
+	r.Length = binary.BigEndian.Uint32(data[40:])
+	r.ProofLayers = binary.BigEndian.Uint32(data[44:])
+	return nil
+}
+
+func (m *HashesMessage) MarshalBinary() ([]byte, error) {
+	buf, err := m.HashRequest.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range m.Hashes {
+		buf = append(buf, h[:]...)
+	}
+	return buf, nil
+}
+
+func (m *HashesMessage) UnmarshalBinary(data []byte) error {
+	if err := m.HashRequest.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	data = data[hashRequestLength:]
+	if len(data)%32 != 0 {
+		return fmt.Errorf("%w: hashes are not a multiple of 32 bytes len=%d", ErrInvalidHashMessage, len(data))
+	}
+	m.Hashes = make([][32]byte, len(data)/32)
+	for i := range m.Hashes {
+		copy(m.Hashes[i][:], data[i*32:])
+	}
+	return nil
+}
+
+// PieceV2 describes how to verify a piece against the merkle tree of its file.
+type PieceV2 struct {
+	PiecesRoot [32]byte
+	Index      int  // the index of the piece within its file
+	Single     bool // the file fits into one piece, so the pieces root is the hash of the piece
+	Leaves     int  // the amount of 16KiB leaves under the piece hash
+	Length     int  // the amount of file data in the piece, the rest is padding (hybrid torrents)
+}
+
+// Hash returns the merkle root the piece has to hash to.
+func (p *PieceV2) Hash(f *bencode.File) ([32]byte, error) {
+	if p.Single {
+		return p.PiecesRoot, nil
+	}
+	layer, ok := f.PieceLayer(p.PiecesRoot)
+	if !ok || p.Index >= len(layer) {
+		return [32]byte{}, fmt.Errorf("%w, pieces root %x", ErrMissingPieceLayer, p.PiecesRoot)
+	}
+	return layer[p.Index], nil
+}
+
+// v2Pieces returns the verification data of every v2 piece, indexed like the pieces on the wire,
+// where every file starts at a piece boundary.
+func v2Pieces(f *bencode.File) map[uint32]*PieceV2 {
+	var (
+		pieceLength = int(f.Info.PieceLength)
+		pieces      = make(map[uint32]*PieceV2)
+		index       uint32
+	)
+	for _, file := range f.Info.FileTree {
+		length := int(file.Length)
+		if length == 0 {
+			continue
+		}
+
+		count := (length + pieceLength - 1) / pieceLength
+		for i := range count {
+			p := &PieceV2{
+				PiecesRoot: file.PiecesRoot,
+				Index:      i,
+				Single:     count == 1,
+				Leaves:     pieceLength / bencode.BlockSize,
+				Length:     min(pieceLength, length-i*pieceLength),
+			}
+			if p.Single {
+				p.Leaves = bencode.NextPowerOfTwo((length + bencode.BlockSize - 1) / bencode.BlockSize)
+			}
+			pieces[index+uint32(i)] = p
+		}
+		index += uint32(count)
+	}
+	return pieces
+}
+
+// VerifyPiece checks the piece data against the v1 SHA-1 hash and the v2 merkle tree, whichever the torrent has.
+func VerifyPiece(f *bencode.File, piece *Piece, data []byte) error {
+	if len(data) != piece.TotalSize {
+		return fmt.Errorf("%w, piece %d has %d bytes, want %d", ErrInvalidPieceHash, piece.Index, len(data), piece.TotalSize)
+	}
+
+	if f.IsV1() {
+		sum := sha1.Sum(data)
+		if hex.EncodeToString(sum[:]) != piece.Hash {
+			return fmt.Errorf("%w, piece %d", ErrInvalidPieceHash, piece.Index)
+		}
+	}
+
+	if piece.V2 != nil {
+		want, err := piece.V2.Hash(f)
+		if err != nil {
+			return err
+		}
+		if bencode.MerkleRoot(bencode.BlockHashes(data[:piece.V2.Length]), piece.V2.Leaves) != want {
+			return fmt.Errorf("%w, piece %d does not match the merkle tree", ErrInvalidPieceHash, piece.Index)
+		}
+	}
+
+	return nil
+}
+
+// merkleLayers builds the tree above a piece layer, layers[0] is the padded piece layer and the last one is the root.
+func merkleLayers(layer [][32]byte, pieceLength int) [][][32]byte {
+	pad := bencode.PadHash(pieceLength / bencode.BlockSize)
+	base := make([][32]byte, bencode.NextPowerOfTwo(len(layer)))
+	n := copy(base, layer)
+	for i := n; i < len(base); i++ {
+		base[i] = pad
+	}
+
+	layers := [][][32]byte{base}
+	for len(base) > 1 {
+		next := make([][32]byte, len(base)/2)
+		for i := range next {
+			next[i] = bencode.MerkleRoot(base[2*i:2*i+2], 2)
+		}
+		layers = append(layers, next)
+		base = next
+	}
+	return layers
+}
+
+// hashesFor answers a hash request from our piece layers, only requests for the piece layer can be answered.
+func hashesFor(f *bencode.File, req *HashRequest) (*HashesMessage, error) {
+	pieceLength := int(f.Info.PieceLength)
+	if req.BaseLayer != uint32(bits.TrailingZeros(uint(pieceLength/bencode.BlockSize))) {
+		return nil, fmt.Errorf("%w: base layer %d is not the piece layer", ErrInvalidHashMessage, req.BaseLayer)
+	}
+	if req.Length < 2 || req.Length > MaxHashesPerRequest || req.Length&(req.Length-1) != 0 || req.Index%req.Length != 0 {
+		return nil, fmt.Errorf("%w: invalid range index=%d length=%d", ErrInvalidHashMessage, req.Index, req.Length)
+	}
+
+	layer, ok := f.PieceLayer(req.PiecesRoot)
+	if !ok {
+		return nil, fmt.Errorf("%w, pieces root %x", ErrMissingPieceLayer, req.PiecesRoot)
+	}
+
+	layers := merkleLayers(layer, pieceLength)
+	if int(req.Index+req.Length) > len(layers[0]) {
+		return nil, fmt.Errorf("%w: range is outside of the layer", ErrInvalidHashMessage)
+	}
+
+	msg := &HashesMessage{HashRequest: *req}
+	msg.Hashes = append(msg.Hashes, layers[0][req.Index:req.Index+req.Length]...)
+
+	level, node := bits.TrailingZeros32(req.Length), int(req.Index/req.Length)
+	for proofs := uint32(0); level < len(layers)-1 && proofs < req.ProofLayers; proofs++ {
+		msg.Hashes = append(msg.Hashes, layers[level][node^1])
+		level++
+		node /= 2
+	}
+
+	return msg, nil
+}
+
+// VerifyHashes checks that the hashes and their uncles add up to the pieces root.
+func VerifyHashes(f *bencode.File, msg *HashesMessage) bool {
+	if msg.Length == 0 || msg.Length&(msg.Length-1) != 0 || int(msg.Length) > len(msg.Hashes) {
+		return false
+	}
+
+	var (
+		root  = bencode.MerkleRoot(msg.Hashes[:msg.Length], int(msg.Length))
+		node  = msg.Index / msg.Length
+		width = msg.Length // the amount of base layer hashes the current node covers
+	)
+	for _, uncle := range msg.Hashes[msg.Length:] {
+		if node%2 == 0 {
+			root = bencode.MerkleRoot([][32]byte{root, uncle}, 2)
+		} else {
+			root = bencode.MerkleRoot([][32]byte{uncle, root}, 2)
+		}
+		node /= 2
+		width *= 2
+	}
+
+	// The node we reached has to be the top of the file's tree.
+	for _, file := range f.Info.FileTree {
+		if file.PiecesRoot != msg.PiecesRoot {
+			continue
+		}
+		nodeSize := bencode.BlockSize << msg.BaseLayer
+		leaves := bencode.NextPowerOfTwo((int(file.Length) + nodeSize - 1) / nodeSize)
+		return node == 0 && int(width) == leaves && root == msg.PiecesRoot
+	}
+
+	return false
+}
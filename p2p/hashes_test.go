@@ -0,0 +1,256 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+// newTestTorrentV2 builds a v2 (or hybrid) torrent of a directory with the files.
+func newTestTorrentV2(t *testing.T, pieceLength int, files []testFile, hybrid bool) *bencode.Torrent {
+	t.Helper()
+
+	var (
+		tree        = bencode.Dictionary{}
+		layers      = bencode.Dictionary{}
+		v1Files     = bencode.List{}
+		v1Data      []byte
+		blocksPiece = pieceLength / bencode.BlockSize
+	)
+	for i, f := range files {
+		leaves := bencode.BlockHashes(f.data)
+		var root [32]byte
+		if len(f.data) <= pieceLength {
+			root = bencode.MerkleRoot(leaves, bencode.NextPowerOfTwo(len(leaves)))
+		} else {
+			var layer [][32]byte
+			var encoded []byte
+			for off := 0; off < len(f.data); off += pieceLength {
+				h := bencode.MerkleRoot(bencode.BlockHashes(f.data[off:min(off+pieceLength, len(f.data))]), blocksPiece)
+				layer = append(layer, h)
+				encoded = append(encoded, h[:]...)
+			}
+			root = bencode.PieceLayerRoot(layer, pieceLength)
+			layers[bencode.String(root[:])] = bencode.String(encoded)
+		}
+		tree[bencode.String(f.name)] = bencode.Dictionary{"": bencode.Dictionary{
+			"length":      bencode.Integer(len(f.data)),
+			"pieces root": bencode.String(root[:]),
+		}}
+
+		v1Files = append(v1Files, bencode.Dictionary{
+			"length": bencode.Integer(len(f.data)),
+			"path":   bencode.List{bencode.String(f.name)},
+		})
+		v1Data = append(v1Data, f.data...)
+		if pad := (pieceLength - len(f.data)%pieceLength) % pieceLength; pad > 0 && i != len(files)-1 {
+			v1Files = append(v1Files, bencode.Dictionary{
+				"length": bencode.Integer(pad),
+				"path":   bencode.List{bencode.String(".pad"), bencode.String("pad")},
+				"attr":   bencode.String("p"),
+			})
+			v1Data = append(v1Data, make([]byte, pad)...)
+		}
+	}
+
+	info := bencode.Dictionary{
+		"name":         bencode.String("dir"),
+		"piece length": bencode.Integer(pieceLength),
+		"meta version": bencode.Integer(2),
+		"file tree":    tree,
+	}
+	if hybrid {
+		var pieces []byte
+		for off := 0; off < len(v1Data); off += pieceLength {
+			sum := sha1.Sum(v1Data[off:min(off+pieceLength, len(v1Data))])
+			pieces = append(pieces, sum[:]...)
+		}
+		info["pieces"] = bencode.String(pieces)
+		info["files"] = v1Files
+	}
+
+	encoded, err := bencode.Dictionary{
+		"announce":     bencode.String("http://tracker/announce"),
+		"info":         info,
+		"piece layers": layers,
+	}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent, err := bencode.NewTorrent(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("NewTorrent() error = %v", err)
+	}
+	return torrent
+}
+
+func TestVerifyPieceV2(t *testing.T) {
+	const pieceLength = 2 * bencode.BlockSize
+	files := []testFile{
+		{name: "a.bin", data: randomBytes(t, 5*bencode.BlockSize+100)},
+		{name: "b.bin", data: randomBytes(t, 1000)},
+		{name: "c.bin", data: randomBytes(t, pieceLength)},
+	}
+
+	for _, hybrid := range []bool{false, true} {
+		name := "v2"
+		if hybrid {
+			name = "hybrid"
+		}
+		t.Run(name, func(t *testing.T) {
+			torrent := newTestTorrentV2(t, pieceLength, files, hybrid)
+			if torrent.File.IsHybrid() != hybrid {
+				t.Fatalf("IsHybrid() = %v, want %v", torrent.File.IsHybrid(), hybrid)
+			}
+			if want := sha256.Sum256(mustEncodeInfo(t, torrent)); torrent.File.InfoHashV2 != want {
+				t.Errorf("InfoHashV2 = %x, want %x", torrent.File.InfoHashV2, want)
+			}
+
+			pieces, err := torrentPieces(&torrent.File, ChunkSize)
+			if err != nil {
+				t.Fatalf("torrentPieces() error = %v", err)
+			}
+			if len(pieces) != 5 {
+				t.Fatalf("len(Pieces()) = %d, want %d", len(pieces), 5)
+			}
+
+			// Pieces never span files, so they can be cut out of the files directly.
+			var datas [][]byte
+			for _, f := range files {
+				for off := 0; off < len(f.data); off += pieceLength {
+					piece := f.data[off:min(off+pieceLength, len(f.data))]
+					if hybrid && len(piece) < pieceLength && f.name != files[len(files)-1].name {
+						piece = append(append([]byte(nil), piece...), make([]byte, pieceLength-len(piece))...)
+					}
+					datas = append(datas, piece)
+				}
+			}
+
+			for i := range pieces {
+				if err := VerifyPiece(&torrent.File, &pieces[i], datas[i]); err != nil {
+					t.Errorf("VerifyPiece(%d) error = %v", i, err)
+				}
+
+				corrupt := append([]byte(nil), datas[i]...)
+				corrupt[0] ^= 0xff
+				if err := VerifyPiece(&torrent.File, &pieces[i], corrupt); !errors.Is(err, ErrInvalidPieceHash) {
+					t.Errorf("VerifyPiece(%d) of corrupt data error = %v, want %v", i, err, ErrInvalidPieceHash)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRequest(t *testing.T) {
+	const pieceLength = bencode.BlockSize
+	files := []testFile{{name: "big.bin", data: randomBytes(t, 11*pieceLength)}}
+	torrent := newTestTorrentV2(t, pieceLength, files, false)
+	root := torrent.File.Info.FileTree[0].PiecesRoot
+
+	tests := []struct {
+		name    string
+		req     HashRequest
+		wantErr bool
+	}{
+		{name: "Whole layer", req: HashRequest{PiecesRoot: root, Index: 0, Length: 16}},
+		{name: "Range with proofs", req: HashRequest{PiecesRoot: root, Index: 4, Length: 4, ProofLayers: 2}},
+		{name: "Not a power of two", req: HashRequest{PiecesRoot: root, Index: 0, Length: 3}, wantErr: true},
+		{name: "Unknown root", req: HashRequest{PiecesRoot: [32]byte{1}, Index: 0, Length: 2}, wantErr: true},
+		{name: "Not the piece layer", req: HashRequest{PiecesRoot: root, BaseLayer: 3, Index: 0, Length: 2}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := hashesFor(&torrent.File, &tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hashesFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			data, err := msg.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := new(HashesMessage)
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+
+			if !VerifyHashes(&torrent.File, decoded) {
+				t.Error("VerifyHashes() = false, want true")
+			}
+
+			decoded.Hashes[0][0] ^= 0xff
+			if VerifyHashes(&torrent.File, decoded) {
+				t.Error("VerifyHashes() of tampered hashes = true, want false")
+			}
+		})
+	}
+}
+
+func TestMissingPieceLayer(t *testing.T) {
+	const pieceLength = 2 * bencode.BlockSize
+	torrent := newTestTorrentV2(t, pieceLength, []testFile{{name: "a.bin", data: randomBytes(t, 3*bencode.BlockSize)}}, false)
+	torrent.File.PieceLayers = nil // Built by hand, the decoder rejects such torrents.
+
+	if _, err := torrentPieces(&torrent.File, ChunkSize); !errors.Is(err, ErrMissingPieceLayer) {
+		t.Errorf("torrentPieces() error = %v, want %v", err, ErrMissingPieceLayer)
+	}
+	if _, err := NewClient(context.Background(), slog.Default(), torrent); !errors.Is(err, ErrMissingPieceLayer) {
+		t.Errorf("NewClient() error = %v, want %v", err, ErrMissingPieceLayer)
+	}
+}
+
+func mustEncodeInfo(t *testing.T, torrent *bencode.Torrent) []byte {
+	t.Helper()
+
+	info := bencode.Dictionary{
+		"name":         torrent.File.Info.Name,
+		"piece length": torrent.File.Info.PieceLength,
+		"meta version": torrent.File.Info.MetaVersion,
+	}
+	tree := bencode.Dictionary{}
+	for _, f := range torrent.File.Info.FileTree {
+		tree[f.Path[0]] = bencode.Dictionary{"": bencode.Dictionary{
+			"length":      f.Length,
+			"pieces root": bencode.String(f.PiecesRoot[:]),
+		}}
+	}
+	info["file tree"] = tree
+	if torrent.File.IsV1() {
+		info["pieces"] = bencode.String(torrent.File.Info.Pieces)
+		files := bencode.List{}
+		for _, f := range torrent.File.Info.Files {
+			path := bencode.List{}
+			for _, c := range f.Path {
+				path = append(path, c)
+			}
+			entry := bencode.Dictionary{"length": f.Length, "path": path}
+			if f.Attr != "" {
+				entry["attr"] = f.Attr
+			}
+			files = append(files, entry)
+		}
+		info["files"] = files
+	}
+
+	encoded, err := info.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
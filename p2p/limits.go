@@ -0,0 +1,33 @@
+package p2p
+
+// SetDownloadLimit limits the download rate of the torrent in bytes per second, 0 means no limit.
+func (c *Client) SetDownloadLimit(rate int) {
+	c.download.SetRate(rate)
+}
+
+// SetUploadLimit limits the upload rate of the torrent in bytes per second, 0 means no limit.
+func (c *Client) SetUploadLimit(rate int) {
+	c.upload.SetRate(rate)
+}
+
+func (c *Client) DownloadLimit() int { return c.download.Rate() }
+func (c *Client) UploadLimit() int   { return c.upload.Rate() }
+
+// SetPeerDownloadLimit limits the download rate of every connection of the torrent, including the open ones.
+func (c *Client) SetPeerDownloadLimit(rate int) {
+	c.peerDownload.Store(int64(max(rate, 0)))
+	for _, conn := range c.Connections() {
+		conn.download.SetRate(rate)
+	}
+}
+
+// SetPeerUploadLimit limits the upload rate of every connection of the torrent, including the open ones.
+func (c *Client) SetPeerUploadLimit(rate int) {
+	c.peerUpload.Store(int64(max(rate, 0)))
+	for _, conn := range c.Connections() {
+		conn.upload.SetRate(rate)
+	}
+}
+
+func (c *Client) PeerDownloadLimit() int { return int(c.peerDownload.Load()) }
+func (c *Client) PeerUploadLimit() int   { return int(c.peerUpload.Load()) }
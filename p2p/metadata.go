@@ -0,0 +1,278 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+const (
+	// MetadataPieceSize is the size of the pieces the info dictionary is sent in (BEP 9).
+	MetadataPieceSize = 16 * 1024
+	// MaxMetadataSize is the largest info dictionary a peer may announce.
+	MaxMetadataSize = 16 * 1024 * 1024
+)
+
+// utMetadataID is the extension ID the peers send the ut_metadata messages to us with.
+const utMetadataID = 1
+
+// The types of the ut_metadata messages.
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// FetchMetadata gets the info dictionary of the torrent of the magnet link from the peers of its
+// trackers (BEP 9) and returns the torrent with the trackers of the link. The peers are asked one
+// after another until one of them sends an info dictionary that matches the info hash, or the
+// context is done. BitTorrent v2 torrents can't be fetched this way, their piece layers are not
+// part of the info dictionary.
+func FetchMetadata(ctx context.Context, log *slog.Logger, magnet *bencode.Magnet, opts ...Option) (*bencode.Torrent, error) {
+	return fetchMetadata(ctx, log, newClientConfig(opts...), magnet)
+}
+
+// FetchMetadata is FetchMetadata with the peer ID, the port and the options of the session.
+func (s *Session) FetchMetadata(ctx context.Context, magnet *bencode.Magnet) (*bencode.Torrent, error) {
+	return fetchMetadata(ctx, s.log, s.cfg, magnet)
+}
+
+func fetchMetadata(ctx context.Context, log *slog.Logger, cfg ClientConfig, magnet *bencode.Magnet) (*bencode.Torrent, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if len(magnet.Trackers) == 0 {
+		return nil, fmt.Errorf("%w, the magnet link has no trackers", ErrNoMetadata)
+	}
+
+	var errs []error
+	for _, tracker := range magnet.Trackers {
+		announce, err := sendAnnounce(ctx, &cfg, &bencode.AnnounceMessage{
+			Announce: bencode.String(tracker),
+			InfoHash: bencode.String(hex.EncodeToString(magnet.InfoHash[:])),
+			PeerID:   bencode.String(cfg.PeerID),
+			Port:     bencode.Integer(cfg.Port),
+			Left:     1, // Unknown until the metadata arrives, but not 0, which would make us a seeder.
+			Compact:  1,
+			Key:      bencode.String(newKey()),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Debug("Tracker announce failed", "tracker", tracker, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, peer := range announce.Peers {
+			info, err := fetchPeerMetadata(ctx, &cfg, peer.Addr(), magnet.InfoHash)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Debug("Peer did not send the metadata", "peer", peer.Addr(), "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			log.Info("Fetched the metadata", "peer", peer.Addr(), "size", len(info))
+			return newMagnetTorrent(magnet, info)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%w, the trackers have no peers", ErrNoMetadata)
+	}
+	return nil, fmt.Errorf("%w, %w", ErrNoMetadata, errors.Join(errs...))
+}
+
+// fetchPeerMetadata downloads the info dictionary from the peer and checks it against the info hash.
+func fetchPeerMetadata(ctx context.Context, cfg *ClientConfig, addr string, infoHash [20]byte) ([]byte, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+	conn, err := cfg.Dialer.DialContext(dialCtx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	msg := &HandshakeMessage{InfoHash: infoHash[:], PeerID: cfg.PeerID}
+	msg.Reserved[5] |= ReservedExtension
+	if err := conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := NewHandshakeEncoder(conn).Encode(msg); err != nil {
+		return nil, err
+	}
+	decoded, err := NewHandshakeDecoder(conn).Decode()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(decoded.InfoHash, infoHash[:]) {
+		return nil, fmt.Errorf("%w, the peer answered with another info hash", ErrInvalidHandshakeFormat)
+	}
+	if !decoded.SupportsExtensions() {
+		return nil, fmt.Errorf("%w, the peer does not support the extension protocol", ErrInvalidMetadata)
+	}
+
+	if err := writeExtended(conn, 0, bencode.Dictionary{
+		"m": bencode.Dictionary{"ut_metadata": bencode.Integer(utMetadataID)},
+	}, nil); err != nil {
+		return nil, err
+	}
+
+	var (
+		metadata []byte
+		received []bool
+		missing  int
+	)
+	for {
+		if err := conn.SetDeadline(time.Now().Add(cfg.ReadTimeout)); err != nil {
+			return nil, err
+		}
+		command, err := NewCommandDecoder(conn).Decode()
+		if err != nil {
+			return nil, err
+		}
+		if command.MessageID != CommandExtended || len(command.Payload) == 0 {
+			continue // The bitfield, haves and the like.
+		}
+
+		dict, data, err := decodeExtended(command.Payload[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		switch command.Payload[0] {
+		case 0: // The extension handshake of the peer.
+			if metadata != nil {
+				continue // It may send another one, the first one counts.
+			}
+			m, _ := dict["m"].(bencode.Dictionary)
+			id, _ := m["ut_metadata"].(bencode.Integer)
+			size, _ := dict["metadata_size"].(bencode.Integer)
+			if id <= 0 || id > 255 {
+				return nil, fmt.Errorf("%w, the peer does not send metadata", ErrInvalidMetadata)
+			}
+			if size <= 0 || size > MaxMetadataSize {
+				return nil, fmt.Errorf("%w, the size %d", ErrInvalidMetadata, size)
+			}
+
+			metadata = make([]byte, size)
+			missing = (int(size) + MetadataPieceSize - 1) / MetadataPieceSize
+			received = make([]bool, missing)
+			for i := range missing {
+				if err := writeExtended(conn, byte(id), bencode.Dictionary{
+					"msg_type": bencode.Integer(metadataRequest),
+					"piece":    bencode.Integer(i),
+				}, nil); err != nil {
+					return nil, err
+				}
+			}
+		case utMetadataID:
+			msgType, _ := dict["msg_type"].(bencode.Integer)
+			piece, ok := dict["piece"].(bencode.Integer)
+			if !ok || piece < 0 || int(piece) >= len(received) {
+				return nil, fmt.Errorf("%w, the piece %d was not requested", ErrInvalidMetadata, piece)
+			}
+			switch msgType {
+			case metadataReject:
+				return nil, fmt.Errorf("%w, the peer rejected the piece %d", ErrInvalidMetadata, piece)
+			case metadataData:
+			default:
+				continue
+			}
+
+			start := int(piece) * MetadataPieceSize
+			end := min(start+MetadataPieceSize, len(metadata))
+			if len(data) != end-start {
+				return nil, fmt.Errorf("%w, the piece %d has %d bytes", ErrInvalidMetadata, piece, len(data))
+			}
+			copy(metadata[start:end], data)
+			if !received[piece] {
+				received[piece] = true
+				missing--
+			}
+			if missing > 0 {
+				continue
+			}
+
+			if sha1.Sum(metadata) != infoHash {
+				return nil, fmt.Errorf("%w, it does not match the info hash", ErrInvalidMetadata)
+			}
+			return metadata, nil
+		}
+	}
+}
+
+// writeExtended sends the bencoded message and the data after it to the extension with the ID.
+func writeExtended(conn net.Conn, id byte, msg bencode.Dictionary, data []byte) error {
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	payload := append(append([]byte{id}, encoded...), data...)
+	return NewCommandEncoder(conn).Encode(&Command{Length: 1 + uint32(len(payload)), MessageID: CommandExtended, Payload: payload})
+}
+
+// decodeExtended splits the payload of an extension message into its bencoded dictionary and the
+// data after it.
+func decodeExtended(payload []byte) (bencode.Dictionary, []byte, error) {
+	r := bytes.NewReader(payload)
+	dec := bencode.NewDecoder(r)
+	value, err := dec.Decode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w, %w", ErrInvalidMetadata, err)
+	}
+	dict, ok := value.(bencode.Dictionary)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w, the message is not a dictionary", ErrInvalidMetadata)
+	}
+	return dict, payload[len(payload)-r.Len()-dec.Buffered():], nil
+}
+
+// newMagnetTorrent returns the torrent of the info dictionary with the trackers of the magnet link,
+// every one of them in its own tier.
+func newMagnetTorrent(magnet *bencode.Magnet, info []byte) (*bencode.Torrent, error) {
+	value, err := bencode.NewDecoder(bytes.NewReader(info)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidMetadata, err)
+	}
+	infoDict, ok := value.(bencode.Dictionary)
+	if !ok {
+		return nil, fmt.Errorf("%w, the info is not a dictionary", ErrInvalidMetadata)
+	}
+
+	metainfo := bencode.Dictionary{"info": infoDict}
+	if len(magnet.Trackers) > 0 {
+		tiers := make(bencode.List, 0, len(magnet.Trackers))
+		for _, tracker := range magnet.Trackers {
+			tiers = append(tiers, bencode.List{bencode.String(tracker)})
+		}
+		metainfo["announce"] = bencode.String(magnet.Trackers[0])
+		metainfo["announce-list"] = tiers
+	}
+	encoded, err := metainfo.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	torrent, err := bencode.NewTorrent(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if torrent.File.InfoHashSum != magnet.InfoHash {
+		return nil, fmt.Errorf("%w, the info dictionary is not in the canonical encoding", ErrInvalidMetadata)
+	}
+	return torrent, nil
+}
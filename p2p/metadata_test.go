@@ -0,0 +1,215 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// newTestInfo returns an info dictionary that takes two metadata pieces.
+func newTestInfo(t *testing.T) []byte {
+	t.Helper()
+	const pieces = 1000
+	info, err := bencode.Dictionary{
+		"length":       bencode.Integer(pieces * 16),
+		"name":         bencode.String("file"),
+		"piece length": bencode.Integer(16),
+		"pieces":       bencode.String(randomBytes(t, pieces*sha1.Size)),
+	}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) <= MetadataPieceSize {
+		t.Fatalf("the info has %d bytes, want more than one metadata piece", len(info))
+	}
+	return info
+}
+
+// serveMetadata accepts one connection and answers the metadata requests on it, the reject peers
+// reject them and the others send the pieces of info.
+func serveMetadata(t *testing.T, info []byte, reject bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	infoHash := sha1.Sum(info)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := NewHandshakeDecoder(conn).Decode(); err != nil {
+			return
+		}
+		msg := &HandshakeMessage{InfoHash: infoHash[:], PeerID: []byte("-TT0001-000000000000")}
+		msg.Reserved[5] |= ReservedExtension
+		if err := NewHandshakeEncoder(conn).Encode(msg); err != nil {
+			return
+		}
+
+		const id = 3 // Our ID for ut_metadata, not the one of the client.
+		if err := writeExtended(conn, 0, bencode.Dictionary{
+			"m":             bencode.Dictionary{"ut_metadata": bencode.Integer(id)},
+			"metadata_size": bencode.Integer(len(info)),
+		}, nil); err != nil {
+			return
+		}
+
+		var theirs bencode.Integer
+		for {
+			command, err := NewCommandDecoder(conn).Decode()
+			if err != nil {
+				return
+			}
+			dict, _, err := decodeExtended(command.Payload[1:])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if command.Payload[0] == 0 {
+				m, _ := dict["m"].(bencode.Dictionary)
+				theirs, _ = m["ut_metadata"].(bencode.Integer)
+				continue
+			}
+			if command.Payload[0] != id {
+				t.Errorf("got a message for the extension %d, want %d", command.Payload[0], id)
+				return
+			}
+
+			piece := dict["piece"].(bencode.Integer)
+			if reject {
+				writeExtended(conn, byte(theirs), bencode.Dictionary{
+					"msg_type": bencode.Integer(metadataReject),
+					"piece":    piece,
+				}, nil)
+				continue
+			}
+			start := int(piece) * MetadataPieceSize
+			end := min(start+MetadataPieceSize, len(info))
+			writeExtended(conn, byte(theirs), bencode.Dictionary{
+				"msg_type":   bencode.Integer(metadataData),
+				"piece":      piece,
+				"total_size": bencode.Integer(len(info)),
+			}, info[start:end])
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// newPeersTracker returns a tracker that answers with the peers.
+func newPeersTracker(t *testing.T, addrs ...string) *httptest.Server {
+	t.Helper()
+	var peers []byte
+	for _, addr := range addrs {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, tcp.IP.To4()...)
+		peers = append(peers, byte(tcp.Port>>8), byte(tcp.Port))
+	}
+	response, err := bencode.Dictionary{
+		"interval": bencode.Integer(1800),
+		"peers":    bencode.String(peers),
+	}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchMetadata(t *testing.T) {
+	info := newTestInfo(t)
+	other := newTestInfo(t)
+
+	tests := []struct {
+		name  string
+		peers func(t *testing.T) []string
+		err   error
+	}{
+		{
+			name: "One peer",
+			peers: func(t *testing.T) []string {
+				return []string{serveMetadata(t, info, false)}
+			},
+		},
+		{
+			name: "Rejecting peer first",
+			peers: func(t *testing.T) []string {
+				return []string{serveMetadata(t, info, true), serveMetadata(t, info, false)}
+			},
+		},
+		{
+			name: "Wrong metadata",
+			peers: func(t *testing.T) []string {
+				return []string{serveMetadata(t, other, false)}
+			},
+			err: ErrNoMetadata,
+		},
+		{
+			name:  "No peers",
+			peers: func(t *testing.T) []string { return nil },
+			err:   ErrNoMetadata,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newPeersTracker(t, tt.peers(t)...)
+			magnet := &bencode.Magnet{InfoHash: sha1.Sum(info), Trackers: []string{tracker.URL}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			torrent, err := FetchMetadata(ctx, slog.Default(), magnet)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("FetchMetadata() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if torrent.File.InfoHashSum != magnet.InfoHash {
+				t.Errorf("the info hash is %x, want %x", torrent.File.InfoHashSum, magnet.InfoHash)
+			}
+			if torrent.File.Announce != bencode.String(tracker.URL) {
+				t.Errorf("the announce is %q, want %q", torrent.File.Announce, tracker.URL)
+			}
+			if torrent.File.Info.Name != "file" || torrent.File.Info.Length != 16000 {
+				t.Errorf("the file is %q with %d bytes, want \"file\" with 16000", torrent.File.Info.Name, torrent.File.Info.Length)
+			}
+		})
+	}
+}
+
+func TestDecodeExtended(t *testing.T) {
+	payload := []byte("d8:msg_typei1e5:piecei0ee" + "d4:datae")
+	dict, data, err := decodeExtended(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict["msg_type"] != bencode.Integer(metadataData) {
+		t.Errorf("msg_type = %v, want %d", dict["msg_type"], metadataData)
+	}
+	if !bytes.Equal(data, []byte("d4:datae")) {
+		t.Errorf("data = %q, want %q", data, "d4:datae")
+	}
+}
@@ -0,0 +1,175 @@
+package p2p
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PeerClient is the client software of a peer, as far as it can be told from its peer ID.
+type PeerClient struct {
+	Name    string `json:"name"` // "unknown" if the peer ID is not recognized, "unknown (XX 1.2.3)" for unknown Azureus-style codes
+	Version string `json:"version,omitempty"`
+}
+
+func (pc PeerClient) String() string {
+	if pc.Version == "" {
+		return pc.Name
+	}
+	return pc.Name + " " + pc.Version
+}
+
+// azureusClients are the client codes of Azureus-style peer IDs, "-XX1234-" followed by random bytes.
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"GB": "gobittorrent",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "libTorrent",
+	"qB": "qBittorrent",
+	"TR": "Transmission",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// azureusReleases are the clients whose last version character is the release type, not a digit,
+// "-UT355W-" is 3.5.5.
+var azureusReleases = map[string]bool{
+	"BT": true,
+	"UM": true,
+	"UT": true,
+	"UW": true,
+}
+
+// shadowClients are the client codes of Shadow-style peer IDs, "X123---" followed by random bytes.
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+// ParsePeerID recognizes the Azureus-style, Shadow-style and Mainline peer IDs.
+func ParsePeerID(id []byte) PeerClient {
+	if len(id) != 20 {
+		return PeerClient{Name: "unknown"}
+	}
+	if pc, ok := parseAzureus(id); ok {
+		return pc
+	}
+	if pc, ok := parseMainline(id); ok {
+		return pc
+	}
+	if pc, ok := parseShadow(id); ok {
+		return pc
+	}
+	return PeerClient{Name: "unknown"}
+}
+
+func parseAzureus(id []byte) (PeerClient, bool) {
+	if id[0] != '-' || id[7] != '-' {
+		return PeerClient{}, false
+	}
+	for _, b := range id[1:7] {
+		if !isAlphanumeric(b) {
+			return PeerClient{}, false
+		}
+	}
+
+	code, v := string(id[1:3]), string(id[3:7])
+	name, ok := azureusClients[code]
+
+	// Transmission uses two digits for the minor version, "2940" is 2.94.
+	if ok && code == "TR" {
+		return PeerClient{Name: name, Version: v[:1] + "." + v[1:3]}, true
+	}
+	if azureusReleases[code] {
+		v = v[:3]
+	}
+
+	parts := make([]string, 0, len(v))
+	for i := range len(v) {
+		parts = append(parts, strconv.Itoa(versionDigit(v[i])))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	version := strings.Join(parts, ".")
+	if !ok {
+		return PeerClient{Name: "unknown (" + code + " " + version + ")"}, true
+	}
+	return PeerClient{Name: name, Version: version}, true
+}
+
+// parseMainline parses peer IDs like "M4-4-0--", the numbers can have more than one digit.
+func parseMainline(id []byte) (PeerClient, bool) {
+	if id[0] != 'M' {
+		return PeerClient{}, false
+	}
+
+	fields := strings.SplitN(string(id[1:]), "-", 4)
+	if len(fields) != 4 {
+		return PeerClient{}, false
+	}
+	for _, f := range fields[:3] {
+		if _, err := strconv.Atoi(f); err != nil {
+			return PeerClient{}, false
+		}
+	}
+	return PeerClient{Name: "Mainline", Version: strings.Join(fields[:3], ".")}, true
+}
+
+// parseShadow parses peer IDs like "S58B-----", each version character is a number up to 63.
+func parseShadow(id []byte) (PeerClient, bool) {
+	name, ok := shadowClients[id[0]]
+	if !ok {
+		return PeerClient{}, false
+	}
+
+	end := 1
+	for end < 6 && id[end] != '-' {
+		if !isAlphanumeric(id[end]) && id[end] != '.' {
+			return PeerClient{}, false
+		}
+		end++
+	}
+	if end == 1 || string(id[end:end+3]) != "---" {
+		return PeerClient{}, false
+	}
+
+	parts := make([]string, 0, end-1)
+	for _, b := range id[1:end] {
+		parts = append(parts, strconv.Itoa(versionDigit(b)))
+	}
+	return PeerClient{Name: name, Version: strings.Join(parts, ".")}, true
+}
+
+// versionDigit decodes a version character, 0-9, then A-Z for 10-35, a-z for 36-61 and '.' for 62.
+func versionDigit(b byte) int {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b - '0')
+	case b >= 'A' && b <= 'Z':
+		return int(b-'A') + 10
+	case b >= 'a' && b <= 'z':
+		return int(b-'a') + 36
+	case b == '.':
+		return 62
+	}
+	return 63
+}
+
+func isAlphanumeric(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z'
+}
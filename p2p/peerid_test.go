@@ -0,0 +1,35 @@
+package p2p
+
+import "testing"
+
+func TestParsePeerID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want PeerClient
+	}{
+		{"-qB4520-abcdefghijkl", PeerClient{"qBittorrent", "4.5.2"}},
+		{"-TR2940-abcdefghijkl", PeerClient{"Transmission", "2.94"}},
+		{"-UT355W-abcdefghijkl", PeerClient{"µTorrent", "3.5.5"}},
+		{"-UT2210-abcdefghijkl", PeerClient{"µTorrent", "2.2.1"}},
+		{"-GB0100-abcdefghijkl", PeerClient{"gobittorrent", "0.1"}},
+		{"-ZZ1000-abcdefghijkl", PeerClient{"unknown (ZZ 1.0)", ""}},
+		{"-XX1230-abcdefghijkl", PeerClient{"unknown (XX 1.2.3)", ""}},
+		{"M4-4-0--abcdefghijkl", PeerClient{"Mainline", "4.4.0"}},
+		{"M7-10-3-abcdefghijkl", PeerClient{"Mainline", "7.10.3"}},
+		{"S58B-----abcdefghijk", PeerClient{"Shadow", "5.8.11"}},
+		{"T03I---abcdefghijklm", PeerClient{"BitTornado", "0.3.18"}},
+		{"00112233445566778899", PeerClient{"unknown", ""}},
+		{"-qB4520-", PeerClient{"unknown", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if got := ParsePeerID([]byte(tt.id)); got != tt.want {
+				t.Errorf("ParsePeerID(%q) = %+v, want %+v", tt.id, got, tt.want)
+			}
+		})
+	}
+
+	if got := ParsePeerID(GeneratePeerID(DefaultPeerIDPrefix)); got.Name != "gobittorrent" {
+		t.Errorf("ParsePeerID() of a generated peer ID = %+v, want gobittorrent", got)
+	}
+}
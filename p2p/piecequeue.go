@@ -0,0 +1,149 @@
+package p2p
+
+import "sync"
+
+// pieceQueue hands out the pieces to download, the pieces of the most important files first and
+// then by index. The client sets the priorities of the pieces when the priorities of the files,
+// the readers or the sequential window change, so a pop only looks them up.
+type pieceQueue struct {
+	mu         sync.Mutex
+	pieces     []*Piece
+	priorities []FilePriority // by the index of the piece, the skipped pieces are held back
+	streaming  []int          // the readers that need the piece next, by the index of the piece
+	limit      int            // the pieces from it on are held back, unless a reader needs them
+	ready      chan struct{}  // closed and replaced when pieces are pushed or become available
+}
+
+func newPieceQueue(pieceCount int) *pieceQueue {
+	return &pieceQueue{
+		priorities: make([]FilePriority, pieceCount),
+		streaming:  make([]int, pieceCount),
+		limit:      pieceCount,
+		ready:      make(chan struct{}),
+	}
+}
+
+func (q *pieceQueue) push(pieces ...*Piece) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pieces = append(q.pieces, pieces...)
+	q.wakeLocked()
+}
+
+// setPriorities replaces the priorities of the pieces and the limit, the queued pieces are handed
+// out by them from now on.
+func (q *pieceQueue) setPriorities(priorities []FilePriority, limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.priorities, q.limit = priorities, limit
+	q.wakeLocked()
+}
+
+// setLimit holds back the pieces from limit on, the ones before it become available.
+func (q *pieceQueue) setLimit(limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.limit != limit {
+		q.limit = limit
+		q.wakeLocked()
+	}
+}
+
+// stream moves a reader from the pieces it needed to the ones it needs now, an empty range has
+// last < first.
+func (q *pieceQueue) stream(from, to pieceRange) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := max(from.first, 0); i <= min(from.last, len(q.streaming)-1); i++ {
+		q.streaming[i]--
+	}
+	for i := max(to.first, 0); i <= min(to.last, len(q.streaming)-1); i++ {
+		q.streaming[i]++
+	}
+	q.wakeLocked() // The pieces of skipped files may be needed now.
+}
+
+// priority returns the priority a piece is handed out with.
+func (q *pieceQueue) priority(index uint32) FilePriority {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.priorityLocked(index)
+}
+
+func (q *pieceQueue) priorityLocked(index uint32) FilePriority {
+	if q.streaming[index] > 0 {
+		return priorityStreaming
+	}
+	return q.priorities[index]
+}
+
+// availableLocked reports whether the piece can be downloaded now. The pieces of skipped files are
+// not, unless a reader needs them, and neither are the ones past the limit of a sequential download.
+func (q *pieceQueue) availableLocked(index uint32) bool {
+	if q.streaming[index] > 0 {
+		return true
+	}
+	return q.priorities[index] != PrioritySkip && int(index) < q.limit
+}
+
+// wake makes the blocked pops look at the queue again.
+func (q *pieceQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.wakeLocked()
+}
+
+func (q *pieceQueue) wakeLocked() {
+	close(q.ready)
+	q.ready = make(chan struct{})
+}
+
+// pop waits for a piece until quitch is closed.
+func (q *pieceQueue) pop(quitch <-chan struct{}) (*Piece, bool) {
+	for {
+		q.mu.Lock()
+		if piece := q.takeLocked(); piece != nil {
+			q.mu.Unlock()
+			return piece, true
+		}
+		ready := q.ready
+		q.mu.Unlock()
+
+		select {
+		case <-quitch:
+			return nil, false
+		case <-ready:
+		}
+	}
+}
+
+// takeLocked removes the best available piece, nil if there is none.
+func (q *pieceQueue) takeLocked() *Piece {
+	best, bestPriority := -1, FilePriority(0)
+	for i, p := range q.pieces {
+		if !q.availableLocked(p.Index) {
+			continue
+		}
+		priority := q.priorityLocked(p.Index)
+		if best < 0 || priority > bestPriority || priority == bestPriority && p.Index < q.pieces[best].Index {
+			best, bestPriority = i, priority
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	piece := q.pieces[best]
+	q.pieces = append(q.pieces[:best], q.pieces[best+1:]...)
+	return piece
+}
+
+func (q *pieceQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pieces)
+}
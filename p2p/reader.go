@@ -0,0 +1,200 @@
+package p2p
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// pieceRange is the pieces from first to last, including both.
+type pieceRange struct {
+	first, last int
+}
+
+// Reader reads a file of the torrent while it is downloaded. A read blocks until the piece under
+// the position is verified, and the pieces from the position to the end of the readahead are
+// downloaded before any other, so a seek moves the download along with it.
+type Reader struct {
+	c           *Client
+	file        fileSpan
+	pieceLength int64
+
+	mu        sync.Mutex
+	pos       int64
+	readahead int64
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ io.ReadSeekCloser = (*Reader)(nil)
+
+// NewReader returns a reader of the file at the index of Files with the readahead of the config.
+// The missing pieces are queued if Download did not do it yet. Close the reader when done, its
+// pieces keep their priority until then.
+func (c *Client) NewReader(file int) (*Reader, error) {
+	if file < 0 || file >= len(c.files) {
+		return nil, fmt.Errorf("%w, file %d of %d", ErrFileNotFound, file, len(c.files))
+	}
+
+	r := &Reader{
+		c:           c,
+		file:        c.files[file],
+		pieceLength: int64(c.t.File.Info.PieceLength),
+		readahead:   c.cfg.Readahead,
+		closed:      make(chan struct{}),
+	}
+	r.prioritizeLocked()
+	c.queuePieces()
+	return r, nil
+}
+
+// Size returns the length of the file.
+func (r *Reader) Size() int64 {
+	return r.file.length
+}
+
+// SetReadahead changes how many bytes after the position are downloaded first, at least the piece
+// under the position is.
+func (r *Reader) SetReadahead(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readahead = n
+	r.prioritizeLocked()
+}
+
+// Read reads from the position, it blocks until the piece under it is verified, the reader or the
+// client is closed.
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.closed:
+		return 0, ErrReaderClosed
+	default:
+	}
+	if r.pos >= r.file.length {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	offset := r.file.offset + r.pos
+	index := int(offset / r.pieceLength)
+	if err := r.c.waitPiece(index, r.closed, func() error { return ErrReaderClosed }); err != nil {
+		return 0, err
+	}
+	data, err := r.c.storage.ReadPiece(uint32(index))
+	if err != nil {
+		return 0, err
+	}
+
+	start := offset - int64(index)*r.pieceLength
+	end := min(int64(len(data)), start+r.file.length-r.pos)
+	if start >= end {
+		return 0, fmt.Errorf("%w, piece %d has %d bytes, want more than %d", io.ErrUnexpectedEOF, index, len(data), start)
+	}
+	n := copy(p, data[start:end])
+	r.pos += int64(n)
+	r.prioritizeLocked()
+	return n, nil
+}
+
+// Seek moves the position, a position after the end of the file is allowed and reads io.EOF.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += r.pos
+	case io.SeekEnd:
+		pos += r.file.length
+	default:
+		return 0, fmt.Errorf("%w, whence %d", ErrInvalidSeek, whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("%w, negative position %d", ErrInvalidSeek, pos)
+	}
+
+	r.pos = pos
+	r.prioritizeLocked()
+	return pos, nil
+}
+
+// Close stops the reads and gives the pieces of the reader their normal priority back.
+func (r *Reader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.c.haveMu.Lock()
+		r.c.setStreamLocked(r, pieceRange{first: 0, last: -1})
+		r.c.haveMu.Unlock()
+	})
+	return nil
+}
+
+// prioritizeLocked marks the pieces from the position to the end of the readahead as the ones the
+// reader needs next.
+func (r *Reader) prioritizeLocked() {
+	r.c.haveMu.Lock()
+	defer r.c.haveMu.Unlock()
+
+	select {
+	case <-r.closed:
+		return
+	default:
+	}
+	if r.pos >= r.file.length {
+		r.c.setStreamLocked(r, pieceRange{first: 0, last: -1})
+		return
+	}
+	end := min(r.pos+max(r.readahead, 1), r.file.length)
+	r.c.setStreamLocked(r, pieceRange{
+		first: int((r.file.offset + r.pos) / r.pieceLength),
+		last:  int((r.file.offset + end - 1) / r.pieceLength),
+	})
+}
+
+// setStreamLocked records the pieces the reader needs next and moves it to them in the queue, the
+// reader is forgotten when the range is empty.
+func (c *Client) setStreamLocked(r *Reader, pieces pieceRange) {
+	old, ok := c.streams[r]
+	if !ok {
+		old = pieceRange{first: 0, last: -1}
+	}
+	if pieces.last < pieces.first {
+		delete(c.streams, r)
+	} else {
+		c.streams[r] = pieces
+	}
+	c.pieceQueue.stream(old, pieces)
+}
+
+// waitPiece blocks until the piece is verified, the client is closed or done is closed, then it
+// returns the error of doneErr. It returns ErrPieceSkipped for a piece that is not downloaded
+// because its files are skipped.
+func (c *Client) waitPiece(index int, done <-chan struct{}, doneErr func() error) error {
+	for {
+		c.haveMu.RLock()
+		ok, verified := c.have[index], c.haveCh
+		skipped := !ok && !c.wanted[index] && !c.streamingLocked(uint32(index))
+		c.haveMu.RUnlock()
+		if ok {
+			return nil
+		}
+		if skipped {
+			return fmt.Errorf("%w, piece %d", ErrPieceSkipped, index)
+		}
+
+		select {
+		case <-verified:
+		case <-c.closedch:
+			return ErrClientClosed
+		case <-done:
+			return doneErr()
+		}
+	}
+}
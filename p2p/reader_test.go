@@ -0,0 +1,146 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	files := map[string][]byte{
+		"a.txt":     randomBytes(t, 10),
+		"dir/b.bin": randomBytes(t, 50000),
+		"dir/c.bin": randomBytes(t, 20000),
+	}
+	order := []string{"a.txt", "dir/b.bin", "dir/c.bin"}
+
+	dir := t.TempDir()
+	writeTestFiles(t, filepath.Join(dir, "root"), files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "root", 16384, files, order, srv.URL+"/")
+	client, err := NewClient(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, WithReadahead(16384))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.NewReader(3); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("NewReader(3) error = %v, want %v", err, ErrFileNotFound)
+	}
+
+	r, err := client.NewReader(1)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, files["dir/b.bin"]) {
+		t.Errorf("ReadAll() read %d bytes that differ from the file", len(got))
+	}
+
+	tests := []struct {
+		name    string
+		offset  int64
+		whence  int
+		want    int64
+		wantErr error
+	}{
+		{name: "Start", offset: 20000, whence: io.SeekStart, want: 20000},
+		{name: "Current", offset: -5100, whence: io.SeekCurrent, want: 15000}, // after reading 100 bytes
+		{name: "End", offset: -10, whence: io.SeekEnd, want: 49990},
+		{name: "Before the start", offset: -1, whence: io.SeekStart, wantErr: ErrInvalidSeek},
+		{name: "Unknown whence", whence: 7, wantErr: ErrInvalidSeek},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := r.Seek(tt.offset, tt.whence)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Seek() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 100)
+			n, err := io.ReadFull(r, buf)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if pos != tt.want || !bytes.Equal(buf[:n], files["dir/b.bin"][tt.want:min(tt.want+100, 50000)]) {
+				t.Errorf("Seek() = %d, read %d bytes that differ from the file at %d", pos, n, tt.want)
+			}
+		})
+	}
+
+	r.Close()
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrReaderClosed) {
+		t.Errorf("Read() after Close() error = %v, want %v", err, ErrReaderClosed)
+	}
+}
+
+func TestReaderPriority(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-1 and b.txt in 2-9.
+	files := map[string][]byte{"a.txt": randomBytes(t, 20), "b.txt": randomBytes(t, 80)}
+	torrent, _ := newTestTorrent(t, "http://localhost/announce", "dir", 10, files, []string{"a.txt", "b.txt"})
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig(WithReadahead(25)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.NewReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(35, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader is at byte 55 of the torrent, piece 5, and needs up to byte 79, piece 7. The rest
+	// of the pieces come in order.
+	quitch := make(chan struct{})
+	var got []uint32
+	for range 5 {
+		piece, _ := c.pieceQueue.pop(quitch)
+		got = append(got, piece.Index)
+	}
+	if want := []uint32{5, 6, 7, 0, 1}; !slices.Equal(got, want) {
+		t.Errorf("the queue returned the pieces %v, want %v", got, want)
+	}
+
+	r.SetReadahead(0)
+	if c.pieceQueue.priority(5) != priorityStreaming || c.pieceQueue.priority(6) != PriorityNormal {
+		t.Errorf("with no readahead the priorities of pieces 5 and 6 are %v and %v", c.pieceQueue.priority(5), c.pieceQueue.priority(6))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Read() of a missing piece returned %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	r.Close()
+	if err := <-done; !errors.Is(err, ErrReaderClosed) {
+		t.Errorf("blocked Read() error = %v after Close(), want %v", err, ErrReaderClosed)
+	}
+	if c.pieceQueue.priority(5) != PriorityNormal {
+		t.Errorf("the priority of piece 5 is %v after Close(), want %v", c.pieceQueue.priority(5), PriorityNormal)
+	}
+}
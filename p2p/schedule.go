@@ -0,0 +1,231 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/ratelimit"
+)
+
+// BandwidthProfile is the set of session limits that a schedule rule applies.
+type BandwidthProfile struct {
+	DownloadLimit int  `json:"download_limit" toml:"download_limit"` // bytes per second, 0 means no limit
+	UploadLimit   int  `json:"upload_limit" toml:"upload_limit"`
+	Pause         bool `json:"pause" toml:"pause"` // pause every torrent for the duration of the window
+}
+
+// Schedule picks the bandwidth profile by the time of the week, the first matching rule wins
+// and the default applies when no rule matches. An unset default keeps the configured limits.
+type Schedule struct {
+	Default BandwidthProfile `json:"default" toml:"default"`
+	Rules   []ScheduleRule   `json:"rules" toml:"rules"`
+}
+
+// ScheduleRule is a daily time window. A window that ends before it starts goes past midnight
+// and belongs to the day it starts on.
+type ScheduleRule struct {
+	Days  []Weekday `json:"days" toml:"days"` // empty means every day
+	Start TimeOfDay `json:"start" toml:"start"`
+	End   TimeOfDay `json:"end" toml:"end"`
+	BandwidthProfile
+}
+
+// Weekday is a time.Weekday written as its name, "mon" or "monday".
+type Weekday time.Weekday
+
+func (d *Weekday) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			*d = Weekday(day)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown weekday %q", ErrInvalidSchedule, text)
+}
+
+func (d Weekday) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(time.Weekday(d).String()[:3])), nil
+}
+
+// TimeOfDay is the time since midnight, written as "15:04".
+type TimeOfDay time.Duration
+
+func (t *TimeOfDay) UnmarshalText(text []byte) error {
+	parsed, err := time.Parse("15:04", string(text))
+	if err != nil {
+		if string(text) != "24:00" {
+			return fmt.Errorf("%w: time of day %q is not HH:MM", ErrInvalidSchedule, text)
+		}
+		*t = TimeOfDay(24 * time.Hour)
+		return nil
+	}
+	*t = TimeOfDay(time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute)
+	return nil
+}
+
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	d := time.Duration(t)
+	return []byte(fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)), nil
+}
+
+// Contains reports whether the rule's window covers the moment.
+func (r *ScheduleRule) Contains(now time.Time) bool {
+	var (
+		day   = now.Weekday()
+		since = time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+		start = time.Duration(r.Start)
+		end   = time.Duration(r.End)
+	)
+	if start <= end {
+		return r.onDay(day) && since >= start && since < end
+	}
+	// The window goes past midnight, so the early morning belongs to the day before.
+	return (r.onDay(day) && since >= start) || (r.onDay((day+6)%7) && since < end)
+}
+
+func (r *ScheduleRule) onDay(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Profile returns the profile that applies at the moment.
+func (s *Schedule) Profile(now time.Time) BandwidthProfile {
+	profile, _ := s.profile(now)
+	return profile
+}
+
+// profile returns the profile that applies at the moment, false when no rule matches and the
+// default is unset, then the configured limits apply.
+func (s *Schedule) profile(now time.Time) (BandwidthProfile, bool) {
+	for i := range s.Rules {
+		if s.Rules[i].Contains(now) {
+			return s.Rules[i].BandwidthProfile, true
+		}
+	}
+	return s.Default, s.Default != BandwidthProfile{}
+}
+
+// IsZero reports whether the schedule sets nothing, neither limits nor rules.
+func (s *Schedule) IsZero() bool {
+	return len(s.Rules) == 0 && s.Default == BandwidthProfile{}
+}
+
+// SchedulerInterval is how often the scheduler checks which profile applies.
+const SchedulerInterval = time.Minute
+
+// ScheduleTarget is what a scheduler applies the profiles to, a *Session or a single *Client.
+type ScheduleTarget interface {
+	SetDownloadLimit(rate int)
+	SetUploadLimit(rate int)
+	DownloadLimit() int
+	UploadLimit() int
+	scheduled() (torrents []*Client, log *slog.Logger)
+}
+
+func (s *Session) scheduled() ([]*Client, *slog.Logger) { return s.Torrents(), s.log }
+
+func (c *Client) scheduled() ([]*Client, *slog.Logger) { return []*Client{c}, c.log }
+
+// Scheduler applies the profiles of a schedule to a running session or client.
+type Scheduler struct {
+	target   ScheduleTarget
+	schedule Schedule
+	clock    ratelimit.Clock
+
+	mu      sync.Mutex
+	current *BandwidthProfile // nil while the configured limits apply
+	limits  BandwidthProfile  // the configured limits, saved when a profile replaces them
+	paused  map[*Client]bool  // the torrents the scheduler paused, the ones paused by the user stay paused
+}
+
+func NewScheduler(target ScheduleTarget, schedule Schedule) *Scheduler {
+	return NewSchedulerWithClock(target, schedule, ratelimit.SystemClock)
+}
+
+func NewSchedulerWithClock(target ScheduleTarget, schedule Schedule, clock ratelimit.Clock) *Scheduler {
+	return &Scheduler{
+		target:   target,
+		schedule: schedule,
+		clock:    clock,
+		paused:   make(map[*Client]bool),
+	}
+}
+
+// Run applies the schedule until the context is done.
+func (sc *Scheduler) Run(ctx context.Context) error {
+	for {
+		sc.Apply(sc.clock.Now())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sc.clock.After(SchedulerInterval):
+		}
+	}
+}
+
+// SetSchedule replaces the schedule, it takes effect on the next Apply.
+func (sc *Scheduler) SetSchedule(schedule Schedule) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.schedule = schedule
+}
+
+// Apply sets the limits of the profile that applies at the moment and pauses or resumes the torrents,
+// the configured limits come back when no profile applies. It returns the applied limits.
+func (sc *Scheduler) Apply(now time.Time) BandwidthProfile {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	torrents, log := sc.target.scheduled()
+	profile, scheduled := sc.schedule.profile(now)
+	switch {
+	case !scheduled:
+		if sc.current != nil {
+			log.Info("Restoring the configured limits", "download_limit", sc.limits.DownloadLimit, "upload_limit", sc.limits.UploadLimit)
+			sc.target.SetDownloadLimit(sc.limits.DownloadLimit)
+			sc.target.SetUploadLimit(sc.limits.UploadLimit)
+			sc.current = nil
+		}
+		profile = BandwidthProfile{DownloadLimit: sc.target.DownloadLimit(), UploadLimit: sc.target.UploadLimit()}
+	case sc.current == nil || *sc.current != profile:
+		if sc.current == nil {
+			sc.limits = BandwidthProfile{DownloadLimit: sc.target.DownloadLimit(), UploadLimit: sc.target.UploadLimit()}
+		}
+		log.Info("Applying the bandwidth profile", "download_limit", profile.DownloadLimit, "upload_limit", profile.UploadLimit, "pause", profile.Pause)
+		sc.target.SetDownloadLimit(profile.DownloadLimit)
+		sc.target.SetUploadLimit(profile.UploadLimit)
+		sc.current = &profile
+	}
+
+	if profile.Pause {
+		// Torrents added during the window are paused as well.
+		for _, c := range torrents {
+			if !c.Paused() {
+				c.Pause()
+				sc.paused[c] = true
+			}
+		}
+		return profile
+	}
+
+	for c := range sc.paused {
+		c.Resume()
+	}
+	clear(sc.paused)
+
+	return profile
+}
@@ -0,0 +1,181 @@
+package p2p
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// 2024-01-01 is a Monday.
+func monday(hour, minute int) time.Time {
+	return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleRuleContains(t *testing.T) {
+	workHours := ScheduleRule{
+		Days:  []Weekday{Weekday(time.Monday), Weekday(time.Tuesday)},
+		Start: TimeOfDay(9 * time.Hour),
+		End:   TimeOfDay(18 * time.Hour),
+	}
+	overnight := ScheduleRule{
+		Days:  []Weekday{Weekday(time.Monday)},
+		Start: TimeOfDay(22 * time.Hour),
+		End:   TimeOfDay(6 * time.Hour),
+	}
+	everyDay := ScheduleRule{Start: TimeOfDay(0), End: TimeOfDay(24 * time.Hour)}
+
+	tests := []struct {
+		name string
+		rule ScheduleRule
+		now  time.Time
+		want bool
+	}{
+		{name: "Inside", rule: workHours, now: monday(12, 0), want: true},
+		{name: "At the start", rule: workHours, now: monday(9, 0), want: true},
+		{name: "At the end", rule: workHours, now: monday(18, 0), want: false},
+		{name: "Before", rule: workHours, now: monday(8, 59), want: false},
+		{name: "Other day", rule: workHours, now: monday(12, 0).AddDate(0, 0, 2), want: false},
+		{name: "Overnight, evening", rule: overnight, now: monday(23, 0), want: true},
+		{name: "Overnight, next morning", rule: overnight, now: monday(5, 0).AddDate(0, 0, 1), want: true},
+		{name: "Overnight, morning of the same day", rule: overnight, now: monday(5, 0), want: false},
+		{name: "Every day", rule: everyDay, now: monday(23, 59).AddDate(0, 0, 5), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Contains(tt.now); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerApply(t *testing.T) {
+	tracker := newTestTracker(t)
+	torrentA, _ := newTestTorrent(t, tracker.URL, "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"})
+	torrentB, _ := newTestTorrent(t, tracker.URL, "b.bin", 16384, map[string][]byte{"b.bin": randomBytes(t, 1000)}, []string{"b.bin"})
+
+	s, err := NewSession(slog.Default(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a, err := s.Add(torrentA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Add(torrentB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Pause() // Paused by the user, the scheduler must not resume it.
+
+	sc := NewScheduler(s, Schedule{
+		Default: BandwidthProfile{UploadLimit: 1000},
+		Rules: []ScheduleRule{
+			{Start: TimeOfDay(9 * time.Hour), End: TimeOfDay(18 * time.Hour), BandwidthProfile: BandwidthProfile{DownloadLimit: 100000, UploadLimit: 10000}},
+			{Start: TimeOfDay(2 * time.Hour), End: TimeOfDay(4 * time.Hour), BandwidthProfile: BandwidthProfile{Pause: true}},
+		},
+	})
+
+	steps := []struct {
+		now          time.Time
+		wantDownload int
+		wantUpload   int
+		wantAPaused  bool
+	}{
+		{now: monday(12, 0), wantDownload: 100000, wantUpload: 10000},
+		{now: monday(20, 0), wantDownload: 0, wantUpload: 1000},
+		{now: monday(3, 0).AddDate(0, 0, 1), wantAPaused: true},
+		{now: monday(4, 0).AddDate(0, 0, 1), wantDownload: 0, wantUpload: 1000},
+	}
+
+	for _, step := range steps {
+		sc.Apply(step.now)
+		if s.DownloadLimit() != step.wantDownload || s.UploadLimit() != step.wantUpload {
+			t.Errorf("at %v limits = %d, %d, want %d, %d", step.now, s.DownloadLimit(), s.UploadLimit(), step.wantDownload, step.wantUpload)
+		}
+		if a.Paused() != step.wantAPaused {
+			t.Errorf("at %v Paused() = %v, want %v", step.now, a.Paused(), step.wantAPaused)
+		}
+		if !b.Paused() {
+			t.Errorf("at %v the torrent paused by the user was resumed", step.now)
+		}
+	}
+}
+
+func TestSchedulerApplyClient(t *testing.T) {
+	tracker := newTestTracker(t)
+	// The tracker has no peers, the web seed is never asked for data.
+	torrent, _ := newTestTorrent(t, tracker.URL, "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"}, tracker.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.Default(), torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	schedule := Schedule{Default: BandwidthProfile{DownloadLimit: 5000, UploadLimit: 1000}}
+	if schedule.IsZero() {
+		t.Fatal("IsZero() = true for a schedule with default limits only")
+	}
+	sc := NewScheduler(client, schedule)
+
+	sc.Apply(monday(12, 0))
+	if client.DownloadLimit() != 5000 || client.UploadLimit() != 1000 || client.Paused() {
+		t.Errorf("limits = %d, %d, paused %v, want 5000, 1000 and running", client.DownloadLimit(), client.UploadLimit(), client.Paused())
+	}
+
+	sc.SetSchedule(Schedule{Rules: []ScheduleRule{{Start: TimeOfDay(2 * time.Hour), End: TimeOfDay(4 * time.Hour), BandwidthProfile: BandwidthProfile{Pause: true}}}})
+	if sc.Apply(monday(3, 0)); !client.Paused() {
+		t.Error("the client was not paused in the window")
+	}
+	if sc.Apply(monday(5, 0)); client.Paused() || client.DownloadLimit() != 0 {
+		t.Errorf("after the window paused %v, download limit %d, want running without a limit", client.Paused(), client.DownloadLimit())
+	}
+}
+
+func TestScheduleIsZero(t *testing.T) {
+	if s := (Schedule{}); !s.IsZero() {
+		t.Error("IsZero() = false for an empty schedule")
+	}
+	if s := (Schedule{Rules: []ScheduleRule{{}}}); s.IsZero() {
+		t.Error("IsZero() = true for a schedule with a rule")
+	}
+}
+
+func TestSchedulerKeepsConfiguredLimits(t *testing.T) {
+	s, err := NewSession(slog.Default(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetDownloadLimit(5000)
+	s.SetUploadLimit(1000)
+
+	// No default, the configured limits apply outside of the rule.
+	sc := NewScheduler(s, Schedule{Rules: []ScheduleRule{
+		{Start: TimeOfDay(9 * time.Hour), End: TimeOfDay(18 * time.Hour), BandwidthProfile: BandwidthProfile{DownloadLimit: 100}},
+	}})
+
+	steps := []struct {
+		now          time.Time
+		wantDownload int
+		wantUpload   int
+	}{
+		{now: monday(8, 0), wantDownload: 5000, wantUpload: 1000},
+		{now: monday(12, 0), wantDownload: 100, wantUpload: 0},
+		{now: monday(20, 0), wantDownload: 5000, wantUpload: 1000},
+	}
+	for _, step := range steps {
+		got := sc.Apply(step.now)
+		if s.DownloadLimit() != step.wantDownload || s.UploadLimit() != step.wantUpload {
+			t.Errorf("at %v limits = %d, %d, want %d, %d", step.now, s.DownloadLimit(), s.UploadLimit(), step.wantDownload, step.wantUpload)
+		}
+		if got.DownloadLimit != step.wantDownload || got.UploadLimit != step.wantUpload {
+			t.Errorf("at %v Apply() = %+v, want the limits %d, %d", step.now, got, step.wantDownload, step.wantUpload)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package p2p
+
+// SetSequential switches the sequential download on or off. A sequential download takes the
+// pieces front to back instead of by the priorities of the files, and only the window of pieces
+// from the first missing one, so with many peers the pieces arrive close to the order they are
+// written in by Download. A window of 0 takes every piece in order, a small window stalls on a
+// slow peer that holds the first missing piece. The pieces readers wait for still come first.
+func (c *Client) SetSequential(enabled bool, window int) {
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+	c.sequential = enabled
+	c.window = max(window, 0)
+	c.updateQueueLocked()
+}
+
+// Sequential reports whether the download is sequential and its window.
+func (c *Client) Sequential() (enabled bool, window int) {
+	c.haveMu.RLock()
+	defer c.haveMu.RUnlock()
+	return c.sequential, c.window
+}
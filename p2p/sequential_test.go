@@ -0,0 +1,147 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSequential(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-3 and b.txt in 4-9.
+	files := map[string][]byte{"a.txt": randomBytes(t, 40), "b.txt": randomBytes(t, 60)}
+	torrent, data := newTestTorrent(t, "http://localhost/announce", "dir", 10, files, []string{"a.txt", "b.txt"})
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetFilePriority(1, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSequential(true, 3)
+	if enabled, window := c.Sequential(); !enabled || window != 3 {
+		t.Fatalf("Sequential() = %t, %d, want true, 3", enabled, window)
+	}
+	c.queuePieces()
+
+	closed := make(chan struct{})
+	close(closed)
+	pop := func() []uint32 {
+		var got []uint32
+		for {
+			piece, ok := c.pieceQueue.pop(closed)
+			if !ok {
+				return got
+			}
+			got = append(got, piece.Index)
+		}
+	}
+	store := func(index int) {
+		piece := c.Pieces()[index]
+		if err := c.storePiece(&piece, data[index*10:index*10+10]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The high priority of b.txt is ignored, only the window is handed out.
+	if got, want := pop(), []uint32{0, 1, 2}; !slices.Equal(got, want) {
+		t.Errorf("the queue returned %v, want %v", got, want)
+	}
+
+	// The window moves with the first missing piece, not with any verified piece.
+	store(1)
+	if got := pop(); len(got) != 0 {
+		t.Errorf("the queue returned %v before the first piece was verified", got)
+	}
+	store(0)
+	if got, want := pop(), []uint32{3, 4}; !slices.Equal(got, want) {
+		t.Errorf("the queue returned %v, want %v", got, want)
+	}
+
+	// A reader gets its pieces outside of the window.
+	r, err := c.NewReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetReadahead(1)
+	if _, err := r.Seek(50, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pop(), []uint32{9}; !slices.Equal(got, want) {
+		t.Errorf("the queue returned %v with a reader at piece 9, want %v", got, want)
+	}
+	r.Close()
+
+	// Without the sequential mode the priorities of the files apply again.
+	c.SetSequential(false, 0)
+	if got, want := pop(), []uint32{5, 6, 7, 8}; !slices.Equal(got, want) {
+		t.Errorf("the queue returned %v, want %v", got, want)
+	}
+}
+
+// chunkWriter records the writes, it can not seek.
+type chunkWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (w *chunkWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.writes)
+}
+
+func TestDownloadInOrder(t *testing.T) {
+	files := map[string][]byte{"file.bin": randomBytes(t, 30)}
+	torrent, data := newTestTorrent(t, "http://localhost/announce", "file.bin", 10, files, []string{"file.bin"})
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &chunkWriter{}
+	done := make(chan error, 1)
+	go func() { done <- c.Download(context.Background(), w) }()
+
+	store := func(index int) {
+		piece := c.Pieces()[index]
+		if err := c.storePiece(&piece, data[index*10:index*10+10]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitWrites := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for w.len() != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		// Give a wrong write the time to happen.
+		time.Sleep(time.Millisecond * 20)
+		if got := w.len(); got != want {
+			t.Fatalf("Download() wrote %d pieces, want %d", got, want)
+		}
+	}
+
+	store(1)
+	waitWrites(0)
+	store(0)
+	waitWrites(2)
+	store(2)
+	if err := <-done; err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got := bytes.Join(w.writes, nil); !bytes.Equal(got, data) {
+		t.Errorf("Download() wrote %d bytes that differ from the torrent", len(got))
+	}
+}
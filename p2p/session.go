@@ -0,0 +1,378 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/ratelimit"
+	"github.com/handsomefox/gobittorrent/utp"
+)
+
+// Session runs many torrents in one process. The torrents share the listening port, where incoming
+// handshakes are dispatched by info hash, the UDP socket, the connection limit and the bandwidth limits.
+type Session struct {
+	log    *slog.Logger
+	peerID []byte
+	tcp    net.Listener
+	utp    *utp.Socket // nil if the UDP socket could not be opened, then only TCP is used
+	port   int
+	cfg    ClientConfig // the config of the torrents
+
+	slots    *connLimit // the open connections of all torrents
+	halfOpen *connLimit // the dials in progress of all torrents
+
+	download *ratelimit.Limiter // the global limits, shared by the connections of all torrents
+	upload   *ratelimit.Limiter
+
+	events *eventHub // the events of all torrents
+
+	torrents map[[20]byte]*Client  // hybrid torrents are in the map under both of their info hashes
+	clients  []*Client             // in the order they were added
+	pending  map[net.Conn]struct{} // the incoming connections that have not sent their handshake yet
+	closed   bool
+	mu       sync.RWMutex
+	wg       sync.WaitGroup
+}
+
+// NewSession starts listening for peers on addr ("host:port", port 0 picks a free one) over TCP
+// and uTP, which use the same port number. The options apply to every torrent of the session,
+// which all use the same peer ID.
+func NewSession(log *slog.Logger, addr string, opts ...Option) (*Session, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		log:      log,
+		tcp:      l,
+		port:     l.Addr().(*net.TCPAddr).Port,
+		slots:    newConnLimit(0),
+		halfOpen: newConnLimit(0),
+		download: ratelimit.NewLimiter(ratelimit.Unlimited),
+		upload:   ratelimit.NewLimiter(ratelimit.Unlimited),
+		torrents: make(map[[20]byte]*Client),
+		pending:  make(map[net.Conn]struct{}),
+		events:   newEventHub(),
+	}
+
+	s.cfg = newClientConfig(append(opts, WithPort(s.port))...)
+	if err := s.cfg.validate(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	s.peerID = s.cfg.PeerID
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	socket, err := utp.Listen("udp", net.JoinHostPort(host, strconv.Itoa(s.port)))
+	if err != nil {
+		log.Warn("Failed to open the uTP socket, using only TCP", "err", err)
+	} else {
+		s.utp = socket
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop(s.tcp)
+	if s.utp != nil {
+		s.wg.Add(1)
+		go s.acceptLoop(s.utp)
+	}
+
+	return s, nil
+}
+
+// Port returns the port the session accepts connections on.
+func (s *Session) Port() int {
+	return s.port
+}
+
+// SetMaxConnections limits the amount of peer connections of all torrents combined, 0 means no limit.
+// Connections over the new limit are not closed, new ones are refused until there is room again.
+func (s *Session) SetMaxConnections(n int) {
+	s.slots.setMax(n)
+}
+
+// MaxConnections returns the connection limit of the session, 0 means no limit.
+func (s *Session) MaxConnections() int {
+	return s.slots.limit()
+}
+
+// ConnectionCount returns the amount of open peer connections of all torrents combined.
+func (s *Session) ConnectionCount() int {
+	return s.slots.count()
+}
+
+// SetMaxHalfOpen limits the dials in progress of all torrents combined, 0 means no limit.
+func (s *Session) SetMaxHalfOpen(n int) {
+	s.halfOpen.setMax(n)
+}
+
+func (s *Session) MaxHalfOpen() int {
+	return s.halfOpen.limit()
+}
+
+// SetDownloadLimit limits the download rate of all torrents combined in bytes per second, 0 means no limit.
+func (s *Session) SetDownloadLimit(rate int) {
+	s.download.SetRate(rate)
+}
+
+// SetUploadLimit limits the upload rate of all torrents combined in bytes per second, 0 means no limit.
+func (s *Session) SetUploadLimit(rate int) {
+	s.upload.SetRate(rate)
+}
+
+func (s *Session) DownloadLimit() int { return s.download.Rate() }
+func (s *Session) UploadLimit() int   { return s.upload.Rate() }
+
+// PeerID returns the peer ID of the torrents of the session.
+func (s *Session) PeerID() []byte {
+	return s.peerID
+}
+
+// Add starts downloading the torrent, the returned client is owned by the session.
+// The options change the config of the session for this torrent.
+func (s *Session) Add(torrent *bencode.Torrent, opts ...Option) (*Client, error) {
+	cfg := newClientConfig(append([]Option{WithConfig(s.cfg)}, opts...)...)
+	cfg.Port = s.port
+
+	c, err := newClient(s.log.With("torrent", string(torrent.File.Info.Name)), torrent, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.utp = s.utp
+	c.slots = s.slots
+	c.globalHalfOpen = s.halfOpen
+	c.session = s
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		c.storage.Close()
+		return nil, ErrSessionClosed
+	}
+	keys := infoHashes(&torrent.File)
+	for _, key := range keys {
+		if _, ok := s.torrents[key]; ok {
+			s.mu.Unlock()
+			c.storage.Close()
+			return nil, ErrTorrentExists
+		}
+	}
+	for _, key := range keys {
+		s.torrents[key] = c
+	}
+	s.clients = append(s.clients, c)
+	s.mu.Unlock()
+
+	c.start(nil)
+
+	return c, nil
+}
+
+// Remove stops the torrent and removes it from the session.
+func (s *Session) Remove(infoHash [20]byte) error {
+	c, ok := s.Torrent(infoHash)
+	if !ok {
+		return ErrTorrentNotFound
+	}
+	return c.Close()
+}
+
+// Pause disconnects the torrent from its peers until it is resumed.
+func (s *Session) Pause(infoHash [20]byte) error {
+	c, ok := s.Torrent(infoHash)
+	if !ok {
+		return ErrTorrentNotFound
+	}
+	c.Pause()
+	return nil
+}
+
+// Resume starts a paused torrent again.
+func (s *Session) Resume(infoHash [20]byte) error {
+	c, ok := s.Torrent(infoHash)
+	if !ok {
+		return ErrTorrentNotFound
+	}
+	c.Resume()
+	return nil
+}
+
+// Torrent returns the client of the torrent with the info hash.
+func (s *Session) Torrent(infoHash [20]byte) (*Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.torrents[infoHash]
+	return c, ok
+}
+
+// Torrents returns the clients of all torrents in the order they were added.
+func (s *Session) Torrents() []*Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*Client(nil), s.clients...)
+}
+
+// Close stops all the torrents and the listeners.
+func (s *Session) Close() error {
+	return s.close(func(c *Client) error {
+		c.Close()
+		return nil
+	})
+}
+
+// Shutdown stops the listeners and shuts the torrents down at the same time, see Client.Shutdown.
+func (s *Session) Shutdown(ctx context.Context) error {
+	return s.close(func(c *Client) error { return c.Shutdown(ctx) })
+}
+
+// close stops the listeners and closes every torrent with closeTorrent.
+func (s *Session) close(closeTorrent func(c *Client) error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for conn := range s.pending {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.tcp.Close()
+	if s.utp != nil {
+		err = errors.Join(err, s.utp.Close())
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = []error{err}
+	)
+	for _, c := range s.Torrents() {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			if err := closeTorrent(c); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	s.events.close()
+
+	s.wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// forget removes the client from the session, it is called when the client is closed.
+func (s *Session) forget(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range infoHashes(&c.t.File) {
+		if s.torrents[key] == c {
+			delete(s.torrents, key)
+		}
+	}
+	for i, other := range s.clients {
+		if other == c {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Session) acceptLoop(l net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, utp.ErrSocketClosed) {
+				return
+			}
+			s.log.Debug("Failed to accept a connection", "err", err)
+			continue
+		}
+		s.wg.Add(1)
+		go s.handleIncoming(conn)
+	}
+}
+
+// handleIncoming reads the handshake of a peer that connected to us and hands the connection
+// to the torrent it asks for. Closing the session closes the connections that are still in the
+// handshake.
+func (s *Session) handleIncoming(conn net.Conn) {
+	defer s.wg.Done()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.pending[conn] = struct{}{}
+	s.mu.Unlock()
+
+	if err := conn.SetReadDeadline(time.Now().Add(s.cfg.HandshakeTimeout)); err != nil {
+		s.forgetPending(conn)
+		conn.Close()
+		return
+	}
+
+	handshake, err := NewHandshakeDecoder(conn).Decode()
+	s.forgetPending(conn)
+	if err != nil {
+		s.log.Debug("Invalid incoming handshake", "addr", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
+	}
+
+	var infoHash [20]byte
+	copy(infoHash[:], handshake.InfoHash)
+
+	c, ok := s.Torrent(infoHash)
+	if !ok {
+		s.log.Debug("Incoming handshake for an unknown torrent", "addr", conn.RemoteAddr(), "info_hash", infoHash)
+		conn.Close()
+		return
+	}
+
+	if err := c.acceptConnection(conn, handshake); err != nil {
+		s.log.Debug("Refused an incoming connection", "addr", conn.RemoteAddr(), "err", err)
+		conn.Close()
+	}
+}
+
+func (s *Session) forgetPending(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, conn)
+}
+
+// infoHashes returns the info hashes peers may use in the handshake for the torrent.
+func infoHashes(f *bencode.File) [][20]byte {
+	hashes := [][20]byte{f.ProtocolInfoHash()}
+	if f.IsHybrid() {
+		var v2 [20]byte
+		copy(v2[:], f.InfoHashV2[:])
+		hashes = append(hashes, v2)
+	}
+	return hashes
+}
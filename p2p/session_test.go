@@ -0,0 +1,244 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/ratelimit"
+	"github.com/handsomefox/gobittorrent/utp"
+)
+
+// handshakeWith connects to the session and does the handshake for the info hash.
+func handshakeWith(t *testing.T, conn net.Conn, infoHash [20]byte) (*HandshakeMessage, error) {
+	t.Helper()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHandshakeEncoder(conn).Encode(&HandshakeMessage{
+		InfoHash: infoHash[:],
+		PeerID:   []byte("-TT0001-000000000000"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandshakeDecoder(conn).Decode()
+}
+
+func waitForConnection(t *testing.T, c *Client, addr string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if c.HasConnection(addr) {
+			return
+		}
+	}
+	t.Fatalf("the client has no connection from %s", addr)
+}
+
+func TestSession(t *testing.T) {
+	tracker := newTestTracker(t)
+	torrentA, _ := newTestTorrent(t, tracker.URL, "a.bin", 16384, map[string][]byte{"a.bin": randomBytes(t, 1000)}, []string{"a.bin"})
+	torrentB, _ := newTestTorrent(t, tracker.URL, "b.bin", 16384, map[string][]byte{"b.bin": randomBytes(t, 1000)}, []string{"b.bin"})
+
+	peerID := []byte("-GB0100-abcdefghijkl")
+	s, err := NewSession(slog.Default(), "127.0.0.1:0", WithPeerID(peerID))
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer s.Close()
+
+	a, err := s.Add(torrentA)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	b, err := s.Add(torrentB)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := s.Add(torrentA); !errors.Is(err, ErrTorrentExists) {
+		t.Errorf("Add() of a duplicate error = %v, want %v", err, ErrTorrentExists)
+	}
+	if got := len(s.Torrents()); got != 2 {
+		t.Errorf("len(Torrents()) = %d, want %d", got, 2)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port()))
+	dialTCP := func(t *testing.T) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("Dispatch by info hash", func(t *testing.T) {
+		conn := dialTCP(t)
+		reply, err := handshakeWith(t, conn, b.InfoHash())
+		if err != nil {
+			t.Fatalf("handshake error = %v", err)
+		}
+		if [20]byte(reply.InfoHash) != b.InfoHash() {
+			t.Errorf("reply info hash = %x, want %x", reply.InfoHash, b.InfoHash())
+		}
+		if string(reply.PeerID) != string(peerID) {
+			t.Errorf("reply peer id = %q, want %q", reply.PeerID, peerID)
+		}
+		waitForConnection(t, b, conn.LocalAddr().String())
+		if a.ConnectionCount() != 0 {
+			t.Errorf("the other torrent has %d connections, want 0", a.ConnectionCount())
+		}
+	})
+
+	t.Run("Unknown info hash", func(t *testing.T) {
+		if _, err := handshakeWith(t, dialTCP(t), [20]byte{1, 2, 3}); err == nil {
+			t.Error("handshake for an unknown torrent succeeded")
+		}
+	})
+
+	t.Run("Global connection limit", func(t *testing.T) {
+		s.SetMaxConnections(s.ConnectionCount())
+		defer s.SetMaxConnections(0)
+
+		if _, err := handshakeWith(t, dialTCP(t), a.InfoHash()); err == nil {
+			t.Error("handshake over the connection limit succeeded")
+		}
+	})
+
+	t.Run("Pause and resume", func(t *testing.T) {
+		if err := s.Pause(a.InfoHash()); err != nil {
+			t.Fatalf("Pause() error = %v", err)
+		}
+		if !a.Paused() {
+			t.Error("Paused() = false after Pause()")
+		}
+		if _, err := handshakeWith(t, dialTCP(t), a.InfoHash()); err == nil {
+			t.Error("handshake for a paused torrent succeeded")
+		}
+
+		if err := s.Resume(a.InfoHash()); err != nil {
+			t.Fatalf("Resume() error = %v", err)
+		}
+
+		// The uTP socket shares the port with the TCP listener.
+		socket, err := utp.Listen("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer socket.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := socket.DialContext(ctx, addr)
+		if err != nil {
+			t.Fatalf("uTP dial error = %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := handshakeWith(t, conn, a.InfoHash()); err != nil {
+			t.Fatalf("handshake after Resume() error = %v", err)
+		}
+		waitForConnection(t, a, conn.LocalAddr().String())
+	})
+
+	t.Run("Rate limits", func(t *testing.T) {
+		s.SetDownloadLimit(1 << 20)
+		a.SetUploadLimit(1 << 19)
+		a.SetPeerDownloadLimit(1 << 16)
+
+		if s.DownloadLimit() != 1<<20 || a.UploadLimit() != 1<<19 || a.PeerDownloadLimit() != 1<<16 {
+			t.Errorf("limits = %d, %d, %d, want %d, %d, %d", s.DownloadLimit(), a.UploadLimit(), a.PeerDownloadLimit(), 1<<20, 1<<19, 1<<16)
+		}
+
+		conns := a.Connections()
+		if len(conns) == 0 {
+			t.Fatal("the torrent has no connections")
+		}
+		for _, conn := range conns {
+			if conn.download.Rate() != 1<<16 {
+				t.Errorf("connection download limit = %d, want %d", conn.download.Rate(), 1<<16)
+			}
+			metered, ok := conn.Conn.(*meteredConn)
+			if !ok {
+				t.Fatalf("connection is a %T, want a *meteredConn", conn.Conn)
+			}
+			limited, ok := metered.Conn.(*ratelimit.Conn)
+			if !ok {
+				t.Fatalf("the metered connection wraps a %T, want a *ratelimit.Conn", metered.Conn)
+			}
+			if limited.Download[0] != s.download || limited.Upload[1] != a.upload {
+				t.Error("the connection does not use the session and torrent limiters")
+			}
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		if err := s.Remove(b.InfoHash()); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+		if _, ok := s.Torrent(b.InfoHash()); ok {
+			t.Error("Torrent() found a removed torrent")
+		}
+		if err := s.Remove(b.InfoHash()); !errors.Is(err, ErrTorrentNotFound) {
+			t.Errorf("Remove() of a removed torrent error = %v, want %v", err, ErrTorrentNotFound)
+		}
+		if got := s.Torrents(); len(got) != 1 || got[0] != a {
+			t.Errorf("Torrents() = %v, want only the remaining torrent", got)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if len(s.Torrents()) != 0 || !a.Paused() {
+		t.Error("the torrents run after Shutdown()")
+	}
+	if _, err := s.Add(torrentB); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Add() after Shutdown() error = %v, want %v", err, ErrSessionClosed)
+	}
+}
+
+// TestSessionCloseIncoming closes a session while a peer is still in the handshake, Close waits
+// for the connection instead of leaving it to the handshake timeout.
+func TestSessionCloseIncoming(t *testing.T) {
+	s, err := NewSession(slog.Default(), "127.0.0.1:0", WithHandshakeTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.RLock()
+		pending := len(s.pending)
+		s.mu.RUnlock()
+		if pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the session did not accept the connection")
+		}
+	}
+
+	start := time.Now()
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close() took %v", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Read() error = %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,311 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateWindow is the time the transfer rates are averaged over.
+const RateWindow = time.Second * 5
+
+// Stats is a snapshot of the progress of a torrent.
+type Stats struct {
+	Name          string
+	InfoHash      [20]byte
+	Paused        bool
+	Length        int64 // bytes of all the files
+	PiecesTotal   int
+	PiecesDone    int
+	PiecesSkipped int    // missing pieces that only hold skipped files, they are not downloaded
+	Have          []bool // the verified pieces by index
+	Downloaded    int64  // bytes received from peers and web seeds, including the protocol messages
+	Uploaded      int64
+	DownloadRate  float64 // bytes per second over the RateWindow
+	UploadRate    float64
+	Peers         int // open connections
+	Seeds         int // open connections to peers that have every piece
+	HalfOpen      int // dials in progress
+	Choked        int // open connections to peers that choke us
+	Interested    int // open connections to peers we told we are interested
+	QueuedPieces  int // pieces waiting for a peer or web seed
+	Requests      int // requested blocks that did not arrive yet
+	HashFailures  int64
+}
+
+// Progress returns the fraction of the pieces that are done, from 0 to 1, without the skipped ones.
+func (s *Stats) Progress() float64 {
+	if s.PiecesTotal-s.PiecesSkipped == 0 {
+		return 1
+	}
+	return float64(s.PiecesDone) / float64(s.PiecesTotal-s.PiecesSkipped)
+}
+
+// Done reports whether every piece that is not skipped was downloaded.
+func (s *Stats) Done() bool {
+	return s.PiecesDone+s.PiecesSkipped == s.PiecesTotal
+}
+
+// Status describes what the torrent is doing: "paused", "done", "stalled" or "downloading".
+func (s *Stats) Status() string {
+	switch {
+	case s.Paused:
+		return "paused"
+	case s.Done():
+		return "done"
+	case s.Peers == 0 && s.DownloadRate == 0:
+		return "stalled"
+	}
+	return "downloading"
+}
+
+// ETA estimates the time until the download is done from the current download rate,
+// it is 0 when done and -1 when nothing is being downloaded.
+func (s *Stats) ETA() time.Duration {
+	if s.Done() {
+		return 0
+	}
+	if s.DownloadRate <= 0 {
+		return -1
+	}
+	left := float64(s.Length) * (1 - s.Progress())
+	return time.Duration(left / s.DownloadRate * float64(time.Second))
+}
+
+// Stats returns the current progress of the torrent.
+func (c *Client) Stats() Stats {
+	s := Stats{
+		Name:         string(c.t.File.Info.Name),
+		InfoHash:     c.InfoHash(),
+		Paused:       c.Paused(),
+		Length:       int64(c.t.File.Info.Length),
+		PiecesTotal:  c.pieceCount,
+		Downloaded:   c.downloaded.total(),
+		Uploaded:     c.uploaded.total(),
+		DownloadRate: c.downloaded.rate(),
+		UploadRate:   c.uploaded.rate(),
+		HalfOpen:     c.halfOpen.count(),
+		QueuedPieces: c.pieceQueue.len(),
+		HashFailures: c.hashFailures.Load(),
+	}
+
+	c.haveMu.RLock()
+	s.Have = append([]bool(nil), c.have...)
+	for i, ok := range s.Have {
+		switch {
+		case ok:
+			s.PiecesDone++
+		case !c.wanted[i]:
+			s.PiecesSkipped++
+		}
+	}
+	c.haveMu.RUnlock()
+
+	for _, conn := range c.Connections() {
+		s.Peers++
+		if conn.Seed() {
+			s.Seeds++
+		}
+		if !conn.unchoked.Load() {
+			s.Choked++
+		}
+		if conn.interested.Load() {
+			s.Interested++
+		}
+		s.Requests += int(conn.requests.Load())
+	}
+	return s
+}
+
+// PeerStats is a connected peer and the transfer with it.
+type PeerStats struct {
+	Addr         string
+	Client       PeerClient
+	Flags        string // see Connection.Flags
+	DownloadRate float64
+	UploadRate   float64
+	Progress     float64 // the fraction of the pieces the peer has
+}
+
+// PeerStats returns the connected peers ordered by address.
+func (c *Client) PeerStats() []PeerStats {
+	conns := c.Connections()
+	peers := make([]PeerStats, 0, len(conns))
+	for _, conn := range conns {
+		peers = append(peers, PeerStats{
+			Addr:         conn.Addr(),
+			Client:       conn.Client(),
+			Flags:        conn.Flags(),
+			DownloadRate: conn.DownloadRate(),
+			UploadRate:   conn.UploadRate(),
+			Progress:     conn.Progress(),
+		})
+	}
+	slices.SortFunc(peers, func(a, b PeerStats) int { return strings.Compare(a.Addr, b.Addr) })
+	return peers
+}
+
+// setHave marks a verified piece, it reports whether the piece was not marked before.
+func (c *Client) setHave(index uint32) bool {
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+	if int(index) >= len(c.have) || c.have[index] {
+		return false
+	}
+	c.have[index] = true
+	c.advanceFrontLocked()
+	c.pieceQueue.setLimit(c.queueLimitLocked()) // The window of a sequential download may have moved.
+	close(c.haveCh)
+	c.haveCh = make(chan struct{})
+	return true
+}
+
+// Flags describes the connection: "I" when the peer connected to us, "P" for uTP and "S" when
+// the peer is a seed.
+func (c *Connection) Flags() string {
+	var flags string
+	if c.incoming {
+		flags += "I"
+	}
+	if _, ok := c.RemoteAddr().(*net.UDPAddr); ok {
+		flags += "P"
+	}
+	if c.Seed() {
+		flags += "S"
+	}
+	return flags
+}
+
+// DownloadRate returns the bytes per second received from the peer.
+func (c *Connection) DownloadRate() float64 { return c.downloaded.rate() }
+
+// UploadRate returns the bytes per second sent to the peer.
+func (c *Connection) UploadRate() float64 { return c.uploaded.rate() }
+
+// Progress returns the fraction of the pieces the peer has, from its bitfield and have messages.
+func (c *Connection) Progress() float64 {
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+
+	if c.pieces == 0 {
+		return 0
+	}
+	n := 0
+	for _, b := range c.bitfield {
+		n += bits.OnesCount8(b)
+	}
+	return float64(min(n, c.pieces)) / float64(c.pieces)
+}
+
+// Seed reports whether the peer has every piece.
+func (c *Connection) Seed() bool {
+	return c.pieces > 0 && c.Progress() == 1
+}
+
+// setBitfield stores the pieces the peer announced in its bitfield message.
+func (c *Connection) setBitfield(payload []byte) {
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+	c.bitfield = append([]byte(nil), payload...)
+}
+
+// setHave marks a piece the peer announced in a have message.
+func (c *Connection) setHave(payload []byte) {
+	if len(payload) < 4 {
+		return
+	}
+	index := int(binary.BigEndian.Uint32(payload))
+	if index >= c.pieces {
+		return
+	}
+
+	c.haveMu.Lock()
+	defer c.haveMu.Unlock()
+	for len(c.bitfield) <= index/8 {
+		c.bitfield = append(c.bitfield, 0)
+	}
+	c.bitfield[index/8] |= 0x80 >> (index % 8)
+}
+
+// meteredConn counts the bytes that go through the connection.
+type meteredConn struct {
+	net.Conn
+	read, written []*rateMeter
+}
+
+func (m *meteredConn) Read(p []byte) (int, error) {
+	n, err := m.Conn.Read(p)
+	for _, r := range m.read {
+		r.add(n)
+	}
+	return n, err
+}
+
+func (m *meteredConn) Write(p []byte) (int, error) {
+	n, err := m.Conn.Write(p)
+	for _, w := range m.written {
+		w.add(n)
+	}
+	return n, err
+}
+
+// rateMeter counts bytes and averages them over the RateWindow, in one second buckets.
+type rateMeter struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	sum     int64
+	buckets [int(RateWindow / time.Second)]int64
+	second  int64 // the unix second of the newest bucket
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{now: time.Now}
+}
+
+func (m *rateMeter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advanceLocked()
+	m.sum += int64(n)
+	m.buckets[m.second%int64(len(m.buckets))] += int64(n)
+}
+
+func (m *rateMeter) total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sum
+}
+
+// rate returns the bytes per second of the full seconds in the window and the current one.
+func (m *rateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advanceLocked()
+	var n int64
+	for _, b := range m.buckets {
+		n += b
+	}
+	return float64(n) / RateWindow.Seconds()
+}
+
+// advanceLocked clears the buckets of the seconds that passed since the last call.
+func (m *rateMeter) advanceLocked() {
+	now := m.now().Unix()
+	if m.second == 0 || now-m.second >= int64(len(m.buckets)) {
+		clear(m.buckets[:])
+		m.second = now
+		return
+	}
+	for ; m.second < now; m.second++ {
+		m.buckets[(m.second+1)%int64(len(m.buckets))] = 0
+	}
+}
@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := newRateMeter()
+	m.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		add     int
+		want    float64 // bytes per second after adding
+	}{
+		{name: "First second", add: 5000, want: 1000},
+		{name: "Same second", advance: time.Millisecond * 500, add: 5000, want: 2000},
+		{name: "Next second", advance: time.Second, add: 10000, want: 4000},
+		{name: "First second left the window", advance: time.Second * 4, want: 2000},
+		{name: "Idle", advance: time.Second * 10, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			m.add(tt.add)
+			if got := m.rate(); got != tt.want {
+				t.Errorf("rate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if m.total() != 20000 {
+		t.Errorf("total() = %d, want %d", m.total(), 20000)
+	}
+}
+
+func TestConnectionProgress(t *testing.T) {
+	conn := &Connection{pieces: 10}
+	conn.setBitfield([]byte{0b11110000, 0})
+	if got := conn.Progress(); got != 0.4 {
+		t.Errorf("Progress() = %v, want 0.4 after the bitfield", got)
+	}
+
+	for i := range 10 {
+		conn.setHave([]byte{0, 0, 0, byte(i)})
+	}
+	conn.setHave([]byte{0, 0, 0, 20}) // out of range
+	if !conn.Seed() || conn.Progress() != 1 {
+		t.Errorf("Seed() = false, Progress() = %v after every have", conn.Progress())
+	}
+}
+
+func TestStatsETA(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats Stats
+		want  time.Duration
+	}{
+		{name: "Done", stats: Stats{Length: 100, PiecesTotal: 4, PiecesDone: 4}, want: 0},
+		{name: "Done without the skipped pieces", stats: Stats{Length: 100, PiecesTotal: 4, PiecesDone: 1, PiecesSkipped: 3}, want: 0},
+		{name: "Stalled", stats: Stats{Length: 100, PiecesTotal: 4, PiecesDone: 1}, want: -1},
+		{name: "Downloading", stats: Stats{Length: 1000, PiecesTotal: 4, PiecesDone: 1, DownloadRate: 75}, want: time.Second * 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.ETA(); got != tt.want {
+				t.Errorf("ETA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
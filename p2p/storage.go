@@ -0,0 +1,69 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// Storage opens the place where the pieces of a torrent are kept.
+type Storage interface {
+	OpenTorrent(t *bencode.Torrent) (PieceStorage, error)
+}
+
+// PieceStorage keeps the verified pieces of a single torrent.
+type PieceStorage interface {
+	WritePiece(index uint32, data []byte) error
+	// ReadPiece returns ErrPieceNotFound if the piece was not written.
+	ReadPiece(index uint32) ([]byte, error)
+	Close() error
+}
+
+// FileSkipper is a PieceStorage that keeps the skipped files out of the file system, the client
+// tells it which files are skipped before it downloads the pieces of a changed file.
+type FileSkipper interface {
+	// SkipFile is called with the index of the file in Client.Files.
+	SkipFile(file int, skip bool) error
+}
+
+// NewMemoryStorage returns a storage that keeps the pieces in memory until the torrent is closed.
+func NewMemoryStorage() Storage {
+	return memoryStorage{}
+}
+
+type memoryStorage struct{}
+
+func (memoryStorage) OpenTorrent(*bencode.Torrent) (PieceStorage, error) {
+	return &memoryPieces{pieces: make(map[uint32][]byte)}, nil
+}
+
+type memoryPieces struct {
+	mu     sync.RWMutex
+	pieces map[uint32][]byte
+}
+
+func (m *memoryPieces) WritePiece(index uint32, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pieces[index] = data
+	return nil
+}
+
+func (m *memoryPieces) ReadPiece(index uint32) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.pieces[index]
+	if !ok {
+		return nil, fmt.Errorf("%w, piece %d", ErrPieceNotFound, index)
+	}
+	return data, nil
+}
+
+func (m *memoryPieces) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.pieces)
+	return nil
+}
@@ -0,0 +1,295 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// trackerList holds the tiers of trackers, it is tried in order and a working tracker is moved
+// to the front of its tier (BEP 12).
+type trackerList struct {
+	tiers [][]string
+	stats map[string]*TrackerStats
+	mu    sync.Mutex
+}
+
+// TrackerStats counts the announces to a tracker.
+type TrackerStats struct {
+	URL       string
+	Tier      int
+	Announces int64         // the successful ones
+	Failures  int64         // the failed ones
+	Latency   time.Duration // the total time of every announce
+	LastError string        // of the last announce, empty if it succeeded
+}
+
+func newTrackerList(f *bencode.File) *trackerList {
+	tiers := make([][]string, 0)
+	for _, tier := range f.Trackers() {
+		urls := make([]string, 0, len(tier))
+		for _, u := range tier {
+			urls = append(urls, string(u))
+		}
+		tiers = append(tiers, urls)
+	}
+	return &trackerList{tiers: tiers, stats: make(map[string]*TrackerStats)}
+}
+
+// snapshot returns a copy of the tiers, so they can be iterated without the lock.
+func (tl *trackerList) snapshot() [][]string {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tiers := make([][]string, 0, len(tl.tiers))
+	for _, tier := range tl.tiers {
+		tiers = append(tiers, append([]string(nil), tier...))
+	}
+	return tiers
+}
+
+func (tl *trackerList) add(urls ...string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	for _, u := range urls {
+		if !tl.hasLocked(u) {
+			tl.tiers = append(tl.tiers, []string{u})
+		}
+	}
+}
+
+func (tl *trackerList) hasLocked(u string) bool {
+	for _, tier := range tl.tiers {
+		for _, tracker := range tier {
+			if tracker == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// record counts an announce to the tracker that took latency and failed with err, if not nil.
+func (tl *trackerList) record(u string, latency time.Duration, err error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	stats, ok := tl.stats[u]
+	if !ok {
+		stats = &TrackerStats{URL: u}
+		tl.stats[u] = stats
+	}
+	stats.Latency += latency
+	if err != nil {
+		stats.Failures++
+		stats.LastError = err.Error()
+		return
+	}
+	stats.Announces++
+	stats.LastError = ""
+}
+
+// statsSnapshot returns the stats of every tracker in the order of the tiers.
+func (tl *trackerList) statsSnapshot() []TrackerStats {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	var stats []TrackerStats
+	for i, tier := range tl.tiers {
+		for _, u := range tier {
+			s := TrackerStats{URL: u}
+			if recorded, ok := tl.stats[u]; ok {
+				s = *recorded
+			}
+			s.Tier = i
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
+
+// promote moves the tracker to the front of its tier.
+func (tl *trackerList) promote(u string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	for _, tier := range tl.tiers {
+		for i, tracker := range tier {
+			if tracker == u {
+				copy(tier[1:i+1], tier[:i])
+				tier[0] = u
+				return
+			}
+		}
+	}
+}
+
+// Private reports whether the torrent is private (BEP 27), in which case peers may only come from
+// the trackers in the torrent, so DHT, PEX and LSD must not be used.
+func (c *Client) Private() bool {
+	return c.t.File.Info.Private
+}
+
+// Trackers returns the tiers of trackers the client announces to.
+func (c *Client) Trackers() [][]string {
+	return c.trackers.snapshot()
+}
+
+// TrackerStats returns the announce counts of the trackers in the order of the tiers.
+func (c *Client) TrackerStats() []TrackerStats {
+	return c.trackers.statsSnapshot()
+}
+
+// AddTrackers adds trackers that are not in the torrent, each one in its own tier.
+// Private torrents only use their own trackers, so it fails for them.
+func (c *Client) AddTrackers(urls ...string) error {
+	if c.Private() {
+		return ErrPrivateTorrent
+	}
+	c.trackers.add(urls...)
+	return nil
+}
+
+// Announce sends the request to the trackers, tier by tier, and returns the first successful response.
+func (c *Client) Announce(ctx context.Context) (*bencode.AnnounceResponse, error) {
+	var errs []error
+	for _, tier := range c.trackers.snapshot() {
+		for _, u := range tier {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			announce, err := c.announceTracker(ctx, u, "")
+			if err != nil {
+				c.log.Debug("Tracker announce failed", "tracker", u, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			c.trackers.promote(u)
+			return announce, nil
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrNoTrackers
+	}
+	return nil, errors.Join(errs...)
+}
+
+// announceStopped tells the trackers that answered an announce before that the client stopped,
+// all at once, so a slow tracker does not hold up the others.
+func (c *Client) announceStopped(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, tracker := range c.TrackerStats() {
+		if tracker.Announces == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			if _, err := c.announceTracker(ctx, u, "stopped"); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(tracker.URL)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// announceTracker announces to the tracker and records the result, unless the context is done.
+func (c *Client) announceTracker(ctx context.Context, tracker, event string) (*bencode.AnnounceResponse, error) {
+	start := time.Now()
+	announce, err := c.announce(ctx, tracker, event)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	latency := time.Since(start)
+	c.trackers.record(tracker, latency, err)
+	if err != nil {
+		c.emit(Event{Type: EventAnnounce, Tracker: tracker, Latency: latency, Err: err})
+		return nil, err
+	}
+	c.emit(Event{Type: EventAnnounce, Tracker: tracker, Latency: latency, Peers: len(announce.Peers)})
+	return announce, nil
+}
+
+// announce sends the request to the tracker to get the latest announce message, the event is
+// empty for the regular announces.
+func (c *Client) announce(ctx context.Context, tracker, event string) (*bencode.AnnounceResponse, error) {
+	infoHash := c.t.File.ProtocolInfoHash()
+	announceReq := bencode.AnnounceMessage{
+		Announce:   bencode.String(tracker),
+		InfoHash:   bencode.String(hex.EncodeToString(infoHash[:])),
+		PeerID:     bencode.String(c.peerID),
+		Port:       bencode.Integer(c.port),
+		Uploaded:   0,
+		Downloaded: 0,
+		Left:       c.t.File.Info.Length,
+		Compact:    1,
+		Key:        bencode.String(c.key),
+		Event:      bencode.String(event),
+	}
+	return sendAnnounce(ctx, &c.cfg, &announceReq)
+}
+
+// sendAnnounce sends the announce to its tracker and decodes the answer.
+func sendAnnounce(ctx context.Context, cfg *ClientConfig, announceReq *bencode.AnnounceMessage) (*bencode.AnnounceResponse, error) {
+	tracker := string(announceReq.Announce)
+	u, err := announceReq.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", cfg.UserAgent)
+
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := bencode.NewDecoder(bytes.NewReader(body)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", bencode.ErrDecodeAnnounceBody, tracker, err)
+	}
+
+	announce := new(bencode.AnnounceResponse)
+
+	if err := announce.Unmarshal(decoded); err != nil {
+		return nil, err
+	}
+
+	return announce, nil
+}
+
+// newKey returns the random key that is sent with every announce of the client.
+func newKey() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+func TestAnnounceTiers(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	var (
+		queries []map[string]string
+		mu      sync.Mutex
+	)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, map[string]string{
+			"peer_id": r.URL.Query().Get("peer_id"),
+			"key":     r.URL.Query().Get("key"),
+		})
+		mu.Unlock()
+		w.Write([]byte("d8:intervali1800e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer working.Close()
+
+	torrent, _ := newTestTorrent(t, broken.URL, "file.bin", 16384, map[string][]byte{"file.bin": randomBytes(t, 100)}, []string{"file.bin"})
+	torrent.File.AnnounceList = [][]bencode.String{{bencode.String(broken.URL), bencode.String(working.URL)}}
+	torrent.File.Info.Private = true
+
+	c, err := newClient(slog.New(slog.NewTextHandler(io.Discard, nil)), torrent, newClientConfig(WithPeerID([]byte("-GB0100-abcdefghijkl"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		announce, err := c.Announce(context.Background())
+		if err != nil {
+			t.Fatalf("Announce() error = %v", err)
+		}
+		if len(announce.Peers) != 1 || announce.Peers[0].Addr() != "127.0.0.1:6881" {
+			t.Errorf("Announce() peers = %v, want [127.0.0.1:6881]", announce.Peers)
+		}
+	}
+
+	if want := [][]string{{working.URL, broken.URL}}; !reflect.DeepEqual(c.Trackers(), want) {
+		t.Errorf("Trackers() = %v, want the working tracker first %v", c.Trackers(), want)
+	}
+	stats := c.TrackerStats()
+	if len(stats) != 2 || stats[0].Announces != 2 || stats[0].Failures != 0 || stats[1].Announces != 0 || stats[1].Failures != 1 || stats[1].LastError == "" {
+		t.Errorf("TrackerStats() = %+v, want 2 announces to the working tracker and 1 failure of the broken one", stats)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("the working tracker got %d announces, want %d", len(queries), 2)
+	}
+	if queries[0]["key"] == "" || !reflect.DeepEqual(queries[0], queries[1]) {
+		t.Errorf("peer_id and key changed between announces: %v", queries)
+	}
+
+	if err := c.AddTrackers("http://public/announce"); !errors.Is(err, ErrPrivateTorrent) {
+		t.Errorf("AddTrackers() error = %v, want %v", err, ErrPrivateTorrent)
+	}
+}
@@ -0,0 +1,263 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// PieceState is the result of checking a piece or a file against the torrent.
+type PieceState int
+
+const (
+	PieceComplete PieceState = iota // the data matches the hash
+	PieceCorrupt                    // the data is there but does not match the hash
+	PieceMissing                    // a file of the data does not exist or is too short
+)
+
+func (s PieceState) String() string {
+	switch s {
+	case PieceComplete:
+		return "complete"
+	case PieceCorrupt:
+		return "corrupt"
+	case PieceMissing:
+		return "missing"
+	}
+	return fmt.Sprintf("PieceState(%d)", int(s))
+}
+
+func (s *PieceState) UnmarshalText(text []byte) error {
+	for state := PieceComplete; state <= PieceMissing; state++ {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrInvalidPieceState, text)
+}
+
+func (s PieceState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// VerifyReport is the state of the data of a torrent on disk.
+type VerifyReport struct {
+	Pieces []PieceState // by the index of the piece
+	Files  []FileReport // in the order of Client.Files
+}
+
+// FileReport is the state of a file of the torrent on disk.
+type FileReport struct {
+	Path           string     `json:"path"`
+	Length         int64      `json:"length"`
+	State          PieceState `json:"state"` // complete when every piece of it is, missing when it does not exist or a piece of it is
+	PiecesComplete int        `json:"pieces_complete"`
+	PiecesTotal    int        `json:"pieces_total"`
+}
+
+// Complete reports whether every piece matches the torrent.
+func (r *VerifyReport) Complete() bool {
+	for _, s := range r.Pieces {
+		if s != PieceComplete {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the amount of pieces in the state.
+func (r *VerifyReport) Count(state PieceState) int {
+	n := 0
+	for _, s := range r.Pieces {
+		if s == state {
+			n++
+		}
+	}
+	return n
+}
+
+// verifiedFile is a file of the torrent opened for reading, f is nil when it does not exist.
+type verifiedFile struct {
+	span fileSpan
+	f    *os.File
+	size int64
+}
+
+// Verify checks the data of the torrent at path against its hashes, in parallel with workers
+// goroutines (the number of CPUs when it is not positive). The path of a single-file torrent is the
+// file or the directory it is in, the files of the other torrents are under the directory named
+// after the torrent in path, or under path itself.
+func Verify(ctx context.Context, t *bencode.Torrent, path string, workers int) (*VerifyReport, error) {
+	pieces, err := torrentPieces(&t.File, ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	files, err := openVerifiedFiles(t, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			if f.f != nil {
+				f.f.Close()
+			}
+		}
+	}()
+
+	var (
+		pieceLength = int64(t.File.Info.PieceLength)
+		report      = &VerifyReport{Pieces: make([]PieceState, len(pieces))}
+		jobs        = make(chan int)
+		wg          sync.WaitGroup
+		errOnce     sync.Once
+		verifyErr   error
+		quitch      = make(chan struct{})
+	)
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				state, err := verifyPiece(&t.File, &pieces[i], files, int64(i)*pieceLength)
+				if err != nil {
+					errOnce.Do(func() {
+						verifyErr = err
+						close(quitch)
+					})
+					return
+				}
+				report.Pieces[i] = state
+			}
+		}()
+	}
+
+loop:
+	for i := range pieces {
+		select {
+		case jobs <- i:
+		case <-quitch:
+			break loop
+		case <-ctx.Done():
+			errOnce.Do(func() { verifyErr = ctx.Err() })
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+
+	for _, f := range files {
+		file := FileReport{Path: f.span.path, Length: f.span.length, State: PieceComplete}
+		for i := f.span.first; i <= f.span.last; i++ {
+			file.PiecesTotal++
+			switch report.Pieces[i] {
+			case PieceComplete:
+				file.PiecesComplete++
+			case PieceCorrupt:
+				file.State = PieceCorrupt
+			case PieceMissing:
+				if file.State == PieceComplete {
+					file.State = PieceMissing
+				}
+			}
+		}
+		if f.f == nil {
+			file.State = PieceMissing
+		}
+		report.Files = append(report.Files, file)
+	}
+	return report, nil
+}
+
+// openVerifiedFiles opens the files of the torrent under the path that exist.
+func openVerifiedFiles(t *bencode.Torrent, path string) ([]verifiedFile, error) {
+	info := &t.File.Info
+	if err := checkTorrentName(string(info.Name)); err != nil {
+		return nil, err
+	}
+	singleFile := t.File.IsV1() && !info.IsMultiFile()
+	root := path
+	if sub := filepath.Join(path, string(info.Name)); isDir(path) && (singleFile || isDir(sub)) {
+		root = sub
+	}
+
+	spans := fileSpans(&t.File)
+	files := make([]verifiedFile, 0, len(spans))
+	for _, span := range spans {
+		name := root
+		if !singleFile {
+			if !filepath.IsLocal(filepath.FromSlash(span.path)) {
+				return nil, fmt.Errorf("%w, %q", ErrUnsafePath, span.path)
+			}
+			name = filepath.Join(root, filepath.FromSlash(span.path))
+		}
+
+		file := verifiedFile{span: span}
+		f, err := os.Open(name)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			fi, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			file.f, file.size = f, fi.Size()
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// verifyPiece reads the piece at the offset from the files and checks it, the padding between the
+// files stays zero.
+func verifyPiece(f *bencode.File, piece *Piece, files []verifiedFile, start int64) (PieceState, error) {
+	data := make([]byte, piece.TotalSize)
+	end := start + int64(len(data))
+	for _, file := range files {
+		from, to := max(start, file.span.offset), min(end, file.span.offset+file.span.length)
+		if from >= to {
+			continue
+		}
+		if file.f == nil || file.size < to-file.span.offset {
+			return PieceMissing, nil
+		}
+		if _, err := file.f.ReadAt(data[from-start:to-start], from-file.span.offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return PieceMissing, nil // It was truncated while verifying.
+			}
+			return 0, err
+		}
+	}
+
+	if err := VerifyPiece(f, piece, data); err != nil {
+		if errors.Is(err, ErrInvalidPieceHash) {
+			return PieceCorrupt, nil
+		}
+		return 0, err
+	}
+	return PieceComplete, nil
+}
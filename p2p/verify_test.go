@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+func TestVerify(t *testing.T) {
+	// Pieces of 10 bytes: a.txt is in 0-1, dir/b.txt in 1-2 and c.txt in 2-3.
+	files := map[string][]byte{
+		"a.txt":     randomBytes(t, 15),
+		"dir/b.txt": randomBytes(t, 10),
+		"c.txt":     randomBytes(t, 15),
+	}
+	order := []string{"a.txt", "dir/b.txt", "c.txt"}
+	multi, _ := newTestTorrent(t, "http://localhost/announce", "root", 10, files, order)
+	single, _ := newTestTorrent(t, "http://localhost/announce", "a.txt", 10, map[string][]byte{"a.txt": files["a.txt"]}, []string{"a.txt"})
+
+	tests := []struct {
+		name       string
+		multi      bool
+		change     func(t *testing.T, root string) // of the written files
+		path       func(dir string) string
+		wantPieces []PieceState
+		wantFiles  []PieceState
+	}{
+		{
+			name:       "Complete",
+			multi:      true,
+			path:       func(dir string) string { return dir },
+			wantPieces: []PieceState{PieceComplete, PieceComplete, PieceComplete, PieceComplete},
+			wantFiles:  []PieceState{PieceComplete, PieceComplete, PieceComplete},
+		},
+		{
+			name:       "The directory of the torrent",
+			multi:      true,
+			path:       func(dir string) string { return filepath.Join(dir, "root") },
+			wantPieces: []PieceState{PieceComplete, PieceComplete, PieceComplete, PieceComplete},
+			wantFiles:  []PieceState{PieceComplete, PieceComplete, PieceComplete},
+		},
+		{
+			name:  "Corrupt",
+			multi: true,
+			change: func(t *testing.T, root string) {
+				corrupt(t, filepath.Join(root, "c.txt"), 12)
+			},
+			path:       func(dir string) string { return dir },
+			wantPieces: []PieceState{PieceComplete, PieceComplete, PieceComplete, PieceCorrupt},
+			wantFiles:  []PieceState{PieceComplete, PieceComplete, PieceCorrupt},
+		},
+		{
+			name:  "Missing",
+			multi: true,
+			change: func(t *testing.T, root string) {
+				if err := os.Remove(filepath.Join(root, "dir", "b.txt")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:       func(dir string) string { return dir },
+			wantPieces: []PieceState{PieceComplete, PieceMissing, PieceMissing, PieceComplete},
+			wantFiles:  []PieceState{PieceMissing, PieceMissing, PieceMissing},
+		},
+		{
+			name:  "Truncated",
+			multi: true,
+			change: func(t *testing.T, root string) {
+				if err := os.Truncate(filepath.Join(root, "c.txt"), 7); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:       func(dir string) string { return dir },
+			wantPieces: []PieceState{PieceComplete, PieceComplete, PieceComplete, PieceMissing},
+			wantFiles:  []PieceState{PieceComplete, PieceComplete, PieceMissing},
+		},
+		{
+			name:       "Single file",
+			path:       func(dir string) string { return filepath.Join(dir, "a.txt") },
+			wantPieces: []PieceState{PieceComplete, PieceComplete},
+			wantFiles:  []PieceState{PieceComplete},
+		},
+		{
+			name: "Single file in a directory",
+			change: func(t *testing.T, root string) {
+				corrupt(t, filepath.Join(root, "a.txt"), 0)
+			},
+			path:       func(dir string) string { return dir },
+			wantPieces: []PieceState{PieceCorrupt, PieceComplete},
+			wantFiles:  []PieceState{PieceCorrupt},
+		},
+		{
+			name:       "Nothing",
+			path:       func(dir string) string { return filepath.Join(dir, "nothing") },
+			wantPieces: []PieceState{PieceMissing, PieceMissing},
+			wantFiles:  []PieceState{PieceMissing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			torrent, root := single, dir
+			if tt.multi {
+				torrent, root = multi, filepath.Join(dir, "root")
+				writeTestFiles(t, root, files)
+			} else {
+				writeTestFiles(t, root, map[string][]byte{"a.txt": files["a.txt"]})
+			}
+			if tt.change != nil {
+				tt.change(t, root)
+			}
+
+			report, err := Verify(context.Background(), torrent, tt.path(dir), 2)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if !slices.Equal(report.Pieces, tt.wantPieces) {
+				t.Errorf("Verify() pieces = %v, want %v", report.Pieces, tt.wantPieces)
+			}
+			var got []PieceState
+			for _, f := range report.Files {
+				got = append(got, f.State)
+			}
+			if !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("Verify() files = %v, want %v", got, tt.wantFiles)
+			}
+			if complete := !slices.ContainsFunc(tt.wantPieces, func(s PieceState) bool { return s != PieceComplete }); report.Complete() != complete {
+				t.Errorf("Complete() = %t, want %t", report.Complete(), complete)
+			}
+		})
+	}
+}
+
+// corrupt flips a byte of the file.
+func corrupt(t *testing.T, path string, offset int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyV2(t *testing.T) {
+	const pieceLength = 2 * bencode.BlockSize
+	files := []testFile{
+		{name: "a.bin", data: randomBytes(t, 3*bencode.BlockSize+100)},
+		{name: "b.bin", data: randomBytes(t, 1000)},
+	}
+
+	for _, hybrid := range []bool{false, true} {
+		name := "v2"
+		if hybrid {
+			name = "hybrid"
+		}
+		t.Run(name, func(t *testing.T) {
+			torrent := newTestTorrentV2(t, pieceLength, files, hybrid)
+			dir := t.TempDir()
+			writeTestFiles(t, filepath.Join(dir, "dir"), map[string][]byte{files[0].name: files[0].data, files[1].name: files[1].data})
+			corrupt(t, filepath.Join(dir, "dir", "a.bin"), pieceLength+1)
+
+			report, err := Verify(context.Background(), torrent, dir, 0)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if want := []PieceState{PieceComplete, PieceCorrupt, PieceComplete}; !slices.Equal(report.Pieces, want) {
+				t.Errorf("Verify() pieces = %v, want %v", report.Pieces, want)
+			}
+			if len(report.Files) != 2 || report.Files[0].State != PieceCorrupt || report.Files[1].State != PieceComplete {
+				t.Errorf("Verify() files = %+v, want a.bin corrupt and b.bin complete", report.Files)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/ratelimit"
+)
+
+const (
+	WebSeedMinBackoff time.Duration = time.Second
+	WebSeedMaxBackoff time.Duration = time.Minute * 5
+)
+
+// WebSeed is an HTTP server that has the torrent contents (BEP 19), it is used like a regular peer.
+type WebSeed struct {
+	URL       string
+	UserAgent string
+	Download  []*ratelimit.Limiter // the limiters of the fetched bytes, for example the session and the torrent ones
+	client    *http.Client
+	failures  int
+}
+
+func NewWebSeed(u string, client *http.Client) *WebSeed {
+	return &WebSeed{URL: u, client: client}
+}
+
+// fileRange is the part of a single file that a piece covers.
+type fileRange struct {
+	URL    string // empty for padding files, which are all zeros
+	Offset int64
+	Length int64
+}
+
+// Backoff returns how long to wait before using the web seed again after the last failure.
+func (ws *WebSeed) Backoff() time.Duration {
+	if ws.failures == 0 {
+		return 0
+	}
+	backoff := WebSeedMinBackoff << min(ws.failures-1, 16)
+	return min(backoff, WebSeedMaxBackoff)
+}
+
+// FetchPiece downloads the piece with HTTP range requests and verifies its hash.
+func (ws *WebSeed) FetchPiece(ctx context.Context, f *bencode.File, piece *Piece) ([]byte, error) {
+	data, err := ws.fetchPiece(ctx, f, piece)
+	if err != nil {
+		ws.failures++
+		return nil, err
+	}
+	ws.failures = 0
+	return data, nil
+}
+
+func (ws *WebSeed) fetchPiece(ctx context.Context, f *bencode.File, piece *Piece) ([]byte, error) {
+	ranges, err := ws.pieceRanges(f, int64(piece.Index)*int64(f.Info.PieceLength), int64(piece.TotalSize))
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, piece.TotalSize)
+	for _, r := range ranges {
+		b, err := ws.fetchRange(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+
+	if err := VerifyPiece(f, piece, data); err != nil {
+		return nil, fmt.Errorf("web seed %q: %w", ws.URL, err)
+	}
+
+	return data, nil
+}
+
+// pieceRanges maps the byte range of the pieces onto the files (and their URLs) that contain it,
+// the gaps between the files are the padding of hybrid torrents.
+func (ws *WebSeed) pieceRanges(f *bencode.File, offset, length int64) ([]fileRange, error) {
+	spans := fileSpans(f)
+	if !f.Info.IsMultiFile() {
+		u := ws.URL
+		if strings.HasSuffix(u, "/") {
+			u += url.PathEscape(string(f.Info.Name))
+		}
+		if len(spans) != 1 || offset+length > spans[0].length {
+			return nil, fmt.Errorf("%w, piece is outside of the torrent files", ErrPieceNotFound)
+		}
+		return []fileRange{{URL: u, Offset: offset, Length: length}}, nil
+	}
+
+	base := strings.TrimSuffix(ws.URL, "/") + "/" + url.PathEscape(string(f.Info.Name))
+
+	ranges := make([]fileRange, 0, 1)
+	for _, span := range spans {
+		if length <= 0 {
+			break
+		}
+		end := span.offset + span.length
+		if offset >= end {
+			continue
+		}
+		if gap := min(span.offset-offset, length); gap > 0 {
+			ranges = append(ranges, fileRange{Length: gap})
+			offset += gap
+			length -= gap
+		}
+		if length <= 0 {
+			break
+		}
+
+		u := base
+		for _, component := range strings.Split(span.path, "/") {
+			u += "/" + url.PathEscape(component)
+		}
+		n := min(end-offset, length)
+		ranges = append(ranges, fileRange{URL: u, Offset: offset - span.offset, Length: n})
+		offset += n
+		length -= n
+	}
+
+	if length > 0 {
+		return nil, fmt.Errorf("%w, piece is outside of the torrent files", ErrPieceNotFound)
+	}
+
+	return ranges, nil
+}
+
+func (ws *WebSeed) fetchRange(ctx context.Context, r fileRange) ([]byte, error) {
+	if r.URL == "" {
+		return make([]byte, r.Length), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if ws.UserAgent != "" {
+		req.Header.Set("User-Agent", ws.UserAgent)
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(r.Offset, 10)+"-"+strconv.FormatInt(r.Offset+r.Length-1, 10))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = ratelimit.NewReader(ctx, resp.Body, ws.Download...)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK: // The server ignored the range, skip to it ourselves.
+		if _, err := io.CopyN(io.Discard, body, r.Offset); err != nil {
+			return nil, fmt.Errorf("%w, %q: %w", ErrWebSeedShortRead, r.URL, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w %d from %q", ErrWebSeedStatus, resp.StatusCode, r.URL)
+	}
+
+	data := make([]byte, r.Length)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("%w, %q: %w", ErrWebSeedShortRead, r.URL, err)
+	}
+
+	return data, nil
+}
+
+// handleWebSeed is the main loop of a web seed, it takes pieces from the queue like a peer connection does.
+func (c *Client) handleWebSeed(ctx context.Context, ws *WebSeed, quitch chan struct{}) {
+	for {
+		if backoff := ws.Backoff(); backoff > 0 {
+			select {
+			case <-quitch:
+				return
+			case <-time.After(backoff):
+			}
+		}
+
+		piece, ok := c.pieceQueue.pop(quitch)
+		if !ok {
+			return
+		}
+		data, err := ws.FetchPiece(ctx, &c.t.File, piece)
+		c.downloaded.add(len(data))
+		if errors.Is(err, ErrInvalidPieceHash) {
+			c.hashFailures.Add(1)
+			c.emit(Event{Type: EventPieceFailed, Piece: int(piece.Index), Err: err})
+		}
+		if err == nil {
+			err = c.storePiece(piece, data) // FetchPiece verified it.
+		}
+		if err != nil {
+			c.log.Warn("Web seed failed to fetch a piece", "url", ws.URL, "piece", piece.Index, "err", err, "backoff", ws.Backoff())
+			c.requeuePiece(piece)
+			continue
+		}
+
+		piece.DownloadedSize = piece.TotalSize
+		c.log.Debug("Web seed fetched a piece", "url", ws.URL, "piece", piece.Index)
+	}
+}
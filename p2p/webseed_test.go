@@ -0,0 +1,302 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+)
+
+// newTestTorrent builds a torrent for the data, files are laid out one after another.
+func newTestTorrent(t *testing.T, announce, name string, pieceLength int, files map[string][]byte, order []string, urls ...string) (*bencode.Torrent, []byte) {
+	t.Helper()
+
+	var data []byte
+	info := bencode.Dictionary{
+		"name":         bencode.String(name),
+		"piece length": bencode.Integer(pieceLength),
+	}
+	if len(order) == 1 && order[0] == name {
+		data = files[name]
+		info["length"] = bencode.Integer(len(data))
+	} else {
+		list := bencode.List{}
+		for _, path := range order {
+			data = append(data, files[path]...)
+			components := bencode.List{}
+			for _, c := range strings.Split(path, "/") {
+				components = append(components, bencode.String(c))
+			}
+			list = append(list, bencode.Dictionary{
+				"length": bencode.Integer(len(files[path])),
+				"path":   components,
+			})
+		}
+		info["files"] = list
+	}
+
+	var pieces []byte
+	for i := 0; i < len(data); i += pieceLength {
+		sum := sha1.Sum(data[i:min(i+pieceLength, len(data))])
+		pieces = append(pieces, sum[:]...)
+	}
+	info["pieces"] = bencode.String(pieces)
+
+	urlList := bencode.List{}
+	for _, u := range urls {
+		urlList = append(urlList, bencode.String(u))
+	}
+
+	encoded, err := bencode.Dictionary{
+		"announce":   bencode.String(announce),
+		"created by": bencode.String("gobittorrent test"),
+		"url-list":   urlList,
+		"info":       info,
+	}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent, err := bencode.NewTorrent(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return torrent, data
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for path, data := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestTracker returns a tracker that never has any peers.
+func newTestTracker(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebSeedFetchPieceMultiFile(t *testing.T) {
+	files := map[string][]byte{
+		"a.txt":       randomBytes(t, 10),
+		"dir/b.txt":   randomBytes(t, 50000),
+		"dir/c d.bin": randomBytes(t, 20000),
+	}
+	order := []string{"a.txt", "dir/b.txt", "dir/c d.bin"}
+
+	dir := t.TempDir()
+	writeTestFiles(t, filepath.Join(dir, "root"), files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	torrent, data := newTestTorrent(t, "http://localhost/announce", "root", 16384, files, order, srv.URL+"/")
+	pieces, err := torrentPieces(&torrent.File, ChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := NewWebSeed(srv.URL+"/", srv.Client())
+
+	for _, piece := range pieces {
+		got, err := ws.FetchPiece(context.Background(), &torrent.File, &piece)
+		if err != nil {
+			t.Fatalf("FetchPiece(%d) error = %v", piece.Index, err)
+		}
+		start := int(piece.Index) * 16384
+		if !bytes.Equal(got, data[start:start+piece.TotalSize]) {
+			t.Errorf("FetchPiece(%d) returned wrong data", piece.Index)
+		}
+	}
+}
+
+func TestWebSeedFetchPieceV2(t *testing.T) {
+	const pieceLength = 2 * bencode.BlockSize
+	files := []testFile{
+		{name: "a.bin", data: randomBytes(t, 3*bencode.BlockSize+100)},
+		{name: "b.bin", data: randomBytes(t, 1000)},
+	}
+
+	dir := t.TempDir()
+	writeTestFiles(t, filepath.Join(dir, "dir"), map[string][]byte{files[0].name: files[0].data, files[1].name: files[1].data})
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	for _, hybrid := range []bool{false, true} {
+		name := "v2"
+		if hybrid {
+			name = "hybrid"
+		}
+		t.Run(name, func(t *testing.T) {
+			torrent := newTestTorrentV2(t, pieceLength, files, hybrid)
+			pieces, err := torrentPieces(&torrent.File, ChunkSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pieces) != 3 {
+				t.Fatalf("len(pieces) = %d, want 3", len(pieces))
+			}
+
+			ws := NewWebSeed(srv.URL+"/", srv.Client())
+			for _, piece := range pieces {
+				// FetchPiece verifies the data, only the right bytes of the right file pass.
+				if _, err := ws.FetchPiece(context.Background(), &torrent.File, &piece); err != nil {
+					t.Errorf("FetchPiece(%d) error = %v", piece.Index, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWebSeedBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	files := map[string][]byte{"file.bin": randomBytes(t, 1000)}
+	torrent, _ := newTestTorrent(t, "http://localhost/announce", "file.bin", 16384, files, []string{"file.bin"}, srv.URL)
+	pieces, err := torrentPieces(&torrent.File, ChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	piece := pieces[0]
+	ws := NewWebSeed(srv.URL, srv.Client())
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		if _, err := ws.FetchPiece(context.Background(), &torrent.File, &piece); !errors.Is(err, ErrWebSeedStatus) {
+			t.Fatalf("FetchPiece() error = %v, want %v", err, ErrWebSeedStatus)
+		}
+		if backoff := int64(ws.Backoff()); backoff <= last {
+			t.Errorf("Backoff() = %d after %d failures, want more than %d", backoff, i+1, last)
+		} else {
+			last = backoff
+		}
+	}
+}
+
+func TestClientDownloadFromWebSeed(t *testing.T) {
+	files := map[string][]byte{"file.bin": randomBytes(t, 100000)}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tracker := newTestTracker(t)
+	torrent, want := newTestTorrent(t, tracker.URL, "file.bin", 32768, files, []string{"file.bin"}, srv.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.Default(), torrent)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	var buf bytes.Buffer
+	if err := client.Download(context.Background(), &buf); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Download() wrote %d bytes that differ from the %d bytes of the web seed", buf.Len(), len(want))
+	}
+
+	stats := client.Stats()
+	if !stats.Done() || stats.PiecesTotal != 4 || stats.Downloaded != int64(len(want)) || stats.DownloadRate <= 0 || stats.ETA() != 0 {
+		t.Errorf("Stats() = %+v, want 4 done pieces and %d downloaded bytes", stats, len(want))
+	}
+}
+
+func TestClientDownloadFromWebSeedWithoutTracker(t *testing.T) {
+	files := map[string][]byte{"file.bin": randomBytes(t, 40000)}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer tracker.Close()
+	torrent, want := newTestTorrent(t, tracker.URL, "file.bin", 32768, files, []string{"file.bin"}, srv.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.Default(), torrent)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	var buf bytes.Buffer
+	if err := client.Download(context.Background(), &buf); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Download() wrote %d bytes that differ from the %d bytes of the web seed", buf.Len(), len(want))
+	}
+
+	trackers := client.TrackerStats()
+	if len(trackers) != 1 || trackers[0].Failures != 1 || trackers[0].LastError == "" {
+		t.Errorf("TrackerStats() = %+v, want the failed announce", trackers)
+	}
+}
+
+func TestClientDownloadFromWebSeedLimited(t *testing.T) {
+	const rate = 40000
+	files := map[string][]byte{"file.bin": randomBytes(t, rate)}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, files)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tracker := newTestTracker(t)
+	torrent, _ := newTestTorrent(t, tracker.URL, "file.bin", 16384, files, []string{"file.bin"}, srv.URL+"/")
+
+	client, err := NewClient(context.Background(), slog.Default(), torrent)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+	client.SetDownloadLimit(rate)
+
+	start := time.Now()
+	if err := client.Download(context.Background(), io.Discard); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	// The limiter starts empty, a second worth of bytes takes about a second.
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Download() of %d bytes at %d bytes per second took %v", rate, rate, elapsed)
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// MaxChunk is the most bytes read or written at once, so that waits stay short and
+// the limited connections take turns.
+const MaxChunk = 16 * 1024
+
+// Conn is a connection whose reads and writes are limited by every limiter of the direction.
+type Conn struct {
+	net.Conn
+	Download []*Limiter
+	Upload   []*Limiter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewConn(conn net.Conn, download, upload []*Limiter) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{Conn: conn, Download: download, Upload: upload, ctx: ctx, cancel: cancel}
+}
+
+// Read reads first and then waits for the bytes it got, the peer is slowed down by the TCP window.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:min(len(p), MaxChunk)])
+	if n > 0 {
+		if werr := WaitN(c.ctx, n, c.Download...); werr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+// Write waits for the tokens before writing every chunk.
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(len(p), written+MaxChunk)]
+		if err := WaitN(c.ctx, len(chunk), c.Upload...); err != nil {
+			return written, net.ErrClosed
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Close stops the waiting reads and writes and closes the connection.
+func (c *Conn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
@@ -0,0 +1,122 @@
+// Package ratelimit implements the token bucket limiters that cap the bandwidth of peer connections.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Unlimited is the rate of a limiter that never waits.
+const Unlimited = 0
+
+// Clock is the source of time for the limiters, tests replace it with a fake one.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the real time.
+var SystemClock Clock = systemClock{}
+
+// Limiter is a token bucket that holds up to one second worth of bytes. Callers take the tokens
+// before they wait, so the bucket may go into debt, which the following callers wait out.
+// A nil *Limiter is unlimited.
+type Limiter struct {
+	clock  Clock
+	mu     sync.Mutex
+	rate   float64 // bytes per second, 0 is unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter for rate bytes per second, Unlimited (0) means no limit.
+func NewLimiter(rate int) *Limiter {
+	return NewLimiterWithClock(rate, SystemClock)
+}
+
+func NewLimiterWithClock(rate int, clock Clock) *Limiter {
+	l := &Limiter{clock: clock, last: clock.Now()}
+	l.SetRate(rate)
+	l.tokens = l.rate
+	return l
+}
+
+// Rate returns the limit in bytes per second.
+func (l *Limiter) Rate() int {
+	if l == nil {
+		return Unlimited
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rate)
+}
+
+// SetRate changes the limit, callers that are already waiting keep their wait time.
+func (l *Limiter) SetRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refillLocked()
+	l.rate = float64(max(rate, 0))
+	l.tokens = min(l.tokens, l.rate)
+}
+
+// WaitN blocks until n bytes may be transferred or the context is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	d := l.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.clock.After(d):
+		return nil
+	}
+}
+
+// reserve takes n tokens and returns how long to wait for them.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == Unlimited {
+		return 0
+	}
+
+	l.refillLocked()
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) refillLocked() {
+	now := l.clock.Now()
+	if l.rate != Unlimited {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	}
+	l.last = now
+}
+
+// WaitN waits on every limiter in turn, for example the global, the torrent and the peer one.
+func WaitN(ctx context.Context, n int, limiters ...*Limiter) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock moves forward whenever someone waits on it, so the tests don't sleep.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// transfer takes total bytes from the limiters in MaxChunk pieces and returns the fake time it took.
+func transfer(t *testing.T, clock *fakeClock, total int, limiters ...*Limiter) time.Duration {
+	t.Helper()
+	start := clock.Now()
+	for n := 0; n < total; n += MaxChunk {
+		if err := WaitN(context.Background(), min(MaxChunk, total-n), limiters...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return clock.Now().Sub(start)
+}
+
+func assertDuration(t *testing.T, got, want time.Duration) {
+	t.Helper()
+	const tolerance = 0.05
+	if math.Abs(float64(got-want)) > tolerance*float64(want) {
+		t.Errorf("took %v, want %v (±%.0f%%)", got, want, tolerance*100)
+	}
+}
+
+func TestLimiterThroughput(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int
+		total int
+	}{
+		{name: "64KiB/s", rate: 64 * 1024, total: 1024 * 1024},
+		{name: "1MiB/s", rate: 1024 * 1024, total: 20 * 1024 * 1024},
+		{name: "Not a multiple of the chunk", rate: 10000, total: 123456},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := NewLimiterWithClock(tt.rate, clock)
+
+			// The bucket starts full, so the first second worth of bytes is free.
+			want := time.Duration(float64(tt.total-tt.rate) / float64(tt.rate) * float64(time.Second))
+			assertDuration(t, transfer(t, clock, tt.total, l), want)
+		})
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	const rate = 100000
+	clock := newFakeClock()
+	l := NewLimiterWithClock(rate, clock)
+
+	transfer(t, clock, rate, l) // Drain the bucket.
+	assertDuration(t, transfer(t, clock, 2*rate, l), 2*time.Second)
+
+	l.SetRate(4 * rate)
+	if l.Rate() != 4*rate {
+		t.Errorf("Rate() = %d, want %d", l.Rate(), 4*rate)
+	}
+	assertDuration(t, transfer(t, clock, 4*rate, l), time.Second)
+
+	l.SetRate(Unlimited)
+	if got := transfer(t, clock, 100*rate, l); got != 0 {
+		t.Errorf("unlimited transfer took %v, want 0", got)
+	}
+}
+
+func TestWaitNChain(t *testing.T) {
+	const (
+		global = 100000
+		peer   = 1000000
+	)
+	clock := newFakeClock()
+	limiters := []*Limiter{NewLimiterWithClock(global, clock), nil, NewLimiterWithClock(peer, clock)}
+
+	// The slowest limiter decides.
+	want := time.Duration(float64(10*global-global) / global * float64(time.Second))
+	assertDuration(t, transfer(t, clock, 10*global, limiters...), want)
+}
+
+func TestConn(t *testing.T) {
+	const (
+		rate  = 64 * 1024
+		total = 10 * rate
+	)
+	data := make([]byte, total)
+
+	t.Run("Upload", func(t *testing.T) {
+		clock := newFakeClock()
+		start := clock.Now()
+		a, b := net.Pipe()
+		conn := NewConn(a, nil, []*Limiter{NewLimiterWithClock(rate, clock)})
+		defer conn.Close()
+
+		go func() {
+			io.Copy(io.Discard, b)
+		}()
+
+		if n, err := conn.Write(data); err != nil || n != total {
+			t.Fatalf("Write() = %d, %v, want %d, nil", n, err, total)
+		}
+		assertDuration(t, clock.Now().Sub(start), 9*time.Second)
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		clock := newFakeClock()
+		start := clock.Now()
+		a, b := net.Pipe()
+		conn := NewConn(a, []*Limiter{NewLimiterWithClock(rate, clock)}, nil)
+		defer conn.Close()
+
+		go func() {
+			b.Write(data)
+			b.Close()
+		}()
+
+		n, err := io.Copy(io.Discard, conn)
+		if err != nil || n != total {
+			t.Fatalf("Copy() = %d, %v, want %d, nil", n, err, total)
+		}
+		assertDuration(t, clock.Now().Sub(start), 9*time.Second)
+	})
+
+	t.Run("Close stops a waiting write", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		conn := NewConn(a, nil, []*Limiter{NewLimiter(1)})
+
+		errch := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(make([]byte, 1000))
+			errch <- err
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		conn.Close()
+
+		select {
+		case err := <-errch:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Write() error = %v, want %v", err, net.ErrClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Write() is still waiting after Close()")
+		}
+	})
+}
+
+func TestReader(t *testing.T) {
+	const (
+		rate  = 64 * 1024
+		total = 10 * rate
+	)
+	clock := newFakeClock()
+	start := clock.Now()
+	r := NewReader(context.Background(), bytes.NewReader(make([]byte, total)), nil, NewLimiterWithClock(rate, clock))
+
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != total {
+		t.Fatalf("Copy() = %d, %v, want %d, nil", n, err, total)
+	}
+	assertDuration(t, clock.Now().Sub(start), 9*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = NewReader(ctx, bytes.NewReader(make([]byte, total)), NewLimiter(1))
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.Canceled) {
+		t.Errorf("Copy() with a done context error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+)
+
+// Reader is a reader whose reads are limited by every limiter, like the reads of a Conn.
+type Reader struct {
+	r        io.Reader
+	ctx      context.Context
+	limiters []*Limiter
+}
+
+// NewReader limits the reads of r until the context is done, then they return its error.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) *Reader {
+	return &Reader{r: r, ctx: ctx, limiters: limiters}
+}
+
+// Read reads first and then waits for the bytes it got, the sender is slowed down by the TCP window.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), MaxChunk)])
+	if n > 0 {
+		if werr := WaitN(r.ctx, n, r.limiters...); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
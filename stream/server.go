@@ -0,0 +1,142 @@
+// Package stream serves the files of a torrent over HTTP while they are downloaded.
+package stream
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// Server serves every file of the torrent at its path, with Range support, and an index of the
+// files at "/". A read blocks until the pieces it covers are verified, and they are downloaded
+// before the others, so a player can seek in a file that is not downloaded yet.
+type Server struct {
+	client  *p2p.Client
+	log     *slog.Logger
+	mux     *http.ServeMux
+	started time.Time // the modification time of the files
+}
+
+// NewServer returns the server of the files of the client.
+func NewServer(log *slog.Logger, client *p2p.Client) *Server {
+	s := &Server{
+		client:  client,
+		log:     log,
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+	}
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.HandleFunc("GET /{path...}", s.handleFile)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// indexTemplate lists the files with links to them.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>File</th><th>Size</th><th>Done</th></tr>
+{{range .Files}}<tr><td><a href="/{{.Path}}">{{.Path}}</a></td><td>{{.Length}}</td><td>{{printf "%.1f" .Percent}}%</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// indexFile is a row of the index.
+type indexFile struct {
+	Path    string
+	Length  int64
+	Percent float64
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, _ *http.Request) {
+	files := s.client.Files()
+	data := struct {
+		Name  string
+		Files []indexFile
+	}{Name: s.client.Stats().Name}
+	for _, f := range files {
+		data.Files = append(data.Files, indexFile{Path: f.Path, Length: f.Length, Percent: f.Progress * 100})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		s.log.Debug("Failed to write the index", "err", err)
+	}
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("path")
+	index := -1
+	for i, f := range s.client.Files() {
+		if f.Path == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, err := s.client.NewReader(index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	// A read of a piece that is not downloaded blocks, closing the reader stops it when the
+	// request is canceled.
+	stop := context.AfterFunc(r.Context(), func() { reader.Close() })
+	defer stop()
+
+	typ := contentType(name)
+	if typ == "" {
+		typ = "application/octet-stream" // sniffing would wait for the first piece
+	}
+	w.Header().Set("Content-Type", typ)
+	s.log.Debug("Serving a file", "path", name, "range", r.Header.Get("Range"))
+	http.ServeContent(w, r, path.Base(name), s.started, reader)
+}
+
+// mediaTypes are the types of the media files that are not in the types of Go and can be missing
+// from the system.
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".ts":   "video/mp2t",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".srt":  "application/x-subrip",
+	".vtt":  "text/vtt; charset=utf-8",
+}
+
+// contentType returns the type of the file by its extension, or "" if it is unknown.
+func contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
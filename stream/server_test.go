@@ -0,0 +1,170 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/gobittorrent/bencode"
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+func TestServer(t *testing.T) {
+	files := map[string][]byte{
+		"movie.mp4":      make([]byte, 70000),
+		"subs/movie.srt": []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"),
+		"notes":          []byte("no extension"),
+	}
+	if _, err := rand.Read(files["movie.mp4"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are served to the client by a web seed.
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, "root", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer seed.Close()
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	b := bencode.NewBuilder(filepath.Join(dir, "root"))
+	b.Announce = tracker.URL + "/announce"
+	b.URLList = []string{seed.URL + "/"}
+	b.PieceLength = bencode.MinPieceLength
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := bencode.NewTorrent(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := p2p.NewClient(context.Background(), log, torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	srv := httptest.NewServer(NewServer(log, client))
+	defer srv.Close()
+
+	tests := []struct {
+		name            string
+		path            string
+		rangeHeader     string
+		wantStatus      int
+		wantContentType string
+		wantBody        []byte
+	}{
+		{
+			name:            "Video",
+			path:            "/movie.mp4",
+			wantStatus:      http.StatusOK,
+			wantContentType: "video/mp4",
+			wantBody:        files["movie.mp4"],
+		},
+		{
+			name:            "Range",
+			path:            "/movie.mp4",
+			rangeHeader:     "bytes=40000-40099",
+			wantStatus:      http.StatusPartialContent,
+			wantContentType: "video/mp4",
+			wantBody:        files["movie.mp4"][40000:40100],
+		},
+		{
+			name:            "Suffix range",
+			path:            "/movie.mp4",
+			rangeHeader:     "bytes=-10",
+			wantStatus:      http.StatusPartialContent,
+			wantContentType: "video/mp4",
+			wantBody:        files["movie.mp4"][69990:],
+		},
+		{
+			name:            "Nested file",
+			path:            "/subs/movie.srt",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-subrip",
+			wantBody:        files["subs/movie.srt"],
+		},
+		{
+			name:            "Unknown type",
+			path:            "/notes",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/octet-stream",
+			wantBody:        files["notes"],
+		},
+		{
+			name:       "Missing file",
+			path:       "/missing.mp4",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("GET %s Content-Type = %q, want %q", tt.path, got, tt.wantContentType)
+			}
+			if !bytes.Equal(body, tt.wantBody) {
+				t.Errorf("GET %s returned %d bytes that differ from the %d of the file", tt.path, len(body), len(tt.wantBody))
+			}
+		})
+	}
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	index, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`href="/movie.mp4"`, `href="/subs/movie.srt"`, `href="/notes"`, "100.0%"} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("the index does not contain %q:\n%s", want, index)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package tui is the terminal interface of the client: the progress line of downloads and the
+// full-screen view of many torrents.
+package tui
+
+import "github.com/handsomefox/gobittorrent/p2p"
+
+// Backend is what the full-screen view shows and controls, the torrents are identified by
+// their info hash.
+type Backend interface {
+	Torrents() []p2p.Stats
+	Peers(infoHash [20]byte) []p2p.PeerStats
+	Trackers(infoHash [20]byte) [][]string
+	Files(infoHash [20]byte) []p2p.FileStats
+	Pause(infoHash [20]byte) error
+	Resume(infoHash [20]byte) error
+	Remove(infoHash [20]byte) error
+	SetFilePriority(infoHash [20]byte, file int, priority p2p.FilePriority) error
+}
+
+// SessionBackend shows the torrents of a session.
+type SessionBackend struct {
+	Session *p2p.Session
+}
+
+var _ Backend = SessionBackend{}
+
+// Torrents returns the stats of the torrents in the order they were added.
+func (b SessionBackend) Torrents() []p2p.Stats {
+	clients := b.Session.Torrents()
+	stats := make([]p2p.Stats, 0, len(clients))
+	for _, c := range clients {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
+
+func (b SessionBackend) Peers(infoHash [20]byte) []p2p.PeerStats {
+	if c, ok := b.Session.Torrent(infoHash); ok {
+		return c.PeerStats()
+	}
+	return nil
+}
+
+func (b SessionBackend) Trackers(infoHash [20]byte) [][]string {
+	if c, ok := b.Session.Torrent(infoHash); ok {
+		return c.Trackers()
+	}
+	return nil
+}
+
+func (b SessionBackend) Files(infoHash [20]byte) []p2p.FileStats {
+	if c, ok := b.Session.Torrent(infoHash); ok {
+		return c.Files()
+	}
+	return nil
+}
+
+func (b SessionBackend) Pause(infoHash [20]byte) error  { return b.Session.Pause(infoHash) }
+func (b SessionBackend) Resume(infoHash [20]byte) error { return b.Session.Resume(infoHash) }
+func (b SessionBackend) Remove(infoHash [20]byte) error { return b.Session.Remove(infoHash) }
+
+func (b SessionBackend) SetFilePriority(infoHash [20]byte, file int, priority p2p.FilePriority) error {
+	c, ok := b.Session.Torrent(infoHash)
+	if !ok {
+		return p2p.ErrTorrentNotFound
+	}
+	return c.SetFilePriority(file, priority)
+}
@@ -0,0 +1,92 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// FormatBytes formats an amount of bytes with a binary unit, "1.5 MiB".
+func FormatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
+
+// FormatRate formats bytes per second.
+func FormatRate(n float64) string {
+	return FormatBytes(n) + "/s"
+}
+
+// FormatETA formats the estimate of p2p.Stats.ETA, "-" when it is unknown.
+func FormatETA(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
+}
+
+// PieceMap draws the pieces in width cells: "█" when every piece of the cell is done,
+// "▒" when some are and "·" when none are.
+func PieceMap(have []bool, width int) string {
+	if len(have) == 0 {
+		return strings.Repeat("·", width)
+	}
+	width = min(width, len(have))
+
+	var sb strings.Builder
+	for cell := range width {
+		var (
+			from = cell * len(have) / width
+			to   = (cell + 1) * len(have) / width
+			done = 0
+		)
+		for _, ok := range have[from:to] {
+			if ok {
+				done++
+			}
+		}
+		switch {
+		case done == to-from:
+			sb.WriteString("█")
+		case done > 0:
+			sb.WriteString("▒")
+		default:
+			sb.WriteString("·")
+		}
+	}
+	return sb.String()
+}
+
+// ProgressLine formats the stats of a download as a single line with a piece map of width cells.
+func ProgressLine(s *p2p.Stats, width int) string {
+	return fmt.Sprintf("%5.1f%% [%s] %d/%d pieces  ↓ %s  ↑ %s  ETA %s  peers %d (%d seeds)",
+		s.Progress()*100,
+		PieceMap(s.Have, width),
+		s.PiecesDone, s.PiecesTotal,
+		FormatRate(s.DownloadRate), FormatRate(s.UploadRate),
+		FormatETA(s.ETA()),
+		s.Peers, s.Seeds,
+	)
+}
+
+// fit cuts or pads the line to exactly width runes.
+func fit(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		if width <= 1 {
+			return string(runes[:width])
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return line + strings.Repeat(" ", width-len(runes))
+}
@@ -0,0 +1,40 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPieceMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		have  []bool
+		width int
+		want  string
+	}{
+		{name: "Empty torrent", width: 3, want: "···"},
+		{name: "One piece per cell", have: []bool{true, false, true}, width: 4, want: "█·█"},
+		{name: "Two pieces per cell", have: []bool{true, true, true, false, false, false}, width: 3, want: "█▒·"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PieceMap(tt.have, tt.width); got != tt.want {
+				t.Errorf("PieceMap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for n, want := range map[float64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"} {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%v) = %q, want %q", n, got, want)
+		}
+	}
+	if got := FormatETA(-1); got != "-" {
+		t.Errorf("FormatETA(-1) = %q, want %q", got, "-")
+	}
+	if got := FormatETA(time.Second*90 + time.Millisecond*400); got != "1m30s" {
+		t.Errorf("FormatETA() = %q, want %q", got, "1m30s")
+	}
+}
@@ -0,0 +1,263 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// Pane is the tab of the detail pane.
+type Pane int
+
+const (
+	PanePeers Pane = iota
+	PaneTrackers
+	PaneFiles
+	paneCount
+)
+
+func (p Pane) String() string {
+	return [...]string{"Peers", "Trackers", "Files"}[p]
+}
+
+// Key is a key press, a single character or one of the named keys.
+type Key string
+
+const (
+	KeyUp    Key = "up"
+	KeyDown  Key = "down"
+	KeyLeft  Key = "left"
+	KeyRight Key = "right"
+	KeyTab   Key = "tab"
+	KeyEnter Key = "enter"
+	KeyCtrlC Key = "ctrl+c"
+)
+
+// Help lists the keybindings, it is the last line of the view.
+const Help = "j/k select  tab pane  p pause/resume  x remove  [/] file  +/- priority  q quit"
+
+// Model is the state of the full-screen view. It is drawn by View and changed by HandleKey and
+// Refresh, which reads the backend.
+type Model struct {
+	backend Backend
+
+	torrents []p2p.Stats
+	peers    []p2p.PeerStats
+	trackers [][]string
+	files    []p2p.FileStats
+
+	selected int // the torrent
+	file     int // the file in the files pane
+	pane     Pane
+	confirm  bool   // waiting for "y" to remove the selected torrent
+	status   string // the result of the last action
+
+	width, height int
+}
+
+func NewModel(backend Backend) *Model {
+	return &Model{backend: backend, width: 80, height: 24}
+}
+
+// SetSize changes the size of the view in cells.
+func (m *Model) SetSize(width, height int) {
+	m.width, m.height = max(width, 20), max(height, 8)
+}
+
+// Refresh reads the torrents and the details of the selected one from the backend.
+func (m *Model) Refresh() {
+	m.torrents = m.backend.Torrents()
+	m.selected = max(0, min(m.selected, len(m.torrents)-1))
+
+	m.peers, m.trackers, m.files = nil, nil, nil
+	if t, ok := m.current(); ok {
+		m.peers = m.backend.Peers(t.InfoHash)
+		m.trackers = m.backend.Trackers(t.InfoHash)
+		m.files = m.backend.Files(t.InfoHash)
+	}
+	m.file = max(0, min(m.file, len(m.files)-1))
+}
+
+func (m *Model) current() (p2p.Stats, bool) {
+	if len(m.torrents) == 0 {
+		return p2p.Stats{}, false
+	}
+	return m.torrents[m.selected], true
+}
+
+// HandleKey applies the key and refreshes the view, it reports whether the view should close.
+func (m *Model) HandleKey(k Key) (quit bool) {
+	if m.confirm {
+		m.confirm = false
+		m.status = ""
+		if k == "y" {
+			m.act("removed", m.backend.Remove)
+		}
+		m.Refresh()
+		return false
+	}
+
+	switch k {
+	case "q", KeyCtrlC:
+		return true
+	case "j", KeyDown:
+		m.selected = min(m.selected+1, len(m.torrents)-1)
+		m.file = 0
+	case "k", KeyUp:
+		m.selected = max(m.selected-1, 0)
+		m.file = 0
+	case KeyTab, "l", KeyRight:
+		m.pane = (m.pane + 1) % paneCount
+	case "h", KeyLeft:
+		m.pane = (m.pane + paneCount - 1) % paneCount
+	case "p":
+		if t, ok := m.current(); ok && t.Paused {
+			m.act("resumed", m.backend.Resume)
+		} else {
+			m.act("paused", m.backend.Pause)
+		}
+	case "x":
+		if t, ok := m.current(); ok {
+			m.confirm = true
+			m.status = fmt.Sprintf("Remove %s? y/n", t.Name)
+		}
+	case "]":
+		m.pane = PaneFiles
+		m.file = min(m.file+1, len(m.files)-1)
+	case "[":
+		m.pane = PaneFiles
+		m.file = max(m.file-1, 0)
+	case "+", "=":
+		m.setPriority(+1)
+	case "-":
+		m.setPriority(-1)
+	}
+
+	m.Refresh()
+	return false
+}
+
+// act runs the action on the selected torrent and reports the result in the status line.
+func (m *Model) act(done string, action func([20]byte) error) {
+	t, ok := m.current()
+	if !ok {
+		return
+	}
+	if err := action(t.InfoHash); err != nil {
+		m.status = "Error: " + err.Error()
+		return
+	}
+	m.status = fmt.Sprintf("%s %s", t.Name, done)
+}
+
+func (m *Model) setPriority(change p2p.FilePriority) {
+	t, ok := m.current()
+	if !ok || len(m.files) == 0 {
+		return
+	}
+	m.pane = PaneFiles
+
+	f := m.files[m.file]
+	priority := max(p2p.PrioritySkip, min(f.Priority+change, p2p.PriorityHigh))
+	if err := m.backend.SetFilePriority(t.InfoHash, m.file, priority); err != nil {
+		m.status = "Error: " + err.Error()
+		return
+	}
+	m.status = fmt.Sprintf("%s priority %s", f.Path, priority)
+}
+
+// View draws the model in exactly height lines of width cells.
+func (m *Model) View() string {
+	var (
+		lines    []string
+		download float64
+		upload   float64
+	)
+	for _, t := range m.torrents {
+		download += t.DownloadRate
+		upload += t.UploadRate
+	}
+	lines = append(lines, fmt.Sprintf("gobittorrent  %d torrents  ↓ %s  ↑ %s", len(m.torrents), FormatRate(download), FormatRate(upload)))
+
+	// The torrent list takes up to half of the screen, the selected torrent stays visible.
+	rows := max(1, (m.height-4)/2)
+	lines = append(lines, fmt.Sprintf("  %-30s %-11s %6s %12s %12s %7s %8s", "NAME", "STATUS", "DONE", "DOWN", "UP", "PEERS", "ETA"))
+	first := max(0, m.selected-rows+1)
+	for i := first; i < min(first+rows, len(m.torrents)); i++ {
+		t := &m.torrents[i]
+		cursor := "  "
+		if i == m.selected {
+			cursor = "> "
+		}
+		lines = append(lines, fmt.Sprintf("%s%-30s %-11s %5.1f%% %12s %12s %7s %8s",
+			cursor, fit(t.Name, 30), t.Status(), t.Progress()*100, FormatRate(t.DownloadRate), FormatRate(t.UploadRate),
+			fmt.Sprintf("%d (%d)", t.Peers, t.Seeds), FormatETA(t.ETA())))
+	}
+	if len(m.torrents) == 0 {
+		lines = append(lines, "  no torrents")
+	}
+	lines = append(lines, strings.Repeat("─", m.width))
+
+	var tabs []string
+	for p := range paneCount {
+		if p == m.pane {
+			tabs = append(tabs, "["+p.String()+"]")
+		} else {
+			tabs = append(tabs, " "+p.String()+" ")
+		}
+	}
+	lines = append(lines, strings.Join(tabs, " "))
+	lines = append(lines, m.detail()...)
+
+	status := Help
+	if m.status != "" {
+		status = m.status
+	}
+
+	// Keep the status line at the bottom.
+	for len(lines) < m.height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:m.height-1], status)
+
+	for i := range lines {
+		lines[i] = fit(lines[i], m.width)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// detail draws the lines of the current pane.
+func (m *Model) detail() []string {
+	var lines []string
+	switch m.pane {
+	case PanePeers:
+		lines = append(lines, fmt.Sprintf("  %-22s %-20s %-5s %12s %12s %6s", "ADDRESS", "CLIENT", "FLAGS", "DOWN", "UP", "HAS"))
+		for _, p := range m.peers {
+			lines = append(lines, fmt.Sprintf("  %-22s %-20s %-5s %12s %12s %5.1f%%",
+				p.Addr, fit(p.Client.String(), 20), p.Flags, FormatRate(p.DownloadRate), FormatRate(p.UploadRate), p.Progress*100))
+		}
+		if len(m.peers) == 0 {
+			lines = append(lines, "  no peers")
+		}
+	case PaneTrackers:
+		for i, tier := range m.trackers {
+			for _, u := range tier {
+				lines = append(lines, fmt.Sprintf("  tier %d  %s", i, u))
+			}
+		}
+		if len(m.trackers) == 0 {
+			lines = append(lines, "  no trackers")
+		}
+	case PaneFiles:
+		lines = append(lines, fmt.Sprintf("  %-40s %10s %6s %8s", "PATH", "SIZE", "DONE", "PRIORITY"))
+		for i, f := range m.files {
+			cursor := "  "
+			if i == m.file {
+				cursor = "> "
+			}
+			lines = append(lines, fmt.Sprintf("%s%-40s %10s %5.1f%% %8s", cursor, fit(f.Path, 40), FormatBytes(float64(f.Length)), f.Progress*100, f.Priority))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,257 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/gobittorrent/p2p"
+)
+
+// fakeBackend is a Backend with fixed torrents that records the actions.
+type fakeBackend struct {
+	torrents []p2p.Stats
+	peers    []p2p.PeerStats
+	trackers [][]string
+	files    []p2p.FileStats
+	actions  []string
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		torrents: []p2p.Stats{
+			{Name: "debian.iso", InfoHash: [20]byte{1}, PiecesTotal: 4, PiecesDone: 2, Peers: 3, Seeds: 1, DownloadRate: 2048},
+			{Name: "ubuntu.iso", InfoHash: [20]byte{2}, PiecesTotal: 4, PiecesDone: 4, Paused: true},
+		},
+		peers: []p2p.PeerStats{
+			{Addr: "10.0.0.1:6881", Client: p2p.PeerClient{Name: "Transmission", Version: "2.94"}, Flags: "IS", DownloadRate: 1024, Progress: 1},
+		},
+		trackers: [][]string{{"http://tracker.example/announce"}},
+		files: []p2p.FileStats{
+			{Path: "dir/a.txt", Length: 1024},
+			{Path: "dir/b.txt", Length: 2048, Priority: p2p.PriorityHigh},
+		},
+	}
+}
+
+func (b *fakeBackend) torrent(infoHash [20]byte) (*p2p.Stats, error) {
+	for i := range b.torrents {
+		if b.torrents[i].InfoHash == infoHash {
+			return &b.torrents[i], nil
+		}
+	}
+	return nil, p2p.ErrTorrentNotFound
+}
+
+func (b *fakeBackend) record(action string, infoHash [20]byte) (*p2p.Stats, error) {
+	t, err := b.torrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	b.actions = append(b.actions, action+" "+t.Name)
+	return t, nil
+}
+
+func (b *fakeBackend) Torrents() []p2p.Stats                   { return slices.Clone(b.torrents) }
+func (b *fakeBackend) Peers(infoHash [20]byte) []p2p.PeerStats { return b.peers }
+func (b *fakeBackend) Trackers(infoHash [20]byte) [][]string   { return b.trackers }
+func (b *fakeBackend) Files(infoHash [20]byte) []p2p.FileStats { return slices.Clone(b.files) }
+
+func (b *fakeBackend) Pause(infoHash [20]byte) error {
+	t, err := b.record("pause", infoHash)
+	if err == nil {
+		t.Paused = true
+	}
+	return err
+}
+
+func (b *fakeBackend) Resume(infoHash [20]byte) error {
+	t, err := b.record("resume", infoHash)
+	if err == nil {
+		t.Paused = false
+	}
+	return err
+}
+
+func (b *fakeBackend) Remove(infoHash [20]byte) error {
+	t, err := b.record("remove", infoHash)
+	if err == nil {
+		b.torrents = slices.DeleteFunc(b.torrents, func(s p2p.Stats) bool { return s.InfoHash == t.InfoHash })
+	}
+	return err
+}
+
+func (b *fakeBackend) SetFilePriority(infoHash [20]byte, file int, priority p2p.FilePriority) error {
+	if _, err := b.record("priority", infoHash); err != nil {
+		return err
+	}
+	if file < 0 || file >= len(b.files) {
+		return p2p.ErrFileNotFound
+	}
+	b.files[file].Priority = priority
+	return nil
+}
+
+func TestModelKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		actions []string
+		check   func(t *testing.T, b *fakeBackend)
+	}{
+		{name: "Pause", keys: []Key{"p"}, actions: []string{"pause debian.iso"}},
+		{name: "Resume", keys: []Key{"j", "p"}, actions: []string{"resume ubuntu.iso"}},
+		{name: "Arrow keys", keys: []Key{KeyDown, KeyDown, KeyUp, "p"}, actions: []string{"pause debian.iso"}},
+		{
+			name:    "Remove after confirmation",
+			keys:    []Key{"j", "x", "y"},
+			actions: []string{"remove ubuntu.iso"},
+			check: func(t *testing.T, b *fakeBackend) {
+				if len(b.torrents) != 1 {
+					t.Errorf("got %d torrents after remove, want 1", len(b.torrents))
+				}
+			},
+		},
+		{name: "Remove cancelled", keys: []Key{"x", "n"}},
+		{
+			name:    "Raise priority",
+			keys:    []Key{"+"},
+			actions: []string{"priority debian.iso"},
+			check: func(t *testing.T, b *fakeBackend) {
+				if b.files[0].Priority != p2p.PriorityHigh {
+					t.Errorf("priority = %v, want %v", b.files[0].Priority, p2p.PriorityHigh)
+				}
+			},
+		},
+		{
+			name:    "Lower priority of the second file",
+			keys:    []Key{"]", "-", "-"},
+			actions: []string{"priority debian.iso", "priority debian.iso"},
+			check: func(t *testing.T, b *fakeBackend) {
+				if b.files[1].Priority != p2p.PriorityLow {
+					t.Errorf("priority = %v, want %v", b.files[1].Priority, p2p.PriorityLow)
+				}
+			},
+		},
+		{
+			name:    "Skip the second file",
+			keys:    []Key{"]", "-", "-", "-", "-"},
+			actions: []string{"priority debian.iso", "priority debian.iso", "priority debian.iso", "priority debian.iso"},
+			check: func(t *testing.T, b *fakeBackend) {
+				if b.files[1].Priority != p2p.PrioritySkip {
+					t.Errorf("priority = %v, want %v", b.files[1].Priority, p2p.PrioritySkip)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBackend()
+			m := NewModel(b)
+			m.Refresh()
+			for _, k := range tt.keys {
+				if m.HandleKey(k) {
+					t.Fatalf("HandleKey(%q) quit", k)
+				}
+			}
+			if !slices.Equal(b.actions, tt.actions) {
+				t.Errorf("actions = %q, want %q", b.actions, tt.actions)
+			}
+			if tt.check != nil {
+				tt.check(t, b)
+			}
+		})
+	}
+
+	if !NewModel(newFakeBackend()).HandleKey("q") {
+		t.Error(`HandleKey("q") did not quit`)
+	}
+}
+
+func TestModelView(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+		want []string
+	}{
+		{name: "Torrents and peers", want: []string{"debian.iso", "ubuntu.iso", "paused", "50.0%", "[Peers]", "10.0.0.1:6881", "Transmission 2.94", "IS", Help}},
+		{name: "Trackers", keys: []Key{KeyTab}, want: []string{"[Trackers]", "tier 0  http://tracker.example/announce"}},
+		{name: "Files", keys: []Key{KeyTab, KeyTab}, want: []string{"[Files]", "> dir/a.txt", "dir/b.txt", "high"}},
+		{name: "Confirmation", keys: []Key{"x"}, want: []string{"Remove debian.iso? y/n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewModel(newFakeBackend())
+			m.SetSize(100, 20)
+			m.Refresh()
+			for _, k := range tt.keys {
+				m.HandleKey(k)
+			}
+
+			view := m.View()
+			lines := strings.Split(view, "\n")
+			if len(lines) != 20 {
+				t.Errorf("got %d lines, want 20", len(lines))
+			}
+			for i, line := range lines {
+				if n := len([]rune(line)); n != 100 {
+					t.Errorf("line %d is %d cells, want 100", i, n)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(view, want) {
+					t.Errorf("View() does not contain %q:\n%s", want, view)
+				}
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	b := newFakeBackend()
+	// Down arrow, pause the second torrent (it is paused, so it resumes) and quit.
+	in := strings.NewReader("\x1b[Bpq")
+
+	var out strings.Builder
+	err := Run(context.Background(), b, in, &out, func() (int, int) { return 80, 24 })
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if want := []string{"resume ubuntu.iso"}; !slices.Equal(b.actions, want) {
+		t.Errorf("actions = %q, want %q", b.actions, want)
+	}
+	if !strings.HasPrefix(out.String(), enterScreen) || !strings.HasSuffix(out.String(), leaveScreen) {
+		t.Error("Run() did not enter and leave the alternate screen")
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	size := func() (int, int) { return 80, 24 }
+
+	// The input is closed.
+	if err := Run(context.Background(), newFakeBackend(), strings.NewReader(""), io.Discard, size); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	// The context is done while the input blocks.
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := Run(ctx, newFakeBackend(), r, io.Discard, size); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	// The output fails.
+	if err := Run(context.Background(), newFakeBackend(), r, failingWriter{}, size); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Run() error = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
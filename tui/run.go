@@ -0,0 +1,116 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RefreshInterval is the time between redraws of the full-screen view.
+const RefreshInterval = time.Second
+
+// The escape sequences of the terminal.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l" // the alternate screen, without the cursor
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	home        = "\x1b[H"
+)
+
+// Run shows the backend on out and handles the keys read from in until "q" is pressed, in is
+// closed or ctx is done. The terminal must already be in raw mode, size returns its width and
+// height.
+func Run(ctx context.Context, b Backend, in io.Reader, out io.Writer, size func() (int, int)) error {
+	m := NewModel(b)
+	keys := make(chan Key)
+	go readKeys(ctx, in, keys)
+
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+
+	m.Refresh()
+	for {
+		m.SetSize(size())
+		// Raw mode does not translate "\n", the lines start at the first column with "\r\n".
+		if _, err := fmt.Fprint(out, home+strings.ReplaceAll(m.View(), "\n", "\r\n")); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case k, ok := <-keys:
+			if !ok || m.HandleKey(k) {
+				return nil
+			}
+		case <-ticker.C:
+			m.Refresh()
+		}
+	}
+}
+
+// readKeys sends the keys read from in until it fails, then it closes keys.
+func readKeys(ctx context.Context, in io.Reader, keys chan<- Key) {
+	defer close(keys)
+
+	r := bufio.NewReader(in)
+	for {
+		k, err := readKey(r)
+		if err != nil {
+			return
+		}
+		if k == "" {
+			continue
+		}
+		select {
+		case keys <- k:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readKey reads a key, it returns an empty key for the escape sequences it does not know.
+func readKey(r *bufio.Reader) (Key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch c {
+	case 3:
+		return KeyCtrlC, nil
+	case '\t':
+		return KeyTab, nil
+	case '\r', '\n':
+		return KeyEnter, nil
+	case 0x1b:
+		// The arrow keys are "\x1b[A" to "\x1b[D", a lone escape is ignored.
+		if r.Buffered() < 2 {
+			return "", nil
+		}
+		seq := make([]byte, 2)
+		if _, err := io.ReadFull(r, seq); err != nil {
+			return "", err
+		}
+		if seq[0] != '[' && seq[0] != 'O' {
+			return "", nil
+		}
+		switch seq[1] {
+		case 'A':
+			return KeyUp, nil
+		case 'B':
+			return KeyDown, nil
+		case 'C':
+			return KeyRight, nil
+		case 'D':
+			return KeyLeft, nil
+		}
+		return "", nil
+	}
+	return Key(string(c)), nil
+}
@@ -0,0 +1,598 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	recvBufferSize = 1 << 20
+	// maxReorder is how many out of order packets are buffered while waiting for a missing one.
+	maxReorder = 1024
+
+	initialRTO = time.Second
+	minRTO     = 500 * time.Millisecond
+	maxRTO     = 60 * time.Second
+
+	maxSynRetries  = 3
+	maxDataRetries = 8
+	// lingerTimeout is how long a closed connection keeps retransmitting its FIN and unacked data.
+	lingerTimeout = 10 * time.Second
+
+	// Limits for the selective ack bitmask, STATE packets have room for a bigger one.
+	maxDataSelectiveAck  = 4
+	maxStateSelectiveAck = 16
+)
+
+type outPacket struct {
+	p             *packet
+	sentAt        time.Time
+	transmissions int
+	fastResent    bool
+}
+
+// Conn is a uTP connection, it implements net.Conn.
+type Conn struct {
+	s              *Socket
+	raddr          net.Addr
+	recvID, sendID uint16
+	initiator      bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	connected   bool
+	err         error // terminal error, set once the connection is dead
+	localClosed bool
+	closedAt    time.Time
+
+	// Send side.
+	seqNr         uint16 // sequence number of the next packet
+	inflight      []*outPacket
+	inflightBytes int
+	peerWindow    uint32
+	cc            *ledbat
+	lastDelay     uint32
+	rtt, rttVar   time.Duration
+	rto           time.Duration
+	retries       int
+
+	// Receive side.
+	ackNr      uint16 // last packet received in order
+	replyMicro uint32
+	reorder    map[uint16]*packet
+	readBuf    bytes.Buffer
+	eof        bool
+	advertised uint32
+
+	readDeadline, writeDeadline time.Time
+	readTimer, writeTimer       *time.Timer
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func newConn(s *Socket, raddr net.Addr, recvID, sendID uint16) *Conn {
+	c := &Conn{
+		s:          s,
+		raddr:      raddr,
+		recvID:     recvID,
+		sendID:     sendID,
+		peerWindow: recvBufferSize,
+		cc:         newLedbat(),
+		rto:        initialRTO,
+		reorder:    make(map[uint16]*packet),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// connect sends the SYN and waits for the peer to acknowledge it.
+func (c *Conn) connect(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, c.wake)
+	defer stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initiator = true
+	c.seqNr = 1
+	c.queueLocked(stSyn, nil, time.Now())
+
+	for !c.connected {
+		if c.err != nil {
+			return c.err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.cond.Wait()
+	}
+
+	return nil
+}
+
+// accept answers the SYN of an incoming connection.
+func (c *Conn) accept(syn *packet) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return false
+	}
+
+	c.replyMicro = timestampMicro(time.Now()) - syn.Timestamp
+	c.peerWindow = syn.Window
+	c.seqNr = randomUint16()
+	c.ackNr = syn.Seq
+	c.connected = true
+	c.sendStateLocked()
+
+	return true
+}
+
+// reset refuses the connection.
+func (c *Conn) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sendLocked(&packet{Type: stReset, Seq: c.seqNr}, maxDataSelectiveAck)
+	c.failLocked(ErrReset)
+}
+
+// fail marks the connection as dead with the given error.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failLocked(err)
+}
+
+func (c *Conn) failLocked(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.stopTimersLocked()
+	c.cond.Broadcast()
+	c.s.removeConn(c)
+}
+
+func (c *Conn) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// handle processes a packet that was routed to this connection by the socket.
+func (c *Conn) handle(p *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	now := time.Now()
+	c.replyMicro = timestampMicro(now) - p.Timestamp
+	c.peerWindow = p.Window
+
+	switch p.Type {
+	case stReset:
+		c.failLocked(ErrReset)
+		return
+	case stSyn: // Our STATE was lost, repeat it.
+		if !c.initiator {
+			c.sendStateLocked()
+		}
+		return
+	}
+
+	if !c.connected {
+		if p.Type != stState {
+			return
+		}
+		c.connected = true
+		c.ackNr = p.Seq - 1
+	}
+
+	c.processAckLocked(p, now)
+
+	if p.Type == stData || p.Type == stFin {
+		c.receiveLocked(p)
+	}
+
+	c.cond.Broadcast()
+	c.maybeFinishLocked()
+}
+
+func (c *Conn) processAckLocked(p *packet, now time.Time) {
+	if p.TimeDiff != 0 {
+		c.cc.addDelaySample(p.TimeDiff, now)
+		c.lastDelay = p.TimeDiff
+	}
+
+	acked, progress := 0, false
+	for len(c.inflight) > 0 && !seqLess(p.Ack, c.inflight[0].p.Seq) {
+		acked += c.ackedLocked(c.inflight[0], now)
+		c.inflight = c.inflight[1:]
+		progress = true
+	}
+
+	if p.SelectiveAck != nil {
+		kept := c.inflight[:0]
+		for _, op := range c.inflight {
+			if isSelectivelyAcked(p.SelectiveAck, op.p.Seq-(p.Ack+2)) {
+				acked += c.ackedLocked(op, now)
+				progress = true
+				continue
+			}
+			kept = append(kept, op)
+		}
+		clear(c.inflight[len(kept):])
+		c.inflight = kept
+
+		// A packet that three later packets were acked past is considered lost.
+		lost := false
+		for _, op := range c.inflight {
+			if op.fastResent || countSelectiveAcksAfter(p.SelectiveAck, op.p.Seq-(p.Ack+2)) < 3 {
+				continue
+			}
+			op.fastResent = true
+			c.transmitLocked(op, now)
+			lost = true
+		}
+		if lost {
+			c.cc.onLoss()
+		}
+	}
+
+	if progress {
+		// Karn's algorithm skips the samples of retransmitted packets, so undo the backoff here.
+		if c.rtt != 0 {
+			c.rto = min(max(c.rtt+4*c.rttVar, minRTO), maxRTO)
+		}
+		c.retries = 0
+		c.cc.onAck(acked, c.lastDelay)
+	}
+}
+
+func (c *Conn) ackedLocked(op *outPacket, now time.Time) int {
+	if op.transmissions == 1 {
+		c.updateRTTLocked(now.Sub(op.sentAt))
+	}
+	c.inflightBytes -= len(op.p.Payload)
+	return len(op.p.Payload)
+}
+
+func (c *Conn) updateRTTLocked(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = min(max(c.rtt+4*c.rttVar, minRTO), maxRTO)
+}
+
+func (c *Conn) receiveLocked(p *packet) {
+	defer c.sendStateLocked()
+
+	if !seqLess(c.ackNr, p.Seq) { // Duplicate, the peer did not see our ack.
+		return
+	}
+
+	if p.Seq != c.ackNr+1 {
+		if len(c.reorder) < maxReorder {
+			c.reorder[p.Seq] = p
+		}
+		return
+	}
+
+	c.deliverLocked(p)
+	for {
+		next, ok := c.reorder[c.ackNr+1]
+		if !ok {
+			break
+		}
+		delete(c.reorder, next.Seq)
+		c.deliverLocked(next)
+	}
+}
+
+func (c *Conn) deliverLocked(p *packet) {
+	c.ackNr = p.Seq
+	if c.eof {
+		return
+	}
+	if p.Type == stFin {
+		c.eof = true
+		clear(c.reorder)
+		return
+	}
+	if !c.localClosed {
+		c.readBuf.Write(p.Payload)
+	}
+}
+
+// maybeFinishLocked releases the connection once both sides are done with it.
+func (c *Conn) maybeFinishLocked() {
+	if c.localClosed && len(c.inflight) == 0 && c.eof {
+		c.failLocked(net.ErrClosed)
+	}
+}
+
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	if c.localClosed && now.Sub(c.closedAt) > lingerTimeout {
+		c.failLocked(net.ErrClosed)
+		return
+	}
+	if len(c.inflight) == 0 {
+		if c.localClosed {
+			c.failLocked(net.ErrClosed)
+		}
+		return
+	}
+
+	op := c.inflight[0]
+	if now.Sub(op.sentAt) < c.rto {
+		return
+	}
+
+	maxRetries := maxDataRetries
+	if !c.connected {
+		maxRetries = maxSynRetries
+	}
+	c.retries++
+	if c.retries > maxRetries {
+		c.failLocked(ErrTimeout)
+		return
+	}
+
+	c.rto = min(c.rto*2, maxRTO)
+	c.cc.onTimeout()
+	c.transmitLocked(op, now)
+}
+
+// queueLocked assigns the next sequence number to a packet and sends it, keeping it until it is acked.
+func (c *Conn) queueLocked(typ packetType, payload []byte, now time.Time) {
+	op := &outPacket{p: &packet{Type: typ, Seq: c.seqNr, Payload: payload}}
+	c.seqNr++
+	c.inflight = append(c.inflight, op)
+	c.inflightBytes += len(payload)
+	c.transmitLocked(op, now)
+}
+
+func (c *Conn) transmitLocked(op *outPacket, now time.Time) {
+	op.sentAt = now
+	op.transmissions++
+	c.sendLocked(op.p, maxDataSelectiveAck)
+}
+
+func (c *Conn) sendStateLocked() {
+	c.sendLocked(&packet{Type: stState, Seq: c.seqNr}, maxStateSelectiveAck)
+}
+
+func (c *Conn) sendLocked(p *packet, maxSelectiveAck int) {
+	p.ConnID = c.sendID
+	p.Timestamp = timestampMicro(time.Now())
+	p.TimeDiff = c.replyMicro
+	p.Window = c.recvWindowLocked()
+	p.Ack = c.ackNr
+	if p.Type == stSyn {
+		p.ConnID = c.recvID
+		p.Ack = 0
+	}
+	p.SelectiveAck = c.selectiveAckLocked(maxSelectiveAck)
+	c.advertised = p.Window
+
+	// Lost datagrams are recovered by retransmissions, so the error is not interesting here.
+	_ = c.s.send(p, c.raddr)
+}
+
+func (c *Conn) recvWindowLocked() uint32 {
+	return uint32(max(recvBufferSize-c.readBuf.Len(), 0))
+}
+
+// selectiveAckLocked builds the bitmask of the out of order packets, starting at ackNr+2.
+func (c *Conn) selectiveAckLocked(maxBytes int) []byte {
+	if len(c.reorder) == 0 {
+		return nil
+	}
+
+	var mask []byte
+	for seq := range c.reorder {
+		offset := int(seq - (c.ackNr + 2))
+		if offset >= maxBytes*8 {
+			continue
+		}
+		if size := (offset/32 + 1) * 4; size > len(mask) {
+			mask = append(mask, make([]byte, size-len(mask))...)
+		}
+		mask[offset/8] |= 1 << (offset % 8)
+	}
+
+	return mask
+}
+
+func isSelectivelyAcked(mask []byte, offset uint16) bool {
+	i := int(offset)
+	return i < len(mask)*8 && mask[i/8]&(1<<(i%8)) != 0
+}
+
+func countSelectiveAcksAfter(mask []byte, offset uint16) int {
+	count := 0
+	for i := int(offset) + 1; i < len(mask)*8; i++ {
+		if mask[i/8]&(1<<(i%8)) != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *Conn) canSendLocked(size int) bool {
+	if c.inflightBytes == 0 {
+		return true
+	}
+	window := min(int(c.cc.window), int(c.peerWindow))
+	return c.inflightBytes+size <= window
+}
+
+// Read reads the in-order data received from the peer.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.localClosed {
+			return 0, net.ErrClosed
+		}
+		if c.readBuf.Len() > 0 {
+			n, _ := c.readBuf.Read(b)
+			// The peer may be stalled on a small window, let it know there is room again.
+			if c.err == nil && c.advertised < recvBufferSize/4 && c.recvWindowLocked() >= recvBufferSize/4 {
+				c.sendStateLocked()
+			}
+			return n, nil
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if deadlineExceeded(c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.cond.Wait()
+	}
+}
+
+// Write splits b into packets and sends them as the congestion and receive windows allow.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := 0
+	for len(b) > 0 {
+		n := min(len(b), maxPayload)
+		for {
+			if c.localClosed {
+				return total, net.ErrClosed
+			}
+			if c.err != nil {
+				return total, c.err
+			}
+			if deadlineExceeded(c.writeDeadline) {
+				return total, os.ErrDeadlineExceeded
+			}
+			if c.connected && c.canSendLocked(n) {
+				break
+			}
+			c.cond.Wait()
+		}
+
+		c.queueLocked(stData, append(make([]byte, 0, n), b[:n]...), time.Now())
+		b = b[n:]
+		total += n
+	}
+
+	return total, nil
+}
+
+// Close sends a FIN after the pending data, the connection keeps retransmitting in the background until it is acked.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.localClosed {
+		return net.ErrClosed
+	}
+	c.localClosed = true
+	c.closedAt = time.Now()
+	c.readBuf.Reset()
+	c.stopTimersLocked()
+	c.cond.Broadcast()
+
+	if c.err != nil {
+		return nil
+	}
+	if !c.connected {
+		c.failLocked(net.ErrClosed)
+		return nil
+	}
+
+	c.queueLocked(stFin, nil, c.closedAt)
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.s.Addr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.readTimer = c.resetTimerLocked(c.readTimer, t)
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	c.writeTimer = c.resetTimerLocked(c.writeTimer, t)
+	c.cond.Broadcast()
+	return nil
+}
+
+// resetTimerLocked makes sure the waiters get woken up once the deadline passes.
+func (c *Conn) resetTimerLocked(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), c.wake)
+}
+
+func (c *Conn) stopTimersLocked() {
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+	}
+	if c.writeTimer != nil {
+		c.writeTimer.Stop()
+	}
+}
+
+func deadlineExceeded(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
+
+func timestampMicro(t time.Time) uint32 {
+	return uint32(t.UnixMicro())
+}
@@ -0,0 +1,10 @@
+package utp
+
+import "errors"
+
+var (
+	ErrInvalidPacket = errors.New("utp: invalid packet")
+	ErrTimeout       = errors.New("utp: connection timed out")
+	ErrReset         = errors.New("utp: connection reset by peer")
+	ErrSocketClosed  = errors.New("utp: socket is closed")
+)
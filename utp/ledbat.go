@@ -0,0 +1,84 @@
+package utp
+
+import "time"
+
+const (
+	// targetDelay is the queuing delay LEDBAT tries to stay under.
+	targetDelay = 100 * time.Millisecond
+	// maxWindowIncrease is the most the congestion window may grow by within a single RTT.
+	maxWindowIncrease = 3000
+	minWindow         = maxPayload
+	maxWindowSize     = 1 << 20
+
+	delayHistoryLength = 2 // minutes of base delay history to keep
+)
+
+// ledbat is the delay based congestion controller used by uTP.
+type ledbat struct {
+	window float64 // the congestion window in bytes
+
+	// baseDelays holds the lowest delay seen in each of the last minutes,
+	// the minimum of them is the base (uncongested) delay.
+	baseDelays  [delayHistoryLength]uint32
+	baseMinute  int64
+	haveSamples bool
+}
+
+func newLedbat() *ledbat {
+	return &ledbat{window: 2 * minWindow}
+}
+
+// addDelaySample records the one way delay reported by the peer.
+func (l *ledbat) addDelaySample(delay uint32, now time.Time) {
+	minute := now.Unix() / 60
+	if !l.haveSamples {
+		for i := range l.baseDelays {
+			l.baseDelays[i] = delay
+		}
+		l.baseMinute = minute
+		l.haveSamples = true
+		return
+	}
+	if minute != l.baseMinute {
+		copy(l.baseDelays[1:], l.baseDelays[:len(l.baseDelays)-1])
+		l.baseDelays[0] = delay
+		l.baseMinute = minute
+	}
+	if delay < l.baseDelays[0] {
+		l.baseDelays[0] = delay
+	}
+}
+
+func (l *ledbat) baseDelay() uint32 {
+	base := l.baseDelays[0]
+	for _, d := range l.baseDelays[1:] {
+		if d < base {
+			base = d
+		}
+	}
+	return base
+}
+
+// onAck grows or shrinks the window depending on how far the current delay is from the target.
+func (l *ledbat) onAck(ackedBytes int, delay uint32) {
+	if ackedBytes <= 0 || !l.haveSamples {
+		return
+	}
+
+	ourDelay := time.Duration(delay-l.baseDelay()) * time.Microsecond
+	offTarget := float64(targetDelay-ourDelay) / float64(targetDelay)
+	windowFactor := min(float64(ackedBytes), l.window) / max(float64(ackedBytes), l.window)
+
+	l.window += maxWindowIncrease * offTarget * windowFactor
+	l.window = min(max(l.window, minWindow), maxWindowSize)
+}
+
+// onLoss halves the window after a packet was reported lost.
+func (l *ledbat) onLoss() {
+	l.window = max(l.window/2, minWindow)
+}
+
+// onTimeout collapses the window to a single packet.
+func (l *ledbat) onTimeout() {
+	l.window = minWindow
+}
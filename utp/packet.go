@@ -0,0 +1,135 @@
+package utp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type packetType uint8
+
+const (
+	stData packetType = iota
+	stFin
+	stState
+	stReset
+	stSyn
+)
+
+const (
+	protocolVersion = 1
+	headerSize      = 20
+
+	extNone         = 0
+	extSelectiveAck = 1
+)
+
+func (t packetType) String() string {
+	switch t {
+	case stData:
+		return "ST_DATA"
+	case stFin:
+		return "ST_FIN"
+	case stState:
+		return "ST_STATE"
+	case stReset:
+		return "ST_RESET"
+	case stSyn:
+		return "ST_SYN"
+	default:
+		return fmt.Sprintf("ST_UNKNOWN(%d)", uint8(t))
+	}
+}
+
+// packet is a single uTP packet as described in BEP 29.
+type packet struct {
+	Type      packetType
+	ConnID    uint16
+	Timestamp uint32 // sender's clock in microseconds
+	TimeDiff  uint32 // difference between the sender's clock and the timestamp of the last received packet
+	Window    uint32 // advertised receive window in bytes
+	Seq       uint16
+	Ack       uint16
+	// SelectiveAck is a bitmask of received packets starting at Ack+2, nil if the extension is absent.
+	SelectiveAck []byte
+	Payload      []byte
+}
+
+// isPacket reports whether b looks like a uTP packet,
+// which is how the socket tells uTP traffic apart from DHT messages (bencoded dictionaries).
+func isPacket(b []byte) bool {
+	if len(b) < headerSize {
+		return false
+	}
+	return b[0]&0x0f == protocolVersion && packetType(b[0]>>4) <= stSyn
+}
+
+func (p *packet) MarshalBinary() ([]byte, error) {
+	size := headerSize + len(p.Payload)
+	if p.SelectiveAck != nil {
+		size += 2 + len(p.SelectiveAck)
+	}
+
+	buf := make([]byte, size)
+	buf[0] = byte(p.Type)<<4 | protocolVersion
+	if p.SelectiveAck != nil {
+		buf[1] = extSelectiveAck
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.ConnID)
+	binary.BigEndian.PutUint32(buf[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:], p.TimeDiff)
+	binary.BigEndian.PutUint32(buf[12:], p.Window)
+	binary.BigEndian.PutUint16(buf[16:], p.Seq)
+	binary.BigEndian.PutUint16(buf[18:], p.Ack)
+
+	n := headerSize
+	if p.SelectiveAck != nil {
+		buf[n] = extNone // no extension after this one
+		buf[n+1] = byte(len(p.SelectiveAck))
+		n += 2
+		n += copy(buf[n:], p.SelectiveAck)
+	}
+	copy(buf[n:], p.Payload)
+
+	return buf, nil
+}
+
+func (p *packet) UnmarshalBinary(data []byte) error {
+	if !isPacket(data) {
+		return fmt.Errorf("%w: not a uTP packet (size=%d)", ErrInvalidPacket, len(data))
+	}
+
+	p.Type = packetType(data[0] >> 4)
+	p.ConnID = binary.BigEndian.Uint16(data[2:])
+	p.Timestamp = binary.BigEndian.Uint32(data[4:])
+	p.TimeDiff = binary.BigEndian.Uint32(data[8:])
+	p.Window = binary.BigEndian.Uint32(data[12:])
+	p.Seq = binary.BigEndian.Uint16(data[16:])
+	p.Ack = binary.BigEndian.Uint16(data[18:])
+	p.SelectiveAck = nil
+
+	ext, n := data[1], headerSize
+	for ext != extNone {
+		if len(data) < n+2 {
+			return fmt.Errorf("%w: truncated extension header", ErrInvalidPacket)
+		}
+		next, length := data[n], int(data[n+1])
+		n += 2
+		if len(data) < n+length {
+			return fmt.Errorf("%w: truncated extension (type=%d, length=%d)", ErrInvalidPacket, ext, length)
+		}
+		if ext == extSelectiveAck {
+			p.SelectiveAck = append(make([]byte, 0, length), data[n:n+length]...)
+		}
+		ext = next
+		n += length
+	}
+
+	p.Payload = append(make([]byte, 0, len(data)-n), data[n:]...)
+
+	return nil
+}
+
+// seqLess reports whether a comes before b, accounting for the 16-bit wrap-around.
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
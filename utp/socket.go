@@ -0,0 +1,297 @@
+package utp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPacketSize is the largest datagram we send, chosen to fit a typical 1500 byte MTU.
+	maxPacketSize = 1400
+	// maxPayload is the largest data payload that fits into maxPacketSize with a selective ack extension.
+	maxPayload = maxPacketSize - headerSize - 2 - 4
+
+	tickInterval  = 50 * time.Millisecond
+	acceptBacklog = 32
+)
+
+// PacketHandler receives the datagrams that arrive on the socket but are not uTP packets, e.g. DHT messages.
+type PacketHandler func(b []byte, addr net.Addr)
+
+type connKey struct {
+	addr string
+	id   uint16
+}
+
+// Socket multiplexes uTP connections over a single net.PacketConn.
+// It implements net.Listener for the incoming connections.
+type Socket struct {
+	pc net.PacketConn
+
+	conns   map[connKey]*Conn
+	connsMu sync.Mutex
+
+	handler   PacketHandler
+	handlerMu sync.RWMutex
+
+	acceptch chan *Conn
+	quitch   chan struct{}
+	closeErr error
+	once     sync.Once
+}
+
+var _ net.Listener = (*Socket)(nil)
+
+// Listen opens a UDP socket on the given address and starts serving uTP on it.
+func Listen(network, addr string) (*Socket, error) {
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocket(pc), nil
+}
+
+// NewSocket starts serving uTP on an existing packet connection, the socket takes ownership of pc.
+func NewSocket(pc net.PacketConn) *Socket {
+	s := &Socket{
+		pc:       pc,
+		conns:    make(map[connKey]*Conn),
+		acceptch: make(chan *Conn, acceptBacklog),
+		quitch:   make(chan struct{}),
+	}
+
+	go s.readLoop()
+	go s.tickLoop()
+
+	return s
+}
+
+// HandlePackets sets the handler for the non-uTP datagrams, this allows DHT to share the socket.
+func (s *Socket) HandlePackets(h PacketHandler) {
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.handler = h
+}
+
+// WriteTo sends a raw datagram through the socket, it is the counterpart of HandlePackets.
+func (s *Socket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return s.pc.WriteTo(b, addr)
+}
+
+// Addr returns the local address of the socket.
+func (s *Socket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+// Accept waits for and returns the next incoming connection.
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.acceptch:
+		return conn, nil
+	case <-s.quitch:
+		return nil, ErrSocketClosed
+	}
+}
+
+// Close closes the socket and every connection that uses it.
+func (s *Socket) Close() error {
+	s.once.Do(func() {
+		close(s.quitch)
+		s.closeErr = s.pc.Close()
+
+		s.connsMu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, conn := range s.conns {
+			conns = append(conns, conn)
+		}
+		clear(s.conns)
+		s.connsMu.Unlock()
+
+		for _, conn := range conns {
+			conn.fail(ErrSocketClosed)
+		}
+	})
+	return s.closeErr
+}
+
+// Dial resolves the UDP address and connects to it.
+func (s *Socket) Dial(addr string) (*Conn, error) {
+	return s.DialContext(context.Background(), addr)
+}
+
+// DialContext resolves the UDP address and connects to it, giving up once ctx is done.
+func (s *Socket) DialContext(ctx context.Context, addr string) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.DialAddrContext(ctx, raddr)
+}
+
+// DialAddrContext connects to the remote address, giving up once ctx is done.
+func (s *Socket) DialAddrContext(ctx context.Context, raddr net.Addr) (*Conn, error) {
+	conn, err := s.newOutgoingConn(raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.connect(ctx); err != nil {
+		conn.fail(err)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (s *Socket) newOutgoingConn(raddr net.Addr) (*Conn, error) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	select {
+	case <-s.quitch:
+		return nil, ErrSocketClosed
+	default:
+	}
+
+	for {
+		id := randomUint16()
+		key := connKey{addr: raddr.String(), id: id}
+		if _, ok := s.conns[key]; ok {
+			continue
+		}
+		if _, ok := s.conns[connKey{addr: key.addr, id: id + 1}]; ok {
+			continue
+		}
+		conn := newConn(s, raddr, id, id+1)
+		s.conns[key] = conn
+		return conn, nil
+	}
+}
+
+func (s *Socket) removeConn(conn *Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	key := connKey{addr: conn.raddr.String(), id: conn.recvID}
+	if s.conns[key] == conn {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Socket) send(p *packet, addr net.Addr) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = s.pc.WriteTo(b, addr)
+	return err
+}
+
+func (s *Socket) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.Close()
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			s.Close()
+			return
+		}
+
+		b := buf[:n]
+		if !isPacket(b) {
+			s.handlerMu.RLock()
+			h := s.handler
+			s.handlerMu.RUnlock()
+			if h != nil {
+				h(append(make([]byte, 0, n), b...), addr)
+			}
+			continue
+		}
+
+		p := new(packet)
+		if err := p.UnmarshalBinary(b); err != nil {
+			continue
+		}
+		s.dispatch(p, addr)
+	}
+}
+
+// dispatch routes the packet to its connection, creating one for new SYNs.
+func (s *Socket) dispatch(p *packet, addr net.Addr) {
+	s.connsMu.Lock()
+	if p.Type == stSyn {
+		key := connKey{addr: addr.String(), id: p.ConnID + 1}
+		conn, ok := s.conns[key]
+		if !ok {
+			select {
+			case <-s.quitch:
+				s.connsMu.Unlock()
+				return
+			default:
+			}
+			conn = newConn(s, addr, p.ConnID+1, p.ConnID)
+			s.conns[key] = conn
+			s.connsMu.Unlock()
+
+			if !conn.accept(p) {
+				return
+			}
+			select {
+			case s.acceptch <- conn:
+			default: // Backlog is full, refuse the connection.
+				conn.reset()
+			}
+			return
+		}
+		s.connsMu.Unlock()
+		conn.handle(p)
+		return
+	}
+
+	conn, ok := s.conns[connKey{addr: addr.String(), id: p.ConnID}]
+	s.connsMu.Unlock()
+	if ok {
+		conn.handle(p)
+	}
+}
+
+func (s *Socket) tickLoop() {
+	t := time.NewTicker(tickInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			s.connsMu.Lock()
+			conns := make([]*Conn, 0, len(s.conns))
+			for _, conn := range s.conns {
+				conns = append(conns, conn)
+			}
+			s.connsMu.Unlock()
+
+			for _, conn := range conns {
+				conn.tick(now)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+func randomUint16() uint16 {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
@@ -0,0 +1,264 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+type datagram struct {
+	b    []byte
+	from net.Addr
+}
+
+// pipeEnd is one side of an in-memory packet pipe that drops, delays and reorders datagrams.
+type pipeEnd struct {
+	addr     pipeAddr
+	peer     *pipeEnd
+	in       chan datagram
+	loss     float64
+	maxDelay time.Duration
+
+	rndMu sync.Mutex
+	rnd   *mrand.Rand
+
+	quitch chan struct{}
+	once   sync.Once
+}
+
+func newLossyPipe(loss float64, maxDelay time.Duration) (*pipeEnd, *pipeEnd) {
+	newEnd := func(addr pipeAddr, seed uint64) *pipeEnd {
+		return &pipeEnd{
+			addr:     addr,
+			in:       make(chan datagram, 4096),
+			loss:     loss,
+			maxDelay: maxDelay,
+			rnd:      mrand.New(mrand.NewPCG(seed, seed)),
+			quitch:   make(chan struct{}),
+		}
+	}
+	a, b := newEnd("a", 1), newEnd("b", 2)
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (p *pipeEnd) WriteTo(b []byte, _ net.Addr) (int, error) {
+	select {
+	case <-p.quitch:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	p.rndMu.Lock()
+	drop := p.rnd.Float64() < p.loss
+	delay := time.Duration(0)
+	if p.maxDelay > 0 {
+		delay = time.Duration(p.rnd.Int64N(int64(p.maxDelay)))
+	}
+	p.rndMu.Unlock()
+
+	if drop {
+		return len(b), nil
+	}
+
+	d := datagram{b: append([]byte(nil), b...), from: p.addr}
+	time.AfterFunc(delay, func() {
+		select {
+		case p.peer.in <- d:
+		default: // A full queue drops the datagram, like a real network would.
+		}
+	})
+
+	return len(b), nil
+}
+
+func (p *pipeEnd) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case d := <-p.in:
+		return copy(b, d.b), d.from, nil
+	case <-p.quitch:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (p *pipeEnd) Close() error {
+	p.once.Do(func() { close(p.quitch) })
+	return nil
+}
+
+func (p *pipeEnd) LocalAddr() net.Addr              { return p.addr }
+func (p *pipeEnd) SetDeadline(time.Time) error      { return nil }
+func (p *pipeEnd) SetReadDeadline(time.Time) error  { return nil }
+func (p *pipeEnd) SetWriteDeadline(time.Time) error { return nil }
+
+func TestPacketMarshalUnmarshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		input packet
+	}{
+		{name: "SYN", input: packet{Type: stSyn, ConnID: 42, Timestamp: 1, Seq: 1, Window: recvBufferSize, Payload: []byte{}}},
+		{name: "DATA", input: packet{Type: stData, ConnID: 43, Seq: 7, Ack: 3, Payload: []byte("hello")}},
+		{name: "STATE with selective ack", input: packet{
+			Type: stState, ConnID: 43, TimeDiff: 1000, Seq: 7, Ack: 3,
+			SelectiveAck: []byte{0b101, 0, 0, 0x80}, Payload: []byte{},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.input.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+
+			var got packet
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.input) {
+				t.Errorf("roundtrip failed. got = %+v, want %+v", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestTransferOverLossyPipe(t *testing.T) {
+	tests := []struct {
+		name     string
+		loss     float64
+		maxDelay time.Duration
+		reverse  bool
+	}{
+		{name: "Perfect network", loss: 0, maxDelay: 0},
+		{name: "Reordering", loss: 0, maxDelay: 5 * time.Millisecond},
+		{name: "Loss and reordering", loss: 0.05, maxDelay: 5 * time.Millisecond},
+		{name: "Loss and reordering, acceptor sends", loss: 0.05, maxDelay: 5 * time.Millisecond, reverse: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newLossyPipe(tt.loss, tt.maxDelay)
+			dialer, listener := NewSocket(a), NewSocket(b)
+			defer dialer.Close()
+			defer listener.Close()
+
+			want := make([]byte, 256*1024)
+			if _, err := rand.Read(want); err != nil {
+				t.Fatal(err)
+			}
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					t.Error(err)
+				}
+				accepted <- conn
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			dialed, err := dialer.DialAddrContext(ctx, b.LocalAddr())
+			if err != nil {
+				t.Fatalf("DialAddrContext() error = %v", err)
+			}
+
+			var sender, receiver net.Conn = dialed, <-accepted
+			if tt.reverse {
+				sender, receiver = receiver, sender
+			}
+
+			go func() {
+				if _, err := sender.Write(want); err != nil {
+					t.Error(err)
+				}
+				sender.Close()
+			}()
+
+			receiver.SetReadDeadline(time.Now().Add(30 * time.Second))
+			got, err := io.ReadAll(receiver)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			receiver.Close()
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("received data differs, got %d bytes, want %d bytes", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	a, b := newLossyPipe(1, 0)
+	defer b.Close()
+
+	s := NewSocket(a)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.DialAddrContext(ctx, b.LocalAddr()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialAddrContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	a, b := newLossyPipe(0, 0)
+	dialer, listener := NewSocket(a), NewSocket(b)
+	defer dialer.Close()
+	defer listener.Close()
+
+	conn, err := dialer.DialAddrContext(context.Background(), b.LocalAddr())
+	if err != nil {
+		t.Fatalf("DialAddrContext() error = %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestNonUTPPacketsAreHandedOver(t *testing.T) {
+	a, b := newLossyPipe(0, 0)
+	defer a.Close()
+
+	s := NewSocket(b)
+	defer s.Close()
+
+	received := make(chan []byte, 1)
+	s.HandlePackets(func(b []byte, _ net.Addr) { received <- b })
+
+	want := []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe")
+	if _, err := a.WriteTo(want, b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, want) {
+			t.Errorf("handler got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Error("the handler was not called")
+	}
+}